@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	AUDIT_BROKER_SOCKET         = "/var/run/audit_events"
+	AUDIT_BROKER_DEFAULT_BUFFER = 1024
+	AUDIT_BROKER_HELLO_TIMEOUT  = 5 * time.Second
+	AUDIT_BROKER_WRITE_TIMEOUT  = 10 * time.Second
+)
+
+// Status line sent to a subscriber after its filter line, and whenever
+// events had to be dropped because it did not keep up.
+type AuditBrokerNotice struct {
+	Error   string `json:"error,omitempty"`
+	Filter  string `json:"filter,omitempty"`
+	Dropped uint64 `json:"dropped,omitempty"`
+}
+
+type AuditSubscriberStatus struct {
+	Filter    string
+	Since     time.Time
+	Delivered uint64
+	Dropped   uint64
+	Queued    int
+}
+
+type auditSubscriber struct {
+	w     io.Writer
+	query *AuditQuery
+	queue chan []byte
+	since time.Time
+
+	delivered uint64
+	dropped   uint64
+	unsent    uint64 /* drops not yet reported to the subscriber */
+}
+
+// Republishes one event stream on a unix socket to any number of local
+// consumers, so only the broker needs to be the registered audit pid.
+//
+// A subscriber connects and sends one line with a query (see AuditQuery),
+// an empty line for everything. The broker answers with an
+// AuditBrokerNotice and then writes the matching events as JSON lines.
+// Every subscriber has its own bounded queue; when it is full events are
+// dropped for that subscriber only and the count is reported in a notice
+// once it has caught up.
+type AuditBroker struct {
+	BufferSize int
+	Interp     *AuditInterpreter /* optional, fills the interpreted values */
+
+	mu       sync.Mutex
+	listener net.Listener
+	subs     map[*auditSubscriber]bool
+	wg       sync.WaitGroup
+	closed   bool
+}
+
+func NewAuditBroker(bufferSize int, interp *AuditInterpreter) *AuditBroker {
+	if bufferSize <= 0 {
+		bufferSize = AUDIT_BROKER_DEFAULT_BUFFER
+	}
+	return &AuditBroker{
+		BufferSize: bufferSize,
+		Interp:     interp,
+		subs:       make(map[*auditSubscriber]bool),
+	}
+}
+
+// Listens on the unix socket path, replacing a stale socket file, and
+// serves until Close. mode is applied to the socket file.
+func (b *AuditBroker) ListenAndServe(path string, mode os.FileMode) error {
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		l.Close()
+		return err
+	}
+	return b.Serve(l)
+}
+
+// Accepts subscribers on l until Close.
+func (b *AuditBroker) Serve(l net.Listener) error {
+	b.mu.Lock()
+	b.listener = l
+	b.mu.Unlock()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			b.mu.Lock()
+			closed := b.closed
+			b.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+		b.wg.Add(1)
+		go b.handle(conn)
+	}
+}
+
+func writeAuditBrokerNotice(w io.Writer, n AuditBrokerNotice) error {
+	data, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+func (b *AuditBroker) handle(conn net.Conn) {
+	defer b.wg.Done()
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(AUDIT_BROKER_HELLO_TIMEOUT))
+	r := bufio.NewReader(conn)
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+	expr := strings.TrimSpace(line)
+	q, err := ParseAuditQuery(expr)
+	if err != nil {
+		writeAuditBrokerNotice(conn, AuditBrokerNotice{Error: err.Error()})
+		return
+	}
+	q.Interp = b.Interp
+	if err := writeAuditBrokerNotice(conn, AuditBrokerNotice{Filter: expr}); err != nil {
+		return
+	}
+
+	//Subscribers do not talk after the filter line, a read only ends on close
+	gone := make(chan bool)
+	go func() {
+		r.WriteTo(io.Discard)
+		close(gone)
+	}()
+	b.Subscribe(q, conn, gone)
+}
+
+// Writes the events matching q to w as JSON lines until done is closed,
+// a write fails or the broker is closed. Used for the socket subscribers
+// and for in-process consumers. When w is a net.Conn a subscriber that does
+// not take a line within AUDIT_BROKER_WRITE_TIMEOUT is dropped, so it can
+// not hold up Close.
+func (b *AuditBroker) Subscribe(q *AuditQuery, w io.Writer, done <-chan bool) error {
+	sub := &auditSubscriber{
+		w:     w,
+		query: q,
+		queue: make(chan []byte, b.BufferSize),
+		since: time.Now(),
+	}
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.subs[sub] = true
+	b.mu.Unlock()
+
+	err := b.deliver(sub, done)
+
+	b.mu.Lock()
+	delete(b.subs, sub)
+	b.mu.Unlock()
+	return err
+}
+
+func (b *AuditBroker) deliver(sub *auditSubscriber, done <-chan bool) error {
+	conn, _ := sub.w.(net.Conn)
+	for {
+		select {
+		case data, ok := <-sub.queue:
+			if !ok {
+				return nil
+			}
+			if conn != nil {
+				conn.SetWriteDeadline(time.Now().Add(AUDIT_BROKER_WRITE_TIMEOUT))
+			}
+			if _, err := sub.w.Write(data); err != nil {
+				return err
+			}
+			//Drops only happen on a full queue, so once it is empty again
+			//everything queued before the gap has been written
+			b.mu.Lock()
+			sub.delivered++
+			unsent := uint64(0)
+			if len(sub.queue) == 0 {
+				unsent, sub.unsent = sub.unsent, 0
+			}
+			b.mu.Unlock()
+			if unsent > 0 {
+				if err := writeAuditBrokerNotice(sub.w, AuditBrokerNotice{Dropped: unsent}); err != nil {
+					return err
+				}
+			}
+		case <-done:
+			return nil
+		}
+	}
+}
+
+// Hands ev to every subscriber whose filter matches. Never blocks on a
+// slow subscriber.
+func (b *AuditBroker) Publish(ev *AuditEvent) error {
+	out := ev
+	if b.Interp != nil {
+		out = b.Interp.InterpretedCopy(ev)
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(NewAuditJSONEvent(out)); err != nil {
+		return err
+	}
+	data := buf.Bytes()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for sub := range b.subs {
+		if !sub.query.Match(ev) {
+			continue
+		}
+		select {
+		case sub.queue <- data:
+		default:
+			sub.dropped++
+			sub.unsent++
+		}
+	}
+	return nil
+}
+
+func (b *AuditBroker) Subscribers() []AuditSubscriberStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	out := make([]AuditSubscriberStatus, 0, len(b.subs))
+	for sub := range b.subs {
+		out = append(out, AuditSubscriberStatus{
+			Filter:    sub.query.Expr,
+			Since:     sub.since,
+			Delivered: sub.delivered,
+			Dropped:   sub.dropped,
+			Queued:    len(sub.queue),
+		})
+	}
+	return out
+}
+
+// Stops accepting, lets every subscriber drain its queue and disconnects
+// it.
+func (b *AuditBroker) Close() error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	var err error
+	if b.listener != nil {
+		err = b.listener.Close()
+	}
+	for sub := range b.subs {
+		close(sub.queue)
+		delete(b.subs, sub)
+	}
+	b.mu.Unlock()
+	b.wg.Wait()
+	return err
+}
+
+// Publishes every event of evchan until it is closed, then closes the
+// broker.
+func AuditBrokerStream(b *AuditBroker, evchan <-chan *AuditEvent, errchan chan<- error) {
+	defer b.Close()
+	for ev := range evchan {
+		if err := b.Publish(ev); err != nil {
+			errchan <- err
+		}
+	}
+}
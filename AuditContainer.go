@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const AUDIT_CONTAINER_DEFAULT_CACHE = 4096
+
+// Namespaces read from /proc/<pid>/ns.
+var auditNamespaceNames = []string{"cgroup", "ipc", "mnt", "net", "pid", "time", "user", "uts"}
+
+// Container ids as the common runtimes put them into cgroup paths:
+// docker-<id>.scope, cri-containerd-<id>.scope, crio-<id>.scope,
+// libpod-<id>.scope or a bare <id> directory (cgroupfs driver).
+var auditContainerIdRe = regexp.MustCompile(`^(?:(docker|cri-containerd|crio|libpod)-)?([0-9a-f]{64})(?:\.scope)?$`)
+
+var auditContainerRuntimes = map[string]string{
+	"docker":         "docker",
+	"cri-containerd": "containerd",
+	"crio":           "cri-o",
+	"libpod":         "podman",
+}
+
+// Where the process of an event runs.
+type AuditContainerInfo struct {
+	Pid        int
+	StartTime  uint64            /* clock ticks after boot, /proc/<pid>/stat field 22 */
+	Started    time.Time         /* StartTime as wall clock, zero if boot time is unknown */
+	Cgroup     string            /* cgroup v2 path, or the first v1 hierarchy path */
+	Cgroups    map[string]string /* v1 controller list -> path, "" for v2 */
+	Runtime    string            /* docker, containerd, cri-o, podman, lxc or "" */
+	ID         string            /* runtime container id, "" outside containers */
+	Pod        string            /* kubernetes pod uid, if any */
+	Namespaces map[string]uint64 /* namespace inode numbers */
+	ContID     string            /* kernel audit container id (contid=) */
+}
+
+type auditContainerKey struct {
+	pid   int
+	start uint64
+}
+
+// Adds container and cgroup information to events. Lookups are cached
+// per pid and process start time. A process that started after the event
+// is not used for it, its pid was recycled. Safe for concurrent use.
+type AuditContainerEnricher struct {
+	ProcDir    string /* "/proc" */
+	MaxEntries int
+
+	mu       sync.Mutex
+	cache    map[auditContainerKey]*AuditContainerInfo
+	bootTime time.Time
+	bootRead bool
+}
+
+func NewAuditContainerEnricher(maxEntries int) *AuditContainerEnricher {
+	if maxEntries <= 0 {
+		maxEntries = AUDIT_CONTAINER_DEFAULT_CACHE
+	}
+	return &AuditContainerEnricher{
+		ProcDir:    "/proc",
+		MaxEntries: maxEntries,
+		cache:      make(map[auditContainerKey]*AuditContainerInfo),
+	}
+}
+
+// starttime from /proc/<pid>/stat.
+func (e *AuditContainerEnricher) startTime(pid int) (uint64, error) {
+	stat, err := os.ReadFile(filepath.Join(e.ProcDir, strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return 0, err
+	}
+	end := bytes.LastIndexByte(stat, ')')
+	if end < 0 {
+		return 0, os.ErrInvalid
+	}
+	f := strings.Fields(string(stat[end+1:]))
+	if len(f) < 20 {
+		return 0, os.ErrInvalid
+	}
+	return strconv.ParseUint(f[19], 10, 64)
+}
+
+// Returns the container information of a running process.
+func (e *AuditContainerEnricher) Lookup(pid int) (*AuditContainerInfo, error) {
+	start, err := e.startTime(pid)
+	if err != nil {
+		return nil, err
+	}
+	key := auditContainerKey{pid, start}
+	e.mu.Lock()
+	info, ok := e.cache[key]
+	e.mu.Unlock()
+	if ok {
+		return info, nil
+	}
+
+	info, err = e.read(pid)
+	if err != nil {
+		return nil, err
+	}
+	info.StartTime = start
+
+	e.mu.Lock()
+	if !e.bootRead {
+		e.bootTime, e.bootRead = readProcBootTime(e.ProcDir), true
+	}
+	if !e.bootTime.IsZero() {
+		info.Started = e.bootTime.Add(time.Duration(start) * (time.Second / procClockTicks))
+	}
+	full := len(e.cache) >= e.MaxEntries
+	e.mu.Unlock()
+	if full {
+		e.prune()
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.cache) >= e.MaxEntries {
+		e.cache = make(map[auditContainerKey]*AuditContainerInfo)
+	}
+	e.cache[key] = info
+	return info, nil
+}
+
+// Drops entries of processes that are gone. /proc is read without holding
+// mu, Lookups go on meanwhile.
+func (e *AuditContainerEnricher) prune() {
+	e.mu.Lock()
+	keys := make([]auditContainerKey, 0, len(e.cache))
+	for key := range e.cache {
+		keys = append(keys, key)
+	}
+	e.mu.Unlock()
+
+	var gone []auditContainerKey
+	for _, key := range keys {
+		if start, err := e.startTime(key.pid); err != nil || start != key.start {
+			gone = append(gone, key)
+		}
+	}
+
+	e.mu.Lock()
+	for _, key := range gone {
+		delete(e.cache, key)
+	}
+	e.mu.Unlock()
+}
+
+// Forgets the cached entries of pid, e.g. on a process connector exit.
+func (e *AuditContainerEnricher) Forget(pid int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for key := range e.cache {
+		if key.pid == pid {
+			delete(e.cache, key)
+		}
+	}
+}
+
+func (e *AuditContainerEnricher) read(pid int) (*AuditContainerInfo, error) {
+	dir := filepath.Join(e.ProcDir, strconv.Itoa(pid))
+	data, err := os.ReadFile(filepath.Join(dir, "cgroup"))
+	if err != nil {
+		return nil, err
+	}
+	info := &AuditContainerInfo{Pid: pid, Cgroups: make(map[string]string)}
+	//hierarchy-ID:controller-list:cgroup-path, "0::/path" for cgroup v2
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		info.Cgroups[parts[1]] = parts[2]
+		if parts[0] == "0" || info.Cgroup == "" {
+			info.Cgroup = parts[2]
+		}
+	}
+	info.Runtime, info.ID, info.Pod = ParseAuditContainerCgroup(info.Cgroup)
+	for _, p := range info.Cgroups {
+		if info.ID == "" {
+			info.Runtime, info.ID, info.Pod = ParseAuditContainerCgroup(p)
+		}
+	}
+
+	info.Namespaces = make(map[string]uint64)
+	for _, ns := range auditNamespaceNames {
+		link, err := os.Readlink(filepath.Join(dir, "ns", ns))
+		if err != nil {
+			continue
+		}
+		//"net:[4026531992]"
+		open, end := strings.IndexByte(link, '['), strings.IndexByte(link, ']')
+		if open < 0 || end < open {
+			continue
+		}
+		if ino, err := strconv.ParseUint(link[open+1:end], 10, 64); err == nil {
+			info.Namespaces[ns] = ino
+		}
+	}
+	if id, err := os.ReadFile(filepath.Join(dir, "audit_containerid")); err == nil {
+		info.ContID = strings.TrimSpace(string(id))
+	}
+	return info, nil
+}
+
+// Extracts runtime, container id and kubernetes pod uid from a cgroup
+// path, e.g. /kubepods.slice/kubepods-pod<uid>.slice/cri-containerd-<id>.scope.
+func ParseAuditContainerCgroup(path string) (runtime, id, pod string) {
+	parts := strings.Split(path, "/")
+	kube := false
+	for i, p := range parts {
+		//systemd driver: kubepods-burstable-pod<uid>.slice, cgroupfs: kubepods/burstable/pod<uid>
+		if strings.HasPrefix(p, "kubepods") {
+			kube = true
+			if n := strings.Index(p, "-pod"); n >= 0 {
+				pod = strings.ReplaceAll(strings.TrimSuffix(p[n+len("-pod"):], ".slice"), "_", "-")
+			}
+			continue
+		}
+		if kube && strings.HasPrefix(p, "pod") {
+			pod = p[len("pod"):]
+			continue
+		}
+		if strings.HasPrefix(p, "lxc.payload.") {
+			runtime, id = "lxc", p[len("lxc.payload."):]
+			continue
+		}
+		if p == "lxc" && i+1 < len(parts) {
+			runtime, id = "lxc", parts[i+1]
+			continue
+		}
+		m := auditContainerIdRe.FindStringSubmatch(p)
+		if m == nil {
+			continue
+		}
+		id = m[2]
+		runtime = auditContainerRuntimes[m[1]]
+		if runtime == "" && i > 0 && parts[i-1] == "docker" {
+			runtime = "docker"
+		}
+	}
+	return runtime, id, pod
+}
+
+// The kernel's audit container id: the contid= of a CONTAINER_ID or
+// CONTAINER_OP record, or any record carrying one.
+func auditEventContID(ev *AuditEvent) string {
+	if r := ev.Record(AUDIT_CONTAINER_ID); r != nil {
+		if v := r.Value("contid"); v != "" {
+			return v
+		}
+	}
+	return ev.Value("contid")
+}
+
+// Sets ev.Container. The process is looked up by the pid= of the event;
+// if it is gone, or the pid now belongs to a process started after the
+// event, only the contid from the records is filled in.
+func (e *AuditContainerEnricher) Enrich(ev *AuditEvent) {
+	contid := auditEventContID(ev)
+	var info *AuditContainerInfo
+	if pid, err := strconv.Atoi(ev.Value("pid")); err == nil && pid > 0 {
+		//btime has a resolution of one second
+		if cached, err := e.Lookup(pid); err == nil && (cached.Started.IsZero() || ev.Timestamp.IsZero() || !cached.Started.After(ev.Timestamp.Add(time.Second))) {
+			c := *cached
+			info = &c
+		}
+	}
+	if info == nil && contid == "" {
+		return
+	}
+	if info == nil {
+		info = &AuditContainerInfo{}
+	}
+	if contid != "" {
+		info.ContID = contid
+	}
+	ev.Container = info
+}
+
+// Enriches every event of in and passes it on to out, which is closed
+// once in is closed.
+func AuditContainerStream(e *AuditContainerEnricher, in <-chan *AuditEvent, out chan<- *AuditEvent) {
+	defer close(out)
+	for ev := range in {
+		e.Enrich(ev)
+		out <- ev
+	}
+}
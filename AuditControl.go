@@ -0,0 +1,391 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"syscall"
+)
+
+const (
+	AUDIT_CONTROL_SOCKET   = "/var/run/audit_control"
+	AUDIT_CONTROL_MAX_BODY = 1 << 20
+)
+
+// Status as the control API shows it.
+type AuditControlStatus struct {
+	Enabled      uint32 `json:"enabled"`
+	Failure      uint32 `json:"failure"`
+	Pid          uint32 `json:"pid"`
+	RateLimit    uint32 `json:"rate_limit"`
+	BacklogLimit uint32 `json:"backlog_limit"`
+	Lost         uint32 `json:"lost"`
+	Backlog      uint32 `json:"backlog"`
+}
+
+// Body of a status update, only the fields present are changed.
+type AuditControlSet struct {
+	Enabled      *uint32 `json:"enabled,omitempty"`
+	Failure      *uint32 `json:"failure,omitempty"`
+	Pid          *uint32 `json:"pid,omitempty"`
+	RateLimit    *uint32 `json:"rate_limit,omitempty"`
+	BacklogLimit *uint32 `json:"backlog_limit,omitempty"`
+}
+
+type AuditControlRule struct {
+	*AuditRule
+	Text string `json:"text"`
+}
+
+type AuditControlReconcile struct {
+	Added   []AuditControlRule `json:"added"`
+	Deleted []AuditControlRule `json:"deleted"`
+}
+
+type auditControlPeerKey struct{}
+
+// Local control plane over HTTP with JSON bodies:
+//
+//	GET    /v1/status             AuditControlStatus
+//	POST   /v1/status             AuditControlSet, answers the new status
+//	GET    /v1/rules              []AuditControlRule
+//	POST   /v1/rules              AuditRule, adds it
+//	DELETE /v1/rules              AuditRule, deletes it
+//	PUT    /v1/rules              []AuditRule, reconciles, answers AuditControlReconcile
+//	GET    /v1/events?filter=...  JSON lines from the broker until the client leaves
+//
+// Clients on the unix socket are authenticated by their SO_PEERCRED uid,
+// TCP clients by a client certificate checked against the TLS config.
+// There is deliberately no gRPC flavour: it would pull in grpc and protobuf
+// for what curl and encoding/json already cover.
+type AuditControlServer struct {
+	Socket     NetlinkTransport /* control socket, not the one registered with AuditSetPid */
+	Broker     *AuditBroker     /* event source of /v1/events, nil disables it */
+	AllowUids  []uint32         /* unix socket peers, root only if empty */
+	AllowNames []string         /* client certificate common names, any verified one if empty */
+
+	mu     sync.Mutex /* one netlink exchange at a time */
+	server *http.Server
+}
+
+func NewAuditControlServer(s NetlinkTransport, broker *AuditBroker) *AuditControlServer {
+	c := &AuditControlServer{Socket: s, Broker: broker}
+	c.server = &http.Server{Handler: c.Handler(), ConnContext: auditControlConnContext}
+	return c
+}
+
+// Remembers the peer credentials of unix socket connections.
+func auditControlConnContext(ctx context.Context, conn net.Conn) context.Context {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return ctx
+	}
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return ctx
+	}
+	var cred *syscall.Ucred
+	raw.Control(func(fd uintptr) {
+		cred, err = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, auditControlPeerKey{}, cred)
+}
+
+func (c *AuditControlServer) authorized(r *http.Request) bool {
+	if cred, ok := r.Context().Value(auditControlPeerKey{}).(*syscall.Ucred); ok {
+		if len(c.AllowUids) == 0 {
+			return cred.Uid == 0
+		}
+		for _, uid := range c.AllowUids {
+			if cred.Uid == uid {
+				return true
+			}
+		}
+		return false
+	}
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return false
+	}
+	if len(c.AllowNames) == 0 {
+		return true
+	}
+	cn := r.TLS.VerifiedChains[0][0].Subject.CommonName
+	for _, name := range c.AllowNames {
+		if cn == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *AuditControlServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/status", c.handleStatus)
+	mux.HandleFunc("/v1/rules", c.handleRules)
+	mux.HandleFunc("/v1/events", c.handleEvents)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !c.authorized(r) {
+			writeAuditControlError(w, http.StatusForbidden, errors.New("permission denied"))
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func writeAuditControlJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeAuditControlError(w http.ResponseWriter, code int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
+
+// Netlink errors: EPERM and friends keep their meaning, anything else is
+// a server side failure.
+func auditControlErrorCode(err error) int {
+	switch err {
+	case syscall.EPERM, syscall.EACCES:
+		return http.StatusForbidden
+	case syscall.EEXIST:
+		return http.StatusConflict
+	case syscall.ENOENT:
+		return http.StatusNotFound
+	case syscall.EINVAL:
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+func readAuditControlBody(r *http.Request, v interface{}) error {
+	dec := json.NewDecoder(http.MaxBytesReader(nil, r.Body, AUDIT_CONTROL_MAX_BODY))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+func (c *AuditControlServer) status() (*AuditControlStatus, error) {
+	c.mu.Lock()
+	st, err := AuditGetStatus(c.Socket)
+	c.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return &AuditControlStatus{
+		Enabled:      st.Enabled,
+		Failure:      st.Failure,
+		Pid:          st.Pid,
+		RateLimit:    st.Rate_limit,
+		BacklogLimit: st.Backlog_limit,
+		Lost:         st.Lost,
+		Backlog:      st.Backlog,
+	}, nil
+}
+
+func (c *AuditControlServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var set AuditControlSet
+		if err := readAuditControlBody(r, &set); err != nil {
+			writeAuditControlError(w, http.StatusBadRequest, err)
+			return
+		}
+		var st AuditStatus
+		for _, f := range []struct {
+			v    *uint32
+			dst  *uint32
+			mask uint32
+		}{
+			{set.Enabled, &st.Enabled, AUDIT_STATUS_ENABLED},
+			{set.Failure, &st.Failure, AUDIT_STATUS_FAILURE},
+			{set.Pid, &st.Pid, AUDIT_STATUS_PID},
+			{set.RateLimit, &st.Rate_limit, AUDIT_STATUS_RATE_LIMIT},
+			{set.BacklogLimit, &st.Backlog_limit, AUDIT_STATUS_BACKLOG_LIMIT},
+		} {
+			if f.v != nil {
+				*f.dst = *f.v
+				st.Mask |= f.mask
+			}
+		}
+		if st.Mask != 0 {
+			c.mu.Lock()
+			err := AuditSetStatus(c.Socket, &st)
+			c.mu.Unlock()
+			if err != nil {
+				writeAuditControlError(w, auditControlErrorCode(err), err)
+				return
+			}
+		}
+	default:
+		writeAuditControlError(w, http.StatusMethodNotAllowed, errors.New("use GET or POST"))
+		return
+	}
+	st, err := c.status()
+	if err != nil {
+		writeAuditControlError(w, auditControlErrorCode(err), err)
+		return
+	}
+	writeAuditControlJSON(w, st)
+}
+
+func newAuditControlRules(rules []*AuditRule) []AuditControlRule {
+	out := make([]AuditControlRule, 0, len(rules))
+	for _, r := range rules {
+		out = append(out, AuditControlRule{r, r.String()})
+	}
+	return out
+}
+
+func (c *AuditControlServer) handleRules(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		c.mu.Lock()
+		rules, err := AuditListRules(c.Socket)
+		c.mu.Unlock()
+		if err != nil {
+			writeAuditControlError(w, auditControlErrorCode(err), err)
+			return
+		}
+		writeAuditControlJSON(w, newAuditControlRules(rules))
+	case http.MethodPost, http.MethodDelete:
+		var rule AuditRule
+		if err := readAuditControlBody(r, &rule); err != nil {
+			writeAuditControlError(w, http.StatusBadRequest, err)
+			return
+		}
+		canon, err := rule.Canonical()
+		if err != nil {
+			writeAuditControlError(w, http.StatusBadRequest, err)
+			return
+		}
+		c.mu.Lock()
+		if r.Method == http.MethodPost {
+			err = AuditAddRule(c.Socket, canon)
+		} else {
+			err = AuditDeleteRule(c.Socket, canon)
+		}
+		c.mu.Unlock()
+		if err != nil {
+			writeAuditControlError(w, auditControlErrorCode(err), err)
+			return
+		}
+		writeAuditControlJSON(w, AuditControlRule{canon, canon.String()})
+	case http.MethodPut:
+		var want []*AuditRule
+		if err := readAuditControlBody(r, &want); err != nil {
+			writeAuditControlError(w, http.StatusBadRequest, err)
+			return
+		}
+		for _, rule := range want {
+			if _, err := rule.Canonical(); err != nil {
+				writeAuditControlError(w, http.StatusBadRequest, err)
+				return
+			}
+		}
+		c.mu.Lock()
+		added, deleted, err := AuditReconcileRules(c.Socket, want)
+		c.mu.Unlock()
+		if err != nil {
+			writeAuditControlError(w, auditControlErrorCode(err), err)
+			return
+		}
+		writeAuditControlJSON(w, AuditControlReconcile{newAuditControlRules(added), newAuditControlRules(deleted)})
+	default:
+		writeAuditControlError(w, http.StatusMethodNotAllowed, errors.New("use GET, POST, DELETE or PUT"))
+	}
+}
+
+// Flushes every write so events reach the client as they come.
+type auditControlFlushWriter struct {
+	w http.ResponseWriter
+	f http.Flusher
+}
+
+func (fw auditControlFlushWriter) Write(p []byte) (int, error) {
+	n, err := fw.w.Write(p)
+	fw.f.Flush()
+	return n, err
+}
+
+func (c *AuditControlServer) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAuditControlError(w, http.StatusMethodNotAllowed, errors.New("use GET"))
+		return
+	}
+	if c.Broker == nil {
+		writeAuditControlError(w, http.StatusNotFound, errors.New("event streaming is disabled"))
+		return
+	}
+	q, err := ParseAuditQuery(r.URL.Query().Get("filter"))
+	if err != nil {
+		writeAuditControlError(w, http.StatusBadRequest, err)
+		return
+	}
+	q.Interp = c.Broker.Interp
+	f, ok := w.(http.Flusher)
+	if !ok {
+		writeAuditControlError(w, http.StatusInternalServerError, errors.New("streaming unsupported"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	f.Flush()
+
+	done := make(chan bool)
+	go func() {
+		<-r.Context().Done()
+		close(done)
+	}()
+	c.Broker.Subscribe(q, auditControlFlushWriter{w, f}, done)
+}
+
+// Serves on a unix socket at path, replacing a stale socket file.
+func (c *AuditControlServer) ServeUnix(path string, mode os.FileMode) error {
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		l.Close()
+		return err
+	}
+	return c.Serve(l)
+}
+
+// Serves HTTPS on addr. Client certificates are always required and
+// verified against config.ClientCAs.
+func (c *AuditControlServer) ServeTLS(addr string, config *tls.Config) error {
+	config = config.Clone()
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return c.Serve(tls.NewListener(l, config))
+}
+
+// Serves on l until Close. May be called for several listeners.
+func (c *AuditControlServer) Serve(l net.Listener) error {
+	err := c.server.Serve(l)
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+func (c *AuditControlServer) Close() error {
+	return c.server.Close()
+}
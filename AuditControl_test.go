@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Serves c on a unix socket in a temporary directory and returns an HTTP
+// client for it. Our own uid is allowed.
+func serveAuditTestControl(t *testing.T, c *AuditControlServer) *http.Client {
+	c.AllowUids = []uint32{uint32(os.Getuid())}
+
+	path := filepath.Join(t.TempDir(), "control")
+	go c.ServeUnix(path, 0600)
+	t.Cleanup(func() { c.Close() })
+	waitAuditTest(t, "the control socket", func() bool {
+		_, err := os.Stat(path)
+		return err == nil
+	})
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	return client
+}
+
+// Sends body as JSON and decodes the answer into out, returning the status code.
+func doAuditTestControl(t *testing.T, client *http.Client, method, url string, body, out interface{}) int {
+	t.Helper()
+	var b bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&b).Encode(body)
+	}
+	req, err := http.NewRequest(method, url, &b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if out != nil && resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// Requests that get past authentication fail on the bad filter instead.
+const auditTestControlBadFilter = "/v1/events?filter=key%3D"
+
+func TestAuditControlPeerCred(t *testing.T) {
+	c := NewAuditControlServer(nil, NewAuditBroker(0, nil))
+	client := serveAuditTestControl(t, c)
+	if code := doAuditTestControl(t, client, "GET", "http://control"+auditTestControlBadFilter, nil, nil); code != http.StatusBadRequest {
+		t.Fatalf("allowed uid: got %d", code)
+	}
+	c.AllowUids = []uint32{uint32(os.Getuid()) + 1}
+	if code := doAuditTestControl(t, client, "GET", "http://control"+auditTestControlBadFilter, nil, nil); code != http.StatusForbidden {
+		t.Fatalf("other uid: got %d", code)
+	}
+}
+
+func TestAuditControlEvents(t *testing.T) {
+	broker := NewAuditBroker(0, nil)
+	client := serveAuditTestControl(t, NewAuditControlServer(nil, broker))
+
+	if code := doAuditTestControl(t, client, "POST", "http://control/v1/events", nil, nil); code != http.StatusMethodNotAllowed {
+		t.Fatalf("POST: got %d", code)
+	}
+	if code := doAuditTestControl(t, client, "GET", "http://control"+auditTestControlBadFilter, nil, nil); code != http.StatusBadRequest {
+		t.Fatalf("bad filter: got %d", code)
+	}
+	resp, err := client.Get("http://control/v1/events?filter=key%3Dexec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	waitAuditTest(t, "the subscription", func() bool { return len(broker.Subscribers()) == 1 })
+
+	for serial, key := range []string{"other", "exec"} {
+		r := parseAuditTestRecord(t, AUDIT_SYSCALL, "audit(1.000:1): syscall=59 key=\""+key+"\"")
+		broker.Publish(&AuditEvent{Serial: uint64(serial), Records: []*AuditRecord{r}})
+	}
+	var ev AuditJSONEvent
+	line, err := bufio.NewReader(resp.Body).ReadBytes('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(line, &ev); err != nil || ev.Serial != 1 {
+		t.Fatalf("got %s: %v", line, err)
+	}
+}
+
+func newAuditTestCert(t *testing.T, cn string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, tls.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		tmpl.IsCA, tmpl.BasicConstraintsValid = true, true
+		tmpl.KeyUsage = x509.KeyUsageCertSign
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key, tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestAuditControlTLS(t *testing.T) {
+	ca, caKey, _ := newAuditTestCert(t, "ca", nil, nil)
+	_, _, server := newAuditTestCert(t, "server", ca, caKey)
+	_, _, admin := newAuditTestCert(t, "admin", ca, caKey)
+	_, _, other := newAuditTestCert(t, "other", ca, caKey)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	c := NewAuditControlServer(nil, NewAuditBroker(0, nil))
+	c.AllowNames = []string{"admin"}
+	defer c.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	go c.ServeTLS(addr, &tls.Config{Certificates: []tls.Certificate{server}, ClientCAs: pool})
+
+	get := func(certs ...tls.Certificate) (int, error) {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool, Certificates: certs}}}
+		resp, err := client.Get("https://" + addr + auditTestControlBadFilter)
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		return resp.StatusCode, nil
+	}
+	waitAuditTest(t, "the TLS listener", func() bool {
+		_, err := get(admin)
+		return err == nil
+	})
+	if code, err := get(admin); code != http.StatusBadRequest {
+		t.Fatalf("admin: got %d %v", code, err)
+	}
+	if code, err := get(other); code != http.StatusForbidden {
+		t.Fatalf("other: got %d %v", code, err)
+	}
+	if _, err := get(); err == nil {
+		t.Fatal("no client certificate: request succeeded")
+	}
+}
+
+func TestAuditControlStatus(t *testing.T) {
+	k := NewAuditFakeKernel()
+	s := k.Dial()
+	defer s.Close()
+	client := serveAuditTestControl(t, NewAuditControlServer(s, nil))
+	var st AuditControlStatus
+	if code := doAuditTestControl(t, client, "GET", "http://control/v1/status", nil, &st); code != http.StatusOK || st.Enabled != 1 {
+		t.Fatalf("got %d %+v", code, st)
+	}
+
+	limit := uint32(100)
+	if code := doAuditTestControl(t, client, "POST", "http://control/v1/status", AuditControlSet{RateLimit: &limit}, &st); code != http.StatusOK || st.RateLimit != 100 {
+		t.Fatalf("got %d %+v", code, st)
+	}
+	if k.Status().Rate_limit != 100 {
+		t.Fatal("rate limit not set in the kernel")
+	}
+
+	bad := uint32(3)
+	if code := doAuditTestControl(t, client, "POST", "http://control/v1/status", AuditControlSet{Enabled: &bad}, nil); code != http.StatusBadRequest {
+		t.Fatalf("enabled=3: got %d", code)
+	}
+	if code := doAuditTestControl(t, client, "POST", "http://control/v1/status", map[string]int{"nope": 1}, nil); code != http.StatusBadRequest {
+		t.Fatalf("unknown field: got %d", code)
+	}
+	if code := doAuditTestControl(t, client, "PATCH", "http://control/v1/status", nil, nil); code != http.StatusMethodNotAllowed {
+		t.Fatalf("PATCH: got %d", code)
+	}
+}
+
+func TestAuditControlRules(t *testing.T) {
+	k := NewAuditFakeKernel()
+	s := k.Dial()
+	defer s.Close()
+	client := serveAuditTestControl(t, NewAuditControlServer(s, nil))
+	exec := &AuditRule{List: "exit", Action: "always", Syscalls: []string{"execve"}, Fields: []AuditRuleField{
+		{Name: "arch", Op: "=", Value: "b64"},
+		{Name: "key", Op: "=", Value: "exec"},
+	}}
+	open := &AuditRule{List: "exit", Action: "always", Syscalls: []string{"open"}, Fields: []AuditRuleField{
+		{Name: "arch", Op: "=", Value: "b64"},
+	}}
+
+	if code := doAuditTestControl(t, client, "POST", "http://control/v1/rules", exec, nil); code != http.StatusOK {
+		t.Fatalf("add: got %d", code)
+	}
+	if code := doAuditTestControl(t, client, "POST", "http://control/v1/rules", exec, nil); code != http.StatusConflict {
+		t.Fatalf("second add: got %d", code)
+	}
+	var rules []AuditControlRule
+	if code := doAuditTestControl(t, client, "GET", "http://control/v1/rules", nil, &rules); code != http.StatusOK || len(rules) != 1 {
+		t.Fatalf("list: got %d %v", code, rules)
+	}
+	want, _ := exec.Canonical()
+	if rules[0].Text != want.String() {
+		t.Fatalf("got %q, want %q", rules[0].Text, want.String())
+	}
+
+	var rec AuditControlReconcile
+	if code := doAuditTestControl(t, client, "PUT", "http://control/v1/rules", []*AuditRule{open}, &rec); code != http.StatusOK {
+		t.Fatalf("reconcile: got %d", code)
+	}
+	if len(rec.Added) != 1 || len(rec.Deleted) != 1 || len(k.Rules()) != 1 {
+		t.Fatalf("got %+v, kernel has %d rules", rec, len(k.Rules()))
+	}
+
+	if code := doAuditTestControl(t, client, "DELETE", "http://control/v1/rules", open, nil); code != http.StatusOK {
+		t.Fatalf("delete: got %d", code)
+	}
+	if code := doAuditTestControl(t, client, "DELETE", "http://control/v1/rules", open, nil); code != http.StatusNotFound {
+		t.Fatalf("second delete: got %d", code)
+	}
+	if code := doAuditTestControl(t, client, "POST", "http://control/v1/rules", &AuditRule{List: "nope", Action: "always"}, nil); code != http.StatusBadRequest {
+		t.Fatalf("bad rule: got %d", code)
+	}
+}
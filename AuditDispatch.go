@@ -0,0 +1,440 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	AUDISP_PLUGIN_DIR = "/etc/audit/plugins.d"
+
+	/* format = */
+	AUDISP_FORMAT_STRING = 0 /* one audit.log style line per record */
+	AUDISP_FORMAT_BINARY = 1 /* struct audit_dispatcher_header + message */
+
+	AUDISP_PROTOCOL_VER = 0
+	AUDISP_HEADER_LEN   = 16 /* ver, hlen, type, size */
+
+	/* overflow_action = (auditd.conf), when a plugin queue is full */
+	AUDISP_OVERFLOW_IGNORE  = 0 /* drop the message */
+	AUDISP_OVERFLOW_SYSLOG  = 1 /* drop the message and syslog it, once per overflow */
+	AUDISP_OVERFLOW_SUSPEND = 2 /* stop dispatching until Resume */
+	AUDISP_OVERFLOW_HALT    = 3 /* stop dispatching for good and call OnHalt */
+
+	AUDISP_DEFAULT_QUEUE_DEPTH = 2000 /* q_depth */
+	AUDISP_MIN_BACKOFF         = time.Second
+	AUDISP_MAX_BACKOFF         = 5 * time.Minute
+	AUDISP_STOP_TIMEOUT        = 5 * time.Second
+)
+
+// One plugins.d/*.conf file.
+type AuditPluginConfig struct {
+	Name      string /* file name without .conf */
+	Active    bool
+	Direction string /* only "out" is supported */
+	Path      string
+	Type      string /* "always" plugins are spawned, "builtin" ones are not */
+	Args      []string
+	Format    int
+}
+
+// Parses a plugin configuration in the audispd "key = value" format.
+func ParseAuditPluginConfig(name string, r io.Reader) (*AuditPluginConfig, error) {
+	cfg := &AuditPluginConfig{Name: name, Direction: "out", Type: "always", Format: AUDISP_FORMAT_STRING}
+	sc := bufio.NewScanner(r)
+	line := 0
+	for sc.Scan() {
+		line++
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || text[0] == '#' {
+			continue
+		}
+		eq := strings.IndexByte(text, '=')
+		if eq < 0 {
+			return nil, fmt.Errorf("%s:%d: missing =", name, line)
+		}
+		key, value := strings.TrimSpace(text[:eq]), strings.TrimSpace(text[eq+1:])
+		switch key {
+		case "active":
+			cfg.Active = value == "yes"
+		case "direction":
+			cfg.Direction = value
+		case "path":
+			cfg.Path = value
+		case "type":
+			cfg.Type = value
+		case "args":
+			cfg.Args = strings.Fields(value)
+		case "format":
+			switch value {
+			case "string":
+				cfg.Format = AUDISP_FORMAT_STRING
+			case "binary":
+				cfg.Format = AUDISP_FORMAT_BINARY
+			default:
+				return nil, fmt.Errorf("%s:%d: unknown format %q", name, line, value)
+			}
+		default:
+			//Unknown keys are ignored, like audispd does
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if cfg.Active && cfg.Type == "always" && cfg.Path == "" {
+		return nil, fmt.Errorf("%s: active plugin without path", name)
+	}
+	return cfg, nil
+}
+
+// Reads every *.conf file of dir, sorted by name.
+func LoadAuditPluginConfigs(dir string) ([]*AuditPluginConfig, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.conf"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	var cfgs []*AuditPluginConfig
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		cfg, err := ParseAuditPluginConfig(strings.TrimSuffix(filepath.Base(file), ".conf"), f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		cfgs = append(cfgs, cfg)
+	}
+	return cfgs, nil
+}
+
+// Snapshot of one plugin for status displays.
+type AuditPluginStatus struct {
+	Name     string
+	Pid      int /* 0 while not running */
+	Restarts uint64
+	Dropped  uint64
+	Queued   int
+}
+
+type auditPlugin struct {
+	cfg   *AuditPluginConfig
+	queue chan []byte
+
+	mu       sync.Mutex
+	pid      int
+	restarts uint64
+	dropped  uint64
+	overflow bool
+}
+
+// Feeds messages to plugin processes on their stdin, like audispd. Each
+// plugin has its own queue so a slow one does not hold back the others;
+// one that stops reading fills its queue and gets the Overflow policy.
+// Crashed plugins are restarted with exponential backoff.
+type AuditDispatcher struct {
+	QueueDepth  int
+	Overflow    int
+	OnHalt      func()
+	MinBackoff  time.Duration
+	MaxBackoff  time.Duration
+	StopTimeout time.Duration /* how long Stop waits for a plugin before killing it */
+
+	plugins []*auditPlugin
+	mu      sync.Mutex
+	stopped bool
+	paused  bool
+	halted  bool
+	done    chan bool
+	wg      sync.WaitGroup
+}
+
+// Only active, outgoing, non builtin plugins are dispatched to.
+func NewAuditDispatcher(cfgs []*AuditPluginConfig, queueDepth int, overflow int) *AuditDispatcher {
+	if queueDepth <= 0 {
+		queueDepth = AUDISP_DEFAULT_QUEUE_DEPTH
+	}
+	d := &AuditDispatcher{
+		QueueDepth:  queueDepth,
+		Overflow:    overflow,
+		MinBackoff:  AUDISP_MIN_BACKOFF,
+		MaxBackoff:  AUDISP_MAX_BACKOFF,
+		StopTimeout: AUDISP_STOP_TIMEOUT,
+		done:        make(chan bool),
+	}
+	for _, cfg := range cfgs {
+		if !cfg.Active || cfg.Direction != "out" || cfg.Type != "always" {
+			continue
+		}
+		d.plugins = append(d.plugins, &auditPlugin{cfg: cfg, queue: make(chan []byte, queueDepth)})
+	}
+	return d
+}
+
+// Spawns the plugins. Each one is supervised until Stop.
+func (d *AuditDispatcher) Start() {
+	for _, p := range d.plugins {
+		d.wg.Add(1)
+		go d.supervise(p)
+	}
+}
+
+func (d *AuditDispatcher) supervise(p *auditPlugin) {
+	defer d.wg.Done()
+	backoff := d.MinBackoff
+	for {
+		started := time.Now()
+		err := d.run(p)
+		select {
+		case <-d.done:
+			return
+		default:
+		}
+		//A plugin that ran for a while gets a fresh backoff
+		if time.Since(started) > d.MaxBackoff {
+			backoff = d.MinBackoff
+		}
+		auditLogSyslog(fmt.Sprintf("audisp: plugin %s exited (%v), restarting in %v", p.cfg.Name, err, backoff))
+		select {
+		case <-d.done:
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > d.MaxBackoff {
+			backoff = d.MaxBackoff
+		}
+		p.mu.Lock()
+		p.restarts++
+		p.mu.Unlock()
+	}
+}
+
+// Runs the plugin once, feeding it from its queue until it exits, a
+// write fails or the dispatcher stops.
+func (d *AuditDispatcher) run(p *auditPlugin) error {
+	cmd := exec.Command(p.cfg.Path, p.cfg.Args...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.pid = cmd.Process.Pid
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		p.pid = 0
+		p.mu.Unlock()
+	}()
+
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	//Writes block while the plugin is not reading, they get their own
+	//goroutine so exits and Stop are still seen
+	stop := make(chan bool)
+	written := make(chan error, 1)
+	go func() { written <- d.feed(p, stdin, stop) }()
+	//Closing stdin also fails a write that is blocked
+	stopFeed := func() {
+		close(stop)
+		stdin.Close()
+		<-written
+	}
+
+	select {
+	case err := <-written:
+		stdin.Close()
+		cmd.Process.Kill()
+		<-exited
+		return err
+	case err := <-exited:
+		stopFeed()
+		if err == nil {
+			err = io.EOF
+		}
+		return err
+	case <-d.done:
+		//Closing stdin is how audispd asks plugins to exit
+		stopFeed()
+		select {
+		case err := <-exited:
+			return err
+		case <-time.After(d.StopTimeout):
+			cmd.Process.Kill()
+			return <-exited
+		}
+	}
+}
+
+// Writes queued messages to w until a write fails or stop is closed.
+func (d *AuditDispatcher) feed(p *auditPlugin, w io.Writer, stop <-chan bool) error {
+	for {
+		select {
+		case msg := <-p.queue:
+			if _, err := w.Write(msg); err != nil {
+				p.mu.Lock()
+				p.dropped++
+				p.mu.Unlock()
+				return err
+			}
+		case <-stop:
+			return nil
+		}
+	}
+}
+
+// audit_dispatcher_header in native byte order followed by the message.
+func formatAuditDispatchBinary(typ uint16, data []byte) []byte {
+	b := make([]byte, AUDISP_HEADER_LEN+len(data))
+	e := nativeEndian()
+	e.PutUint32(b[0:4], AUDISP_PROTOCOL_VER)
+	e.PutUint32(b[4:8], AUDISP_HEADER_LEN)
+	e.PutUint32(b[8:12], uint32(typ))
+	e.PutUint32(b[12:16], uint32(len(data)))
+	copy(b[AUDISP_HEADER_LEN:], data)
+	return b
+}
+
+// Queues a kernel message for every plugin. Replies to our own requests
+// (status, rule lists, acks) are not dispatched.
+func (d *AuditDispatcher) DispatchMessage(m syscall.NetlinkMessage) {
+	if !isAuditEventRecord(m.Header.Type) {
+		return
+	}
+	data := []byte(strings.TrimRight(string(m.Data), "\x00\n"))
+	var line []byte
+	for _, p := range d.plugins {
+		var msg []byte
+		if p.cfg.Format == AUDISP_FORMAT_BINARY {
+			msg = formatAuditDispatchBinary(m.Header.Type, data)
+		} else {
+			if line == nil {
+				line = []byte(FormatAuditLogMessage(m) + "\n")
+			}
+			msg = line
+		}
+		d.enqueue(p, msg)
+	}
+}
+
+// Queues the records of an assembled event.
+func (d *AuditDispatcher) DispatchEvent(ev *AuditEvent) {
+	for _, r := range ev.Records {
+		var m syscall.NetlinkMessage
+		m.Header.Type = r.Type
+		m.Data = []byte(formatAuditStamp(r.Timestamp, r.Serial) + ": " + r.Data)
+		d.DispatchMessage(m)
+	}
+}
+
+func (d *AuditDispatcher) enqueue(p *auditPlugin, msg []byte) {
+	d.mu.Lock()
+	off := d.stopped || d.paused || d.halted
+	d.mu.Unlock()
+	if off {
+		p.mu.Lock()
+		p.dropped++
+		p.mu.Unlock()
+		return
+	}
+
+	select {
+	case p.queue <- msg:
+		p.mu.Lock()
+		p.overflow = false
+		p.mu.Unlock()
+		return
+	default:
+	}
+
+	p.mu.Lock()
+	p.dropped++
+	first := !p.overflow
+	p.overflow = true
+	p.mu.Unlock()
+	msgText := "audisp: queue to plugin " + p.cfg.Name + " is full"
+	switch d.Overflow {
+	case AUDISP_OVERFLOW_SYSLOG:
+		if first {
+			auditLogSyslog(msgText + ", dropping events")
+		}
+	case AUDISP_OVERFLOW_SUSPEND:
+		d.mu.Lock()
+		if !d.paused {
+			auditLogSyslog(msgText + ", suspending dispatch")
+		}
+		d.paused = true
+		d.mu.Unlock()
+	case AUDISP_OVERFLOW_HALT:
+		d.mu.Lock()
+		halt := !d.halted
+		d.halted = true
+		d.mu.Unlock()
+		if halt {
+			auditLogSyslog(msgText + ", halting dispatch")
+			if d.OnHalt != nil {
+				go d.OnHalt()
+			}
+		}
+	}
+}
+
+// Lifts an overflow suspension.
+func (d *AuditDispatcher) Resume() {
+	d.mu.Lock()
+	d.paused = false
+	d.mu.Unlock()
+}
+
+func (d *AuditDispatcher) Status() []AuditPluginStatus {
+	out := make([]AuditPluginStatus, 0, len(d.plugins))
+	for _, p := range d.plugins {
+		p.mu.Lock()
+		out = append(out, AuditPluginStatus{
+			Name:     p.cfg.Name,
+			Pid:      p.pid,
+			Restarts: p.restarts,
+			Dropped:  p.dropped,
+			Queued:   len(p.queue),
+		})
+		p.mu.Unlock()
+	}
+	return out
+}
+
+// Closes the plugins' stdin and waits for them to exit, killing those
+// still running after StopTimeout. Messages still queued are not delivered.
+func (d *AuditDispatcher) Stop() {
+	d.mu.Lock()
+	if d.stopped {
+		d.mu.Unlock()
+		return
+	}
+	d.stopped = true
+	close(d.done)
+	d.mu.Unlock()
+	d.wg.Wait()
+}
+
+// Dispatches every message of msgchan until it is closed, e.g. the output
+// of Getreply.
+func AuditDispatchStream(d *AuditDispatcher, msgchan <-chan syscall.NetlinkMessage) {
+	for m := range msgchan {
+		d.DispatchMessage(m)
+	}
+}
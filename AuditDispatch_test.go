@@ -0,0 +1,120 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// A dispatcher for one /bin/sh -c script plugin.
+func newAuditTestDispatcher(script string, queueDepth, overflow int) *AuditDispatcher {
+	cfg := &AuditPluginConfig{Name: "test", Active: true, Direction: "out", Type: "always", Path: "/bin/sh", Args: []string{"-c", script}}
+	d := NewAuditDispatcher([]*AuditPluginConfig{cfg}, queueDepth, overflow)
+	d.MinBackoff, d.MaxBackoff = 10*time.Millisecond, 40*time.Millisecond
+	d.StopTimeout = 100 * time.Millisecond
+	return d
+}
+
+func auditTestMessage(typ uint16, data string) syscall.NetlinkMessage {
+	var m syscall.NetlinkMessage
+	m.Header.Type = typ
+	m.Data = []byte(data)
+	return m
+}
+
+// Polls cond every 10ms for up to 5 seconds.
+func waitAuditTest(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for " + what)
+		}
+	}
+}
+
+func TestParseAuditPluginConfig(t *testing.T) {
+	cfg, err := ParseAuditPluginConfig("af_unix", strings.NewReader(`
+# comment
+active = yes
+direction = out
+path = /sbin/audisp-af_unix
+type = always
+args = 0640 /var/run/audispd_events
+format = binary
+unknown = ignored
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.Active || cfg.Path != "/sbin/audisp-af_unix" || len(cfg.Args) != 2 || cfg.Format != AUDISP_FORMAT_BINARY {
+		t.Fatalf("got %+v", cfg)
+	}
+	for _, bad := range []string{"active", "format = xml", "active = yes\ntype = always"} {
+		if _, err := ParseAuditPluginConfig("bad", strings.NewReader(bad)); err == nil {
+			t.Errorf("%q: no error", bad)
+		}
+	}
+}
+
+func TestAuditDispatcherDeliver(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	d := newAuditTestDispatcher("cat >"+out, 0, AUDISP_OVERFLOW_IGNORE)
+	d.Start()
+	defer d.Stop()
+
+	d.DispatchMessage(auditTestMessage(AUDIT_USER, "audit(1.000:1): msg='hello'"))
+	//Not an event record
+	d.DispatchMessage(auditTestMessage(AUDIT_GET, "audit(1.000:2): x"))
+	want := "type=USER msg=audit(1.000:1): msg='hello'\n"
+	waitAuditTest(t, "delivery", func() bool {
+		b, _ := os.ReadFile(out)
+		return string(b) == want
+	})
+	if st := d.Status(); st[0].Pid == 0 || st[0].Dropped != 0 {
+		t.Fatalf("got %+v", st[0])
+	}
+}
+
+func TestAuditDispatcherRestart(t *testing.T) {
+	d := newAuditTestDispatcher("exit 1", 0, AUDISP_OVERFLOW_IGNORE)
+	d.Start()
+	waitAuditTest(t, "restarts", func() bool { return d.Status()[0].Restarts >= 3 })
+	d.Stop()
+	if st := d.Status(); st[0].Pid != 0 {
+		t.Fatalf("plugin still running: %+v", st[0])
+	}
+}
+
+// A plugin that stops reading fills its queue, which suspends dispatching,
+// and does not keep Stop from returning.
+func TestAuditDispatcherStuckPlugin(t *testing.T) {
+	d := newAuditTestDispatcher("exec sleep 60", 2, AUDISP_OVERFLOW_SUSPEND)
+	d.Start()
+	waitAuditTest(t, "start", func() bool { return d.Status()[0].Pid != 0 })
+
+	big := "audit(1.000:1): msg='" + strings.Repeat("x", 256*1024) + "'"
+	waitAuditTest(t, "overflow", func() bool {
+		d.DispatchMessage(auditTestMessage(AUDIT_USER, big))
+		return d.Status()[0].Dropped > 0
+	})
+	d.mu.Lock()
+	paused := d.paused
+	d.mu.Unlock()
+	if !paused {
+		t.Fatal("overflow did not suspend dispatching")
+	}
+
+	stopped := make(chan bool)
+	go func() {
+		d.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop blocked on the stuck plugin")
+	}
+}
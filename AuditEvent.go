@@ -0,0 +1,290 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	AUDIT_EVENT_DEFAULT_TIMEOUT     = 2 * time.Second
+	AUDIT_EVENT_DEFAULT_MAX_PENDING = 1024
+)
+
+// All records sharing one audit(timestamp:serial) id.
+type AuditEvent struct {
+	Serial    uint64
+	Timestamp time.Time
+	Node      string
+	Records   []*AuditRecord
+	Complete  bool     /* terminated by PROCTITLE/EOE or a standalone record type */
+	Argv      []string /* reassembled from the EXECVE records, if any */
+
+	Container *AuditContainerInfo /* set by AuditContainerEnricher */
+	Lost      *AuditLossInfo      /* synthetic events from AuditLossDetector, one DAEMON_ERR record */
+}
+
+// The exec'd command line, arguments joined by spaces.
+func (ev *AuditEvent) CommandLine() string {
+	return strings.Join(ev.Argv, " ")
+}
+
+// Returns the first record of the given type or nil.
+func (ev *AuditEvent) Record(typ uint16) *AuditRecord {
+	for _, r := range ev.Records {
+		if r.Type == typ {
+			return r
+		}
+	}
+	return nil
+}
+
+// Returns the value of the first record carrying the field.
+func (ev *AuditEvent) Value(name string) string {
+	for _, r := range ev.Records {
+		if f, ok := r.Field(name); ok {
+			return f.Value
+		}
+	}
+	return ""
+}
+
+type auditEventKey struct {
+	node   string
+	msecs  int64
+	serial uint64
+}
+
+type auditPendingEvent struct {
+	ev       *AuditEvent
+	lastSeen time.Time
+}
+
+// Groups records into events. Multi record events are emitted when their
+// PROCTITLE or EOE arrives; the kernel always logs PROCTITLE last and auditd
+// does not write EOE to disk, so either one ends a syscall event. Records
+// without an EOE are emitted after Timeout. At most MaxPending events are
+// kept, the oldest ones are flushed as incomplete when the limit is hit.
+type AuditEventAssembler struct {
+	Timeout    time.Duration
+	MaxPending int
+
+	//Counters, updated atomically. Read them with Counters while the
+	//assembler is running.
+	Emitted    uint64
+	Incomplete uint64
+	Evicted    uint64
+	TimedOut   uint64
+
+	pending map[auditEventKey]*auditPendingEvent
+	order   []auditEventKey
+	now     func() time.Time
+}
+
+func NewAuditEventAssembler(timeout time.Duration, maxPending int) *AuditEventAssembler {
+	if timeout <= 0 {
+		timeout = AUDIT_EVENT_DEFAULT_TIMEOUT
+	}
+	if maxPending <= 0 {
+		maxPending = AUDIT_EVENT_DEFAULT_MAX_PENDING
+	}
+	return &AuditEventAssembler{
+		Timeout:    timeout,
+		MaxPending: maxPending,
+		pending:    make(map[auditEventKey]*auditPendingEvent),
+		now:        time.Now,
+	}
+}
+
+// Records that are never followed by an EOE: userspace messages and daemon records.
+func isAuditStandaloneRecord(t uint16) bool {
+	return (t >= AUDIT_FIRST_USER_MSG && t <= AUDIT_LAST_DAEMON) || (t >= AUDIT_FIRST_USER_MSG2 && t <= AUDIT_LAST_USER_MSG2)
+}
+
+// Replies to our own requests (status, rule lists, acks) are not event records.
+func isAuditEventRecord(t uint16) bool {
+	return t == AUDIT_USER || t == AUDIT_LOGIN || t == AUDIT_CONTAINER_OP || t == AUDIT_CONTAINER_ID || t >= AUDIT_FIRST_USER_MSG
+}
+
+// Number of events waiting for more records.
+func (a *AuditEventAssembler) Pending() int {
+	return len(a.pending)
+}
+
+// Adds a record and returns the events it completed, if any.
+func (a *AuditEventAssembler) Push(r *AuditRecord) []*AuditEvent {
+	var out []*AuditEvent
+	key := auditEventKey{node: r.Node, msecs: r.Timestamp.UnixNano() / int64(time.Millisecond), serial: r.Serial}
+	now := a.now()
+
+	p, ok := a.pending[key]
+	if !ok {
+		//The EOE after a PROCTITLE, or one whose event was already emitted
+		//by timeout or eviction, has nothing left to terminate
+		if r.Type == AUDIT_EOE {
+			return nil
+		}
+		if isAuditStandaloneRecord(r.Type) {
+			ev := &AuditEvent{Serial: r.Serial, Timestamp: r.Timestamp, Node: r.Node, Records: []*AuditRecord{r}, Complete: true}
+			return append(out, a.emit(ev))
+		}
+		for len(a.pending) >= a.MaxPending {
+			ev := a.evictOldest()
+			if ev == nil {
+				break
+			}
+			atomic.AddUint64(&a.Evicted, 1)
+			out = append(out, a.emit(ev))
+		}
+		p = &auditPendingEvent{ev: &AuditEvent{Serial: r.Serial, Timestamp: r.Timestamp, Node: r.Node}}
+		a.pending[key] = p
+		a.order = append(a.order, key)
+		if len(a.order) > 2*a.MaxPending {
+			a.compact()
+		}
+	}
+	p.lastSeen = now
+
+	switch r.Type {
+	case AUDIT_EOE:
+		//EOE carries no data, it only terminates the event
+	case AUDIT_PROCTITLE:
+		p.ev.Records = append(p.ev.Records, r)
+	default:
+		p.ev.Records = append(p.ev.Records, r)
+		return out
+	}
+	delete(a.pending, key)
+	p.ev.Complete = true
+	return append(out, a.emit(p.ev))
+}
+
+// Emits every event that has not seen a record for Timeout. Events that
+// contain a SYSCALL record were expecting an EOE and are reported incomplete.
+func (a *AuditEventAssembler) Expire() []*AuditEvent {
+	var out []*AuditEvent
+	deadline := a.now().Add(-a.Timeout)
+	for key, p := range a.pending {
+		if p.lastSeen.After(deadline) {
+			continue
+		}
+		delete(a.pending, key)
+		atomic.AddUint64(&a.TimedOut, 1)
+		p.ev.Complete = p.ev.Record(AUDIT_SYSCALL) == nil
+		out = append(out, a.emit(p.ev))
+	}
+	a.compact()
+	sortAuditEvents(out)
+	return out
+}
+
+// Emits everything still pending, used when the record source is exhausted.
+func (a *AuditEventAssembler) Flush() []*AuditEvent {
+	var out []*AuditEvent
+	for key, p := range a.pending {
+		delete(a.pending, key)
+		p.ev.Complete = p.ev.Record(AUDIT_SYSCALL) == nil && len(p.ev.Records) > 0
+		out = append(out, a.emit(p.ev))
+	}
+	a.order = a.order[:0]
+	sortAuditEvents(out)
+	return out
+}
+
+// Emitted, incomplete, evicted and timed out event counts. Safe to call
+// from any goroutine.
+func (a *AuditEventAssembler) Counters() (emitted, incomplete, evicted, timedOut uint64) {
+	return atomic.LoadUint64(&a.Emitted), atomic.LoadUint64(&a.Incomplete),
+		atomic.LoadUint64(&a.Evicted), atomic.LoadUint64(&a.TimedOut)
+}
+
+func (a *AuditEventAssembler) emit(ev *AuditEvent) *AuditEvent {
+	if ev.Record(AUDIT_EXECVE) != nil {
+		//A partial argv is still worth matching on, keep it even if pieces are missing
+		ev.Argv, _ = DecodeAuditExecve(ev.Records)
+	}
+	atomic.AddUint64(&a.Emitted, 1)
+	if !ev.Complete {
+		atomic.AddUint64(&a.Incomplete, 1)
+	}
+	return ev
+}
+
+func (a *AuditEventAssembler) evictOldest() *AuditEvent {
+	for len(a.order) > 0 {
+		key := a.order[0]
+		a.order = a.order[1:]
+		if p, ok := a.pending[key]; ok {
+			delete(a.pending, key)
+			p.ev.Complete = false
+			return p.ev
+		}
+	}
+	return nil
+}
+
+// Drops keys of events that are no longer pending so order stays bounded.
+func (a *AuditEventAssembler) compact() {
+	keep := a.order[:0]
+	for _, key := range a.order {
+		if _, ok := a.pending[key]; ok {
+			keep = append(keep, key)
+		}
+	}
+	a.order = keep
+}
+
+// How often the stream helpers call Expire.
+func (a *AuditEventAssembler) expireInterval() time.Duration {
+	if d := a.Timeout / 2; d > 0 {
+		return d
+	}
+	return 1
+}
+
+func sortAuditEvents(evs []*AuditEvent) {
+	sort.Slice(evs, func(i, j int) bool {
+		if !evs[i].Timestamp.Equal(evs[j].Timestamp) {
+			return evs[i].Timestamp.Before(evs[j].Timestamp)
+		}
+		return evs[i].Serial < evs[j].Serial
+	})
+}
+
+// Reads netlink messages as produced by Getreply and writes assembled events
+// to evchan. Parse errors go to errchan. When msgchan is closed the pending
+// events are flushed and evchan is closed.
+func AuditEventStream(a *AuditEventAssembler, msgchan <-chan syscall.NetlinkMessage, evchan chan<- *AuditEvent, errchan chan<- error) {
+	ticker := time.NewTicker(a.expireInterval())
+	defer ticker.Stop()
+	defer close(evchan)
+
+	for {
+		select {
+		case m, ok := <-msgchan:
+			if !ok {
+				for _, ev := range a.Flush() {
+					evchan <- ev
+				}
+				return
+			}
+			if !isAuditEventRecord(m.Header.Type) {
+				continue
+			}
+			r, err := ParseAuditRecord(m)
+			if err != nil {
+				errchan <- err
+				continue
+			}
+			for _, ev := range a.Push(r) {
+				evchan <- ev
+			}
+		case <-ticker.C:
+			for _, ev := range a.Expire() {
+				evchan <- ev
+			}
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+// An assembler whose clock only moves when the test says so.
+func newAuditTestAssembler(timeout time.Duration, maxPending int) (*AuditEventAssembler, *time.Time) {
+	a := NewAuditEventAssembler(timeout, maxPending)
+	now := time.Unix(1700000000, 0)
+	a.now = func() time.Time { return now }
+	return a, &now
+}
+
+func pushAuditTestRecord(t *testing.T, a *AuditEventAssembler, typ uint16, serial uint64, data string) []*AuditEvent {
+	t.Helper()
+	return a.Push(parseAuditTestRecord(t, typ, "audit(1700000000.000:"+strconv.FormatUint(serial, 10)+"): "+data))
+}
+
+func TestAuditEventAssembler(t *testing.T) {
+	a, _ := newAuditTestAssembler(0, 0)
+	if evs := pushAuditTestRecord(t, a, AUDIT_SYSCALL, 1, "arch=c000003e syscall=59 success=yes"); evs != nil {
+		t.Fatalf("SYSCALL emitted %d events", len(evs))
+	}
+	pushAuditTestRecord(t, a, AUDIT_EXECVE, 1, `argc=2 a0="ls" a1="-la"`)
+	pushAuditTestRecord(t, a, AUDIT_CWD, 1, `cwd="/root"`)
+	evs := pushAuditTestRecord(t, a, AUDIT_PROCTITLE, 1, "proctitle=6C73002D6C61")
+	if len(evs) != 1 {
+		t.Fatalf("PROCTITLE emitted %d events", len(evs))
+	}
+	ev := evs[0]
+	if !ev.Complete || ev.Serial != 1 || len(ev.Records) != 4 || ev.CommandLine() != "ls -la" {
+		t.Fatalf("got %+v", ev)
+	}
+	if ev.Value("cwd") != "/root" || ev.Record(AUDIT_EXECVE) == nil {
+		t.Fatalf("got cwd %q", ev.Value("cwd"))
+	}
+
+	//The EOE following PROCTITLE has nothing left to end
+	if evs := pushAuditTestRecord(t, a, AUDIT_EOE, 1, ""); evs != nil || a.Pending() != 0 {
+		t.Fatalf("EOE: %d events, %d pending", len(evs), a.Pending())
+	}
+
+	//EOE ends events without PROCTITLE and is not kept
+	pushAuditTestRecord(t, a, AUDIT_SYSCALL, 2, "arch=c000003e syscall=2 success=no")
+	evs = pushAuditTestRecord(t, a, AUDIT_EOE, 2, "")
+	if len(evs) != 1 || !evs[0].Complete || len(evs[0].Records) != 1 {
+		t.Fatalf("got %+v", evs)
+	}
+
+	//Standalone records are events by themselves
+	evs = pushAuditTestRecord(t, a, AUDIT_USER_LOGIN, 3, "pid=1 uid=0 msg='op=login res=success'")
+	if len(evs) != 1 || !evs[0].Complete || a.Pending() != 0 {
+		t.Fatalf("got %+v", evs)
+	}
+
+	if a.Emitted != 3 || a.Incomplete != 0 || a.Evicted != 0 || a.TimedOut != 0 {
+		t.Fatalf("got counters %d %d %d %d", a.Emitted, a.Incomplete, a.Evicted, a.TimedOut)
+	}
+}
+
+func TestAuditEventAssemblerExpire(t *testing.T) {
+	a, now := newAuditTestAssembler(time.Second, 0)
+	pushAuditTestRecord(t, a, AUDIT_SYSCALL, 1, "arch=c000003e syscall=2 success=no")
+	pushAuditTestRecord(t, a, AUDIT_CONFIG_CHANGE, 2, "op=add_rule key=(null) list=4 res=1")
+
+	*now = now.Add(time.Second / 2)
+	if evs := a.Expire(); evs != nil {
+		t.Fatalf("expired %d events early", len(evs))
+	}
+	*now = now.Add(time.Second)
+	evs := a.Expire()
+	if len(evs) != 2 || evs[0].Serial != 1 || evs[1].Serial != 2 {
+		t.Fatalf("got %+v", evs)
+	}
+	//A SYSCALL without EOE is incomplete, a kernel record on its own is not
+	if evs[0].Complete || !evs[1].Complete {
+		t.Fatalf("got complete %v %v", evs[0].Complete, evs[1].Complete)
+	}
+	if a.Incomplete != 1 || a.TimedOut != 2 {
+		t.Fatalf("got incomplete=%d timed out=%d", a.Incomplete, a.TimedOut)
+	}
+}
+
+func TestAuditEventAssemblerEvict(t *testing.T) {
+	a, _ := newAuditTestAssembler(0, 2)
+	for serial := uint64(1); serial <= 2; serial++ {
+		pushAuditTestRecord(t, a, AUDIT_SYSCALL, serial, "arch=c000003e syscall=2")
+	}
+	evs := pushAuditTestRecord(t, a, AUDIT_SYSCALL, 3, "arch=c000003e syscall=2")
+	if len(evs) != 1 || evs[0].Serial != 1 || evs[0].Complete {
+		t.Fatalf("got %+v", evs)
+	}
+	if a.Evicted != 1 || a.Pending() != 2 {
+		t.Fatalf("got evicted=%d pending=%d", a.Evicted, a.Pending())
+	}
+
+	evs = a.Flush()
+	if len(evs) != 2 || evs[0].Serial != 2 || evs[1].Serial != 3 || a.Pending() != 0 {
+		t.Fatalf("got %+v", evs)
+	}
+}
+
+// Records from different nodes with the same serial are different events.
+func TestAuditEventAssemblerNodes(t *testing.T) {
+	a, _ := newAuditTestAssembler(0, 0)
+	for _, node := range []string{"a", "b"} {
+		a.Push(parseAuditTestRecord(t, AUDIT_SYSCALL, "node="+node+" audit(1.000:7): syscall=2"))
+	}
+	if a.Pending() != 2 {
+		t.Fatalf("got %d pending", a.Pending())
+	}
+}
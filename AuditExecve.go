@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var ErrAuditExecveIncomplete = errors.New("audit execve: arguments missing from EXECVE records")
+
+type auditExecveArg struct {
+	value    string
+	set      bool
+	length   int /* aN_len, only present for split arguments */
+	chunks   map[int]string
+	wireLen  int /* chunk lengths as sent, twice the bytes if hex encoded */
+	hasChunk bool
+}
+
+// Rebuilds the argv of an exec from its EXECVE records. Short arguments come
+// as a0="ls" or hex a1=2D6C61, long ones are announced with a3_len=N and
+// split into a3[0], a3[1] ... which may be spread over several records.
+// When pieces are missing the partial argv is returned with
+// ErrAuditExecveIncomplete.
+func DecodeAuditExecve(records []*AuditRecord) ([]string, error) {
+	argc := -1
+	args := make(map[int]*auditExecveArg)
+	get := func(n int) *auditExecveArg {
+		a, ok := args[n]
+		if !ok {
+			a = &auditExecveArg{chunks: make(map[int]string)}
+			args[n] = a
+		}
+		return a
+	}
+
+	for _, r := range records {
+		if r.Type != AUDIT_EXECVE {
+			continue
+		}
+		for _, f := range r.Fields {
+			if f.Enriched {
+				continue
+			}
+			if f.Name == "argc" {
+				if n, err := strconv.Atoi(f.Value); err == nil && argc < 0 {
+					argc = n
+				}
+				continue
+			}
+			n, suffix, ok := parseAuditExecveName(f.Name)
+			if !ok {
+				continue
+			}
+			a := get(n)
+			switch {
+			case suffix == "":
+				a.value, a.set = f.Value, true
+			case suffix == "_len":
+				a.length, _ = strconv.Atoi(f.Value)
+			case suffix[0] == '[':
+				idx, err := strconv.Atoi(strings.Trim(suffix, "[]"))
+				if err != nil {
+					continue
+				}
+				a.chunks[idx] = f.Value
+				a.hasChunk = true
+				//aN_len counts the encoded argument, not the decoded bytes
+				if f.Encoded {
+					a.wireLen += 2 * len(f.Value)
+				} else {
+					a.wireLen += len(f.Value)
+				}
+			}
+		}
+	}
+
+	if argc < 0 {
+		//No argc at all, take whatever arguments we saw
+		for n := range args {
+			if n+1 > argc {
+				argc = n + 1
+			}
+		}
+	}
+	if argc < 0 {
+		return nil, ErrAuditExecveIncomplete
+	}
+
+	var err error
+	argv := make([]string, 0, argc)
+	for n := 0; n < argc; n++ {
+		a, ok := args[n]
+		if !ok {
+			err = ErrAuditExecveIncomplete
+			argv = append(argv, "")
+			continue
+		}
+		if !a.hasChunk {
+			if !a.set {
+				err = ErrAuditExecveIncomplete
+			}
+			argv = append(argv, a.value)
+			continue
+		}
+		idx := make([]int, 0, len(a.chunks))
+		for i := range a.chunks {
+			idx = append(idx, i)
+		}
+		sort.Ints(idx)
+		var b strings.Builder
+		for i, c := range idx {
+			if c != i {
+				err = ErrAuditExecveIncomplete
+			}
+			b.WriteString(a.chunks[c])
+		}
+		if a.length > 0 && a.wireLen != a.length {
+			err = ErrAuditExecveIncomplete
+		}
+		argv = append(argv, b.String())
+	}
+	return argv, err
+}
+
+// "a12" -> 12,"" "a3_len" -> 3,"_len" "a3[1]" -> 3,"[1]"
+func parseAuditExecveName(name string) (int, string, bool) {
+	if len(name) < 2 || name[0] != 'a' {
+		return 0, "", false
+	}
+	i := 1
+	for i < len(name) && name[i] >= '0' && name[i] <= '9' {
+		i++
+	}
+	if i == 1 {
+		return 0, "", false
+	}
+	n, err := strconv.Atoi(name[1:i])
+	if err != nil {
+		return 0, "", false
+	}
+	suffix := name[i:]
+	if suffix != "" && suffix != "_len" && suffix[0] != '[' {
+		return 0, "", false
+	}
+	return n, suffix, true
+}
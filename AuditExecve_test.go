@@ -0,0 +1,82 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func decodeAuditTestExecve(t *testing.T, data ...string) ([]string, error) {
+	t.Helper()
+	var records []*AuditRecord
+	for _, d := range data {
+		records = append(records, parseAuditTestRecord(t, AUDIT_EXECVE, "audit(1.000:1): "+d))
+	}
+	return DecodeAuditExecve(records)
+}
+
+func TestDecodeAuditExecve(t *testing.T) {
+	for _, c := range []struct {
+		data []string
+		want []string
+	}{
+		{[]string{`argc=3 a0="ls" a1=2D6C61 a2="/tmp"`}, []string{"ls", "-la", "/tmp"}},
+		{[]string{`argc=2 a0="echo" a1=""`}, []string{"echo", ""}},
+		//a1 is split over two records, a1_len counts the hex digits
+		{[]string{
+			`argc=3 a0="cat" a1_len=12 a1[0]=2F746D`,
+			`a1[1]=702F78 a2="-n"`,
+		}, []string{"cat", "/tmp/x", "-n"}},
+		{[]string{`argc=2 a0="sh" a1_len=4 a1[0]="-c" a1[1]="ls"`}, []string{"sh", "-cls"}},
+		//No argc: take the arguments that are there
+		{[]string{`a0="true"`}, []string{"true"}},
+	} {
+		got, err := decodeAuditTestExecve(t, c.data...)
+		if err != nil {
+			t.Errorf("%q: %v", c.data, err)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%q: got %q, want %q", c.data, got, c.want)
+		}
+	}
+}
+
+func TestDecodeAuditExecveIncomplete(t *testing.T) {
+	for _, c := range []struct {
+		data []string
+		want []string
+	}{
+		{[]string{`argc=3 a0="ls" a2="/tmp"`}, []string{"ls", "", "/tmp"}},
+		{[]string{`argc=2 a0="cat" a1_len=12 a1[0]=2F746D`}, []string{"cat", "/tm"}},
+		{[]string{`argc=2 a0="cat" a1_len=12 a1[1]=702F78`}, []string{"cat", "p/x"}},
+		{[]string{`arch=c000003e`}, nil},
+	} {
+		got, err := decodeAuditTestExecve(t, c.data...)
+		if err != ErrAuditExecveIncomplete {
+			t.Errorf("%q: got %v, want ErrAuditExecveIncomplete", c.data, err)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%q: got %q, want %q", c.data, got, c.want)
+		}
+	}
+}
+
+func TestParseAuditExecveName(t *testing.T) {
+	for name, want := range map[string]struct {
+		n      int
+		suffix string
+		ok     bool
+	}{
+		"a0":     {0, "", true},
+		"a12":    {12, "", true},
+		"a3_len": {3, "_len", true},
+		"a3[1]":  {3, "[1]", true},
+		"argc":   {0, "", false},
+		"a":      {0, "", false},
+		"a1x":    {0, "", false},
+	} {
+		n, suffix, ok := parseAuditExecveName(name)
+		if n != want.n || suffix != want.suffix || ok != want.ok {
+			t.Errorf("%s: got %d %q %v", name, n, suffix, ok)
+		}
+	}
+}
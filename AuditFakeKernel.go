@@ -0,0 +1,413 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	AUDIT_FAKE_DEFAULT_QUEUE   = 4096 /* datagrams per socket before ENOBUFS */
+	AUDIT_FAKE_DEFAULT_BACKLOG = 8192
+	AUDIT_FAKE_UNSET           = 4294967295 /* auid and ses of processes that never logged in */
+)
+
+// A record of a synthetic event, see AuditFakeKernel.EmitEvent.
+type AuditFakeRecord struct {
+	Type uint16
+	Text string /* record body after "audit(...): " */
+}
+
+// The audit side of the kernel in-process, for running the library
+// without privileges. It answers AUDIT_GET and AUDIT_SET, keeps the rules
+// of AUDIT_ADD_RULE and AUDIT_DEL_RULE for AUDIT_LIST_RULES, ACKs and NACKs
+// like the kernel does and sends the events given to Emit to the socket
+// registered with AUDIT_STATUS_PID. Replies carry port id 0 and ACKs the
+// port id of the requesting socket, the same as the kernel. Safe for
+// concurrent use.
+type AuditFakeKernel struct {
+	QueueLimit   int  /* per socket, an event beyond it is lost and the next read gets ENOBUFS */
+	Unprivileged bool /* NACK everything that changes state with EPERM */
+
+	mu        sync.Mutex
+	status    AuditStatus
+	rules     [][]byte /* struct audit_rule_data with its buf, in the order added */
+	serial    uint64
+	nextPort  uint32
+	registrar *AuditFakeSocket /* gets the events */
+	now       func() time.Time
+}
+
+func NewAuditFakeKernel() *AuditFakeKernel {
+	return &AuditFakeKernel{
+		QueueLimit: AUDIT_FAKE_DEFAULT_QUEUE,
+		status: AuditStatus{
+			Enabled:       1,
+			Failure:       1,
+			Backlog_limit: AUDIT_FAKE_DEFAULT_BACKLOG,
+		},
+		nextPort: 1,
+		now:      time.Now,
+	}
+}
+
+// A new socket bound to the fake kernel, the counterpart of
+// GetNetlinkSocket.
+func (k *AuditFakeKernel) Dial() *AuditFakeSocket {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	f := &AuditFakeSocket{
+		k:      k,
+		pid:    k.nextPort,
+		wake:   make(chan struct{}, 1),
+		closed: make(chan struct{}),
+	}
+	k.nextPort++
+	return f
+}
+
+func (k *AuditFakeKernel) Status() AuditStatus {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.status
+}
+
+func (k *AuditFakeKernel) Rules() []*AuditRule {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	var rules []*AuditRule
+	for _, b := range k.rules {
+		if r, err := ParseAuditRuleData(b); err == nil {
+			rules = append(rules, r)
+		}
+	}
+	return rules
+}
+
+// Emits a single record event and returns its serial, 0 if auditing is
+// off.
+func (k *AuditFakeKernel) Emit(typ uint16, text string) uint64 {
+	return k.EmitEvent(AuditFakeRecord{typ, text})
+}
+
+// Emits the records as one event, they share a timestamp and serial.
+// Multi-record events should end with an AUDIT_EOE record like the
+// kernel's do. Without a registered socket the event is dropped, the
+// kernel would hand it to printk.
+func (k *AuditFakeKernel) EmitEvent(records ...AuditFakeRecord) uint64 {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.status.Enabled == 0 {
+		return 0
+	}
+	k.serial++
+	k.emit(k.serial, records)
+	return k.serial
+}
+
+// Uses up n serials as if the kernel had to drop n events, counting them
+// in the lost counter.
+func (k *AuditFakeKernel) Drop(n int) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.serial += uint64(n)
+	k.status.Lost += uint32(n)
+}
+
+// Must be called with mu held.
+func (k *AuditFakeKernel) emit(serial uint64, records []AuditFakeRecord) {
+	f := k.registrar
+	if f == nil {
+		return
+	}
+	ts := k.now()
+	prefix := fmt.Sprintf("audit(%d.%03d:%d): ", ts.Unix(), ts.Nanosecond()/int(time.Millisecond), serial)
+	for _, r := range records {
+		if !f.queue(k.message(r.Type, 0, 0, 0, []byte(prefix+r.Text))) {
+			k.status.Lost++
+		}
+	}
+}
+
+// Must be called with mu held.
+func (k *AuditFakeKernel) message(typ, flags uint16, seq, pid uint32, data []byte) []byte {
+	rr := &NetlinkAuditRequest{Data: data}
+	rr.Header.Len = uint32(syscall.NLMSG_HDRLEN + len(data))
+	rr.Header.Type = typ
+	rr.Header.Flags = flags
+	rr.Header.Seq = seq
+	rr.Header.Pid = pid
+	return rr.ToWireFormat()
+}
+
+// Must be called with mu held. errno 0 is an ACK, sent only if the
+// request asked for one.
+func (k *AuditFakeKernel) ack(f *AuditFakeSocket, h syscall.NlMsghdr, errno syscall.Errno) {
+	if errno == 0 && h.Flags&syscall.NLM_F_ACK == 0 {
+		return
+	}
+	b := make([]byte, 4+syscall.NLMSG_HDRLEN)
+	e := nativeEndian()
+	e.PutUint32(b[0:4], uint32(-int32(errno)))
+	e.PutUint32(b[4:8], h.Len)
+	e.PutUint16(b[8:10], h.Type)
+	e.PutUint16(b[10:12], h.Flags)
+	e.PutUint32(b[12:16], h.Seq)
+	e.PutUint32(b[16:20], h.Pid)
+	f.queue(k.message(syscall.NLMSG_ERROR, 0, h.Seq, f.pid, b))
+}
+
+func isAuditFakeUserMessage(t uint16) bool {
+	return t == AUDIT_USER || (t >= AUDIT_FIRST_USER_MSG && t <= AUDIT_LAST_USER_MSG) || (t >= AUDIT_FIRST_USER_MSG2 && t <= AUDIT_LAST_USER_MSG2)
+}
+
+// Handles the requests of one datagram sent by f.
+func (k *AuditFakeKernel) handle(f *AuditFakeSocket, b []byte) error {
+	msgs, err := ParseAuditNetlinkMessage(b)
+	if err != nil {
+		return err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for _, m := range msgs {
+		if m.Header.Flags&syscall.NLM_F_REQUEST == 0 {
+			continue
+		}
+		//The ACK goes out first, replies follow like from the kernel's reply thread
+		var replies [][]byte
+		errno := k.request(f, m, &replies)
+		k.ack(f, m.Header, errno)
+		for _, r := range replies {
+			f.queue(r)
+		}
+	}
+	return nil
+}
+
+// Must be called with mu held.
+func (k *AuditFakeKernel) request(f *AuditFakeSocket, m syscall.NetlinkMessage, replies *[][]byte) syscall.Errno {
+	seq := m.Header.Seq
+	switch t := m.Header.Type; {
+	case t == AUDIT_GET:
+		buff := new(bytes.Buffer)
+		binary.Write(buff, nativeEndian(), k.status)
+		*replies = append(*replies, k.message(AUDIT_GET, 0, seq, 0, buff.Bytes()))
+
+	case t == AUDIT_SET:
+		var st AuditStatus
+		if len(m.Data) < binary.Size(st) {
+			return syscall.EINVAL
+		}
+		binary.Read(bytes.NewReader(m.Data), nativeEndian(), &st)
+		return k.set(f, &st)
+
+	case t == AUDIT_ADD_RULE, t == AUDIT_DEL_RULE:
+		if k.Unprivileged || k.status.Enabled == 2 {
+			return syscall.EPERM
+		}
+		r, err := ParseAuditRuleData(m.Data)
+		if err != nil {
+			return syscall.EINVAL
+		}
+		if _, ok := auditRuleLists[r.List]; !ok {
+			return syscall.EINVAL
+		}
+		if _, ok := auditRuleActions[r.Action]; !ok {
+			return syscall.EINVAL
+		}
+		rule := append([]byte(nil), m.Data[:auditRuleDataLen+int(nativeEndian().Uint32(m.Data[auditRuleDataLen-4:]))]...)
+		i := k.findRule(rule)
+		if t == AUDIT_ADD_RULE {
+			if i >= 0 {
+				return syscall.EEXIST
+			}
+			k.rules = append(k.rules, rule)
+		} else {
+			if i < 0 {
+				return syscall.ENOENT
+			}
+			k.rules = append(k.rules[:i], k.rules[i+1:]...)
+		}
+
+	case t == AUDIT_LIST_RULES:
+		for _, r := range k.rules {
+			*replies = append(*replies, k.message(AUDIT_LIST_RULES, syscall.NLM_F_MULTI, seq, 0, r))
+		}
+		*replies = append(*replies, k.message(syscall.NLMSG_DONE, syscall.NLM_F_MULTI, seq, 0, make([]byte, 4)))
+
+	case isAuditFakeUserMessage(t):
+		if k.status.Enabled != 0 {
+			text := string(bytes.TrimRight(m.Data, "\x00"))
+			k.serial++
+			k.emit(k.serial, []AuditFakeRecord{{t, fmt.Sprintf("pid=%d uid=0 auid=%d ses=%d msg='%s'", f.pid, AUDIT_FAKE_UNSET, AUDIT_FAKE_UNSET, text)}})
+		}
+
+	default:
+		return syscall.EINVAL
+	}
+	return 0
+}
+
+// Must be called with mu held. Applies the fields selected by st.Mask.
+func (k *AuditFakeKernel) set(f *AuditFakeSocket, st *AuditStatus) syscall.Errno {
+	if k.Unprivileged {
+		return syscall.EPERM
+	}
+	if st.Mask&AUDIT_STATUS_ENABLED != 0 && st.Enabled > 2 {
+		return syscall.EINVAL
+	}
+	if st.Mask&AUDIT_STATUS_FAILURE != 0 && st.Failure > 2 {
+		return syscall.EINVAL
+	}
+	if k.status.Enabled == 2 && st.Mask&(AUDIT_STATUS_ENABLED|AUDIT_STATUS_FAILURE|AUDIT_STATUS_RATE_LIMIT|AUDIT_STATUS_BACKLOG_LIMIT) != 0 {
+		return syscall.EPERM
+	}
+	if st.Mask&AUDIT_STATUS_ENABLED != 0 {
+		k.status.Enabled = st.Enabled
+	}
+	if st.Mask&AUDIT_STATUS_FAILURE != 0 {
+		k.status.Failure = st.Failure
+	}
+	if st.Mask&AUDIT_STATUS_PID != 0 {
+		//A live daemon can only be replaced by itself
+		if st.Pid != 0 && k.registrar != nil && k.registrar != f {
+			return syscall.EEXIST
+		}
+		k.status.Pid = st.Pid
+		k.registrar = nil
+		if st.Pid != 0 {
+			k.registrar = f
+		}
+	}
+	if st.Mask&AUDIT_STATUS_RATE_LIMIT != 0 {
+		k.status.Rate_limit = st.Rate_limit
+	}
+	if st.Mask&AUDIT_STATUS_BACKLOG_LIMIT != 0 {
+		k.status.Backlog_limit = st.Backlog_limit
+	}
+	return 0
+}
+
+// Must be called with mu held.
+func (k *AuditFakeKernel) findRule(rule []byte) int {
+	for i, r := range k.rules {
+		if bytes.Equal(r, rule) {
+			return i
+		}
+	}
+	return -1
+}
+
+// A socket of an AuditFakeKernel, implements NetlinkTransport.
+type AuditFakeSocket struct {
+	k       *AuditFakeKernel
+	pid     uint32
+	pending [][]byte /* datagrams to read, guarded by k.mu */
+	overrun bool
+	timeout time.Duration
+	wake    chan struct{}
+	closed  chan struct{}
+}
+
+// Must be called with k.mu held. Returns false if the datagram did not
+// fit in the queue.
+func (f *AuditFakeSocket) queue(b []byte) bool {
+	select {
+	case <-f.closed:
+		return false
+	default:
+	}
+	if f.k.QueueLimit > 0 && len(f.pending) >= f.k.QueueLimit {
+		f.overrun = true
+		return false
+	}
+	f.pending = append(f.pending, b)
+	select {
+	case f.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+func (f *AuditFakeSocket) Send(request *NetlinkAuditRequest) error {
+	select {
+	case <-f.closed:
+		return syscall.EBADF
+	default:
+	}
+	return f.k.handle(f, request.ToWireFormat())
+}
+
+func (f *AuditFakeSocket) Receive(bytesize int, block int) ([]syscall.NetlinkMessage, error) {
+	return receiveNetlinkMessages(f, bytesize, block)
+}
+
+// Datagrams longer than b are truncated, as on a real socket.
+func (f *AuditFakeSocket) Recvfrom(b []byte, flags int) (int, error) {
+	var timeout <-chan time.Time
+	for {
+		f.k.mu.Lock()
+		if f.overrun {
+			f.overrun = false
+			f.k.mu.Unlock()
+			return 0, syscall.ENOBUFS
+		}
+		if len(f.pending) > 0 {
+			d := f.pending[0]
+			f.pending[0] = nil
+			f.pending = f.pending[1:]
+			f.k.mu.Unlock()
+			return copy(b, d), nil
+		}
+		d := f.timeout
+		f.k.mu.Unlock()
+
+		if flags&syscall.MSG_DONTWAIT != 0 {
+			return 0, syscall.EAGAIN
+		}
+		if timeout == nil && d > 0 {
+			t := time.NewTimer(d)
+			defer t.Stop()
+			timeout = t.C
+		}
+		select {
+		case <-f.wake:
+		case <-timeout:
+			return 0, syscall.EAGAIN
+		case <-f.closed:
+			return 0, syscall.EBADF
+		}
+	}
+}
+
+func (f *AuditFakeSocket) SetReceiveTimeout(d time.Duration) error {
+	f.k.mu.Lock()
+	f.timeout = d
+	f.k.mu.Unlock()
+	return nil
+}
+
+func (f *AuditFakeSocket) Pid() uint32 {
+	return f.pid
+}
+
+// Closing the registered socket unregisters it, like the kernel does when
+// the daemon goes away.
+func (f *AuditFakeSocket) Close() {
+	f.k.mu.Lock()
+	defer f.k.mu.Unlock()
+	select {
+	case <-f.closed:
+		return
+	default:
+	}
+	close(f.closed)
+	f.pending = nil
+	if f.k.registrar == f {
+		f.k.registrar = nil
+		f.k.status.Pid = 0
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// A registered socket on a fresh fake kernel.
+func newAuditFakeDaemon(t *testing.T) (*AuditFakeKernel, *AuditFakeSocket) {
+	k := NewAuditFakeKernel()
+	s := k.Dial()
+	t.Cleanup(s.Close)
+	if err := AuditSetStatus(s, &AuditStatus{Mask: AUDIT_STATUS_PID, Pid: 100}); err != nil {
+		t.Fatal(err)
+	}
+	return k, s
+}
+
+// Reads one record from s, failing after a second.
+func readAuditFakeRecord(t *testing.T, s *AuditFakeSocket) *AuditRecord {
+	t.Helper()
+	s.SetReceiveTimeout(time.Second)
+	b := make([]byte, MAX_AUDIT_MESSAGE_LENGTH)
+	n, err := s.Recvfrom(b, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs, err := ParseAuditNetlinkMessage(b[:n])
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := ParseAuditRecord(msgs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestAuditFakeKernelStatus(t *testing.T) {
+	k := NewAuditFakeKernel()
+	s := k.Dial()
+	defer s.Close()
+
+	st, err := AuditGetStatus(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Enabled != 1 || st.Backlog_limit != AUDIT_FAKE_DEFAULT_BACKLOG {
+		t.Fatalf("got enabled=%d backlog_limit=%d", st.Enabled, st.Backlog_limit)
+	}
+
+	err = AuditSetStatus(s, &AuditStatus{Mask: AUDIT_STATUS_FAILURE | AUDIT_STATUS_RATE_LIMIT, Failure: 2, Rate_limit: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := k.Status(); st.Failure != 2 || st.Rate_limit != 50 || st.Enabled != 1 {
+		t.Fatalf("got failure=%d rate_limit=%d enabled=%d", st.Failure, st.Rate_limit, st.Enabled)
+	}
+	if err := AuditSetStatus(s, &AuditStatus{Mask: AUDIT_STATUS_ENABLED, Enabled: 3}); err != syscall.EINVAL {
+		t.Fatalf("enabled=3: got %v, want EINVAL", err)
+	}
+
+	//Locked: no more changes to the configuration
+	if err := AuditSetStatus(s, &AuditStatus{Mask: AUDIT_STATUS_ENABLED, Enabled: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := AuditSetStatus(s, &AuditStatus{Mask: AUDIT_STATUS_ENABLED, Enabled: 0}); err != syscall.EPERM {
+		t.Fatalf("locked: got %v, want EPERM", err)
+	}
+}
+
+// The reply to AUDIT_GET carries port id 0 like the kernel's.
+func TestAuditFakeKernelIsEnabled(t *testing.T) {
+	k := NewAuditFakeKernel()
+	s := k.Dial()
+	defer s.Close()
+	if err := AuditIsEnabled(s); err != nil {
+		t.Fatal(err)
+	}
+	if ParsedResult.Enabled != 1 {
+		t.Fatalf("got enabled=%d", ParsedResult.Enabled)
+	}
+}
+
+func TestAuditFakeKernelRules(t *testing.T) {
+	k := NewAuditFakeKernel()
+	s := k.Dial()
+	defer s.Close()
+
+	r := &AuditRule{List: "exit", Action: "always", Syscalls: []string{"59"}, Fields: []AuditRuleField{
+		{Name: "arch", Op: "=", Value: "b64"},
+		{Name: "key", Op: "=", Value: "exec"},
+	}}
+	if err := AuditAddRule(s, r); err != nil {
+		t.Fatal(err)
+	}
+	if err := AuditAddRule(s, r); err != syscall.EEXIST {
+		t.Fatalf("second add: got %v, want EEXIST", err)
+	}
+	rules, err := AuditListRules(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := r.Canonical()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 1 || rules[0].String() != want.String() {
+		t.Fatalf("got %v, want [%v]", rules, want)
+	}
+	if n := len(k.Rules()); n != 1 {
+		t.Fatalf("kernel has %d rules", n)
+	}
+
+	if err := AuditDeleteRule(s, r); err != nil {
+		t.Fatal(err)
+	}
+	if err := AuditDeleteRule(s, r); err != syscall.ENOENT {
+		t.Fatalf("second delete: got %v, want ENOENT", err)
+	}
+	if rules, err := AuditListRules(s); err != nil || len(rules) != 0 {
+		t.Fatalf("got %v, %v after delete", rules, err)
+	}
+}
+
+func TestAuditFakeKernelUnprivileged(t *testing.T) {
+	k := NewAuditFakeKernel()
+	k.Unprivileged = true
+	s := k.Dial()
+	defer s.Close()
+
+	if _, err := AuditGetStatus(s); err != nil {
+		t.Fatal(err)
+	}
+	if err := AuditSetStatus(s, &AuditStatus{Mask: AUDIT_STATUS_PID, Pid: 1}); err != syscall.EPERM {
+		t.Fatalf("set: got %v, want EPERM", err)
+	}
+	r := &AuditRule{List: "exit", Action: "always", Syscalls: []string{"all"}}
+	if err := AuditAddRule(s, r); err != syscall.EPERM {
+		t.Fatalf("add: got %v, want EPERM", err)
+	}
+	if err := AuditDeleteRule(s, r); err != syscall.EPERM {
+		t.Fatalf("delete: got %v, want EPERM", err)
+	}
+}
+
+func TestAuditFakeKernelRegistrar(t *testing.T) {
+	k, daemon := newAuditFakeDaemon(t)
+	other := k.Dial()
+	defer other.Close()
+
+	if err := AuditSetStatus(other, &AuditStatus{Mask: AUDIT_STATUS_PID, Pid: 200}); err != syscall.EEXIST {
+		t.Fatalf("second daemon: got %v, want EEXIST", err)
+	}
+	if pid := k.Status().Pid; pid != 100 {
+		t.Fatalf("got pid %d, want 100", pid)
+	}
+
+	serial := k.EmitEvent(
+		AuditFakeRecord{AUDIT_SYSCALL, "arch=c000003e syscall=59 success=yes pid=1 comm=\"ls\""},
+		AuditFakeRecord{AUDIT_EOE, ""},
+	)
+	for _, typ := range []uint16{AUDIT_SYSCALL, AUDIT_EOE} {
+		r := readAuditFakeRecord(t, daemon)
+		if r.Type != typ || r.Serial != serial {
+			t.Fatalf("got type %d serial %d, want %d %d", r.Type, r.Serial, typ, serial)
+		}
+	}
+	if _, err := other.Recvfrom(make([]byte, MAX_AUDIT_MESSAGE_LENGTH), syscall.MSG_DONTWAIT); err != syscall.EAGAIN {
+		t.Fatalf("events went to the other socket: %v", err)
+	}
+
+	//User messages from any socket come back as events
+	if err := AuditSend(other, AUDIT_USER, []byte("hello"), len("hello")); err != nil {
+		t.Fatal(err)
+	}
+	r := readAuditFakeRecord(t, daemon)
+	if r.Type != AUDIT_USER || !strings.Contains(r.Data, "msg='hello'") {
+		t.Fatalf("got %d %q", r.Type, r.Data)
+	}
+
+	//The daemon going away unregisters it
+	daemon.Close()
+	if pid := k.Status().Pid; pid != 0 {
+		t.Fatalf("got pid %d after close", pid)
+	}
+	if err := AuditSetStatus(other, &AuditStatus{Mask: AUDIT_STATUS_PID, Pid: 200}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAuditFakeKernelQueueLimit(t *testing.T) {
+	k, s := newAuditFakeDaemon(t)
+	k.QueueLimit = 2
+	for i := 0; i < 5; i++ {
+		k.Emit(AUDIT_USER, "msg='x'")
+	}
+	if lost := k.Status().Lost; lost != 3 {
+		t.Fatalf("got lost=%d, want 3", lost)
+	}
+
+	b := make([]byte, MAX_AUDIT_MESSAGE_LENGTH)
+	if _, err := s.Recvfrom(b, syscall.MSG_DONTWAIT); err != syscall.ENOBUFS {
+		t.Fatalf("got %v, want ENOBUFS", err)
+	}
+	for i := uint64(1); i <= 2; i++ {
+		if r := readAuditFakeRecord(t, s); r.Serial != i {
+			t.Fatalf("got serial %d, want %d", r.Serial, i)
+		}
+	}
+	if _, err := s.Recvfrom(b, syscall.MSG_DONTWAIT); err != syscall.EAGAIN {
+		t.Fatalf("got %v, want EAGAIN", err)
+	}
+}
+
+func TestAuditFakeKernelDisabled(t *testing.T) {
+	k, s := newAuditFakeDaemon(t)
+	if err := AuditSetStatus(s, &AuditStatus{Mask: AUDIT_STATUS_ENABLED, Enabled: 0}); err != nil {
+		t.Fatal(err)
+	}
+	if serial := k.Emit(AUDIT_USER, "msg='x'"); serial != 0 {
+		t.Fatalf("got serial %d while disabled", serial)
+	}
+	if _, err := s.Recvfrom(make([]byte, MAX_AUDIT_MESSAGE_LENGTH), syscall.MSG_DONTWAIT); err != syscall.EAGAIN {
+		t.Fatalf("got %v, want EAGAIN", err)
+	}
+}
+
+type auditTestOverrunCounter struct {
+	*AuditFakeSocket
+	overruns int32
+}
+
+func (c *auditTestOverrunCounter) countOverrun() {
+	atomic.AddInt32(&c.overruns, 1)
+}
+
+// An overrun nobody reads from errchan does not stall Getreply, the
+// records still queued are delivered.
+func TestAuditFakeKernelGetreplyOverrun(t *testing.T) {
+	k, s := newAuditFakeDaemon(t)
+	k.QueueLimit = 1
+	k.Emit(AUDIT_USER, "msg='a'")
+	k.Emit(AUDIT_USER, "msg='b'")
+
+	c := &auditTestOverrunCounter{AuditFakeSocket: s}
+	msgchan := make(chan syscall.NetlinkMessage, 1)
+	done := make(chan bool, 1)
+	go Getreply(c, msgchan, make(chan error), done)
+	select {
+	case m := <-msgchan:
+		if !strings.HasSuffix(string(m.Data), "msg='a'") {
+			t.Fatalf("got %q", m.Data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Getreply blocked on the overrun")
+	}
+	if n := atomic.LoadInt32(&c.overruns); n != 1 {
+		t.Fatalf("counted %d overruns", n)
+	}
+	done <- true
+	s.Close()
+}
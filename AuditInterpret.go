@@ -0,0 +1,443 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+//go:generate go run AuditSyscallsGen.go
+
+const (
+	AUDIT_PASSWD_FILE = "/etc/passwd"
+	AUDIT_GROUP_FILE  = "/etc/group"
+	AUDIT_UNSET_ID    = 4294967295 /* (uid_t)-1, e.g. auid before login */
+)
+
+var ErrAuditBadSockaddr = errors.New("audit interpret: malformed saddr")
+
+var auditArchNames = map[uint32]string{
+	AUDIT_ARCH_AARCH64:  "aarch64",
+	AUDIT_ARCH_ALPHA:    "alpha",
+	AUDIT_ARCH_ARM:      "arm",
+	AUDIT_ARCH_ARMEB:    "armeb",
+	AUDIT_ARCH_I386:     "i386",
+	AUDIT_ARCH_IA64:     "ia64",
+	AUDIT_ARCH_MIPS:     "mips",
+	AUDIT_ARCH_MIPSEL:   "mipsel",
+	AUDIT_ARCH_MIPS64:   "mips64",
+	AUDIT_ARCH_MIPSEL64: "mipsel64",
+	AUDIT_ARCH_PPC:      "ppc",
+	AUDIT_ARCH_PPC64:    "ppc64",
+	AUDIT_ARCH_PPC64LE:  "ppc64le",
+	AUDIT_ARCH_S390:     "s390",
+	AUDIT_ARCH_S390X:    "s390x",
+	AUDIT_ARCH_SPARC:    "sparc",
+	AUDIT_ARCH_SPARC64:  "sparc64",
+	AUDIT_ARCH_X86_64:   "x86_64",
+}
+
+// Index is the capability number from linux/capability.h.
+var auditCapabilityNames = []string{
+	"chown", "dac_override", "dac_read_search", "fowner", "fsetid", "kill",
+	"setgid", "setuid", "setpcap", "linux_immutable", "net_bind_service",
+	"net_broadcast", "net_admin", "net_raw", "ipc_lock", "ipc_owner",
+	"sys_module", "sys_rawio", "sys_chroot", "sys_ptrace", "sys_pacct",
+	"sys_admin", "sys_boot", "sys_nice", "sys_resource", "sys_time",
+	"sys_tty_config", "mknod", "lease", "audit_write", "audit_control",
+	"setfcap", "mac_override", "mac_admin", "syslog", "wake_alarm",
+	"block_suspend", "audit_read", "perfmon", "bpf", "checkpoint_restore",
+}
+
+var (
+	auditUidFields = map[string]bool{
+		"uid": true, "euid": true, "suid": true, "fsuid": true, "ouid": true,
+		"auid": true, "old-auid": true, "iuid": true, "inode_uid": true,
+		"sauid": true, "obj_uid": true, "new_uid": true,
+	}
+	auditGidFields = map[string]bool{
+		"gid": true, "egid": true, "sgid": true, "fsgid": true, "ogid": true,
+		"igid": true, "inode_gid": true, "obj_gid": true, "new_gid": true,
+	}
+	auditCapFields = map[string]bool{
+		"cap_pi": true, "cap_pp": true, "cap_pe": true, "cap_pa": true,
+		"cap_fi": true, "cap_fp": true, "old_pi": true, "old_pp": true,
+		"old_pe": true, "old_pa": true, "pi": true, "pp": true, "pe": true,
+		"pa": true, "cap_bset": true,
+	}
+)
+
+// Decoded saddr= value of a SOCKADDR record.
+type AuditSockaddr struct {
+	Family string
+	IP     net.IP
+	Port   int
+	Path   string /* unix sockets, abstract ones start with @ */
+	Pid    uint32 /* netlink */
+	Groups uint32 /* netlink */
+}
+
+func (sa *AuditSockaddr) String() string {
+	switch sa.Family {
+	case "inet", "inet6":
+		return fmt.Sprintf("{ fam=%s laddr=%s lport=%d }", sa.Family, sa.IP, sa.Port)
+	case "local":
+		return fmt.Sprintf("{ fam=local path=%s }", sa.Path)
+	case "netlink":
+		return fmt.Sprintf("{ fam=netlink nlnk-pid=%d nlnk-groups=%d }", sa.Pid, sa.Groups)
+	}
+	return fmt.Sprintf("{ fam=%s }", sa.Family)
+}
+
+// Resolves raw field values to the human readable form ausearch -i prints.
+// uid and gid names come from PasswdFile and GroupFile, which are read
+// lazily and cached until Reload is called.
+type AuditInterpreter struct {
+	PasswdFile string
+	GroupFile  string
+
+	mu     sync.Mutex
+	loaded bool
+	users  map[uint64]string
+	groups map[uint64]string
+}
+
+func NewAuditInterpreter() *AuditInterpreter {
+	return &AuditInterpreter{
+		PasswdFile: AUDIT_PASSWD_FILE,
+		GroupFile:  AUDIT_GROUP_FILE,
+	}
+}
+
+// Rereads the passwd and group files.
+func (in *AuditInterpreter) Reload() error {
+	users, err := readAuditIdFile(in.PasswdFile)
+	if err != nil {
+		return err
+	}
+	groups, err := readAuditIdFile(in.GroupFile)
+	if err != nil {
+		return err
+	}
+	in.mu.Lock()
+	in.users, in.groups, in.loaded = users, groups, true
+	in.mu.Unlock()
+	return nil
+}
+
+// passwd and group share the name:x:id: layout.
+func readAuditIdFile(path string) (map[uint64]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ids := make(map[uint64]string)
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := sc.Text()
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 4)
+		if len(parts) < 3 {
+			continue
+		}
+		id, err := strconv.ParseUint(parts[2], 10, 32)
+		if err != nil {
+			continue
+		}
+		if _, ok := ids[id]; !ok {
+			ids[id] = parts[0]
+		}
+	}
+	return ids, sc.Err()
+}
+
+func (in *AuditInterpreter) lookupId(group bool, value string) string {
+	id, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return value
+	}
+	if id == AUDIT_UNSET_ID {
+		return "unset"
+	}
+	in.mu.Lock()
+	loaded := in.loaded
+	in.mu.Unlock()
+	if !loaded && in.Reload() != nil {
+		//Unreadable files only mean numeric output, do not retry on every field
+		in.mu.Lock()
+		in.loaded = true
+		in.mu.Unlock()
+	}
+
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	table := in.users
+	if group {
+		table = in.groups
+	}
+	if name, ok := table[id]; ok {
+		return name
+	}
+	return "unknown(" + value + ")"
+}
+
+func (in *AuditInterpreter) UserName(value string) string {
+	return in.lookupId(false, value)
+}
+
+func (in *AuditInterpreter) GroupName(value string) string {
+	return in.lookupId(true, value)
+}
+
+// Sets Interp on every field of every record of the event.
+func (in *AuditInterpreter) InterpretEvent(ev *AuditEvent) {
+	for _, r := range ev.Records {
+		in.InterpretRecord(r)
+	}
+}
+
+// Returns a copy of ev with Interp set, leaving ev alone. For events that
+// are shared with other consumers.
+func (in *AuditInterpreter) InterpretedCopy(ev *AuditEvent) *AuditEvent {
+	c := *ev
+	c.Records = make([]*AuditRecord, len(ev.Records))
+	for i, r := range ev.Records {
+		rc := *r
+		rc.Fields = append([]AuditField(nil), r.Fields...)
+		in.InterpretRecord(&rc)
+		c.Records[i] = &rc
+	}
+	return &c
+}
+
+// Sets Interp on the fields of r that have an interpretation.
+func (in *AuditInterpreter) InterpretRecord(r *AuditRecord) {
+	arch := uint32(0)
+	if a, err := strconv.ParseUint(r.Value("arch"), 16, 32); err == nil {
+		arch = uint32(a)
+	}
+	for i := range r.Fields {
+		f := &r.Fields[i]
+		if f.Enriched || f.Null {
+			continue
+		}
+		//auditd already resolved some fields when logging in ENRICHED format
+		if v, ok := r.EnrichedValue(strings.ToUpper(f.Name)); ok {
+			f.Interp = v
+			continue
+		}
+		f.Interp = in.interpretField(arch, f)
+	}
+}
+
+func (in *AuditInterpreter) interpretField(arch uint32, f *AuditField) string {
+	switch {
+	case auditUidFields[f.Name]:
+		return in.UserName(f.Value)
+	case auditGidFields[f.Name]:
+		return in.GroupName(f.Value)
+	case auditCapFields[f.Name]:
+		return AuditCapabilitySetNames(f.Value)
+	}
+	switch f.Name {
+	case "arch":
+		return AuditArchName(f.Value)
+	case "syscall":
+		return AuditSyscallName(arch, f.Value)
+	case "saddr":
+		sa, err := ParseAuditSockaddr(f.Value)
+		if err != nil {
+			return ""
+		}
+		return sa.String()
+	case "proctitle":
+		return strings.Join(AuditProctitleArgv(f.Value), " ")
+	case "mode":
+		return AuditModeString(f.Value)
+	case "capability":
+		n, err := strconv.Atoi(f.Value)
+		if err != nil || n < 0 || n >= len(auditCapabilityNames) {
+			return ""
+		}
+		return auditCapabilityNames[n]
+	}
+	return ""
+}
+
+// arch=c000003e -> x86_64
+func AuditArchName(value string) string {
+	a, err := strconv.ParseUint(value, 16, 32)
+	if err != nil {
+		return value
+	}
+	if name, ok := auditArchNames[uint32(a)]; ok {
+		return name
+	}
+	return "unknown-arch(" + value + ")"
+}
+
+// Resolves a syscall number for the given AUDIT_ARCH_* value. When arch is
+// unknown the table of the running machine is used.
+func AuditSyscallName(arch uint32, value string) string {
+	nr, err := strconv.Atoi(value)
+	if err != nil {
+		return value
+	}
+	table, ok := auditSyscallTables[arch]
+	if !ok {
+		table = auditSyscallTables[auditHostArch()]
+	}
+	if name, ok := table[nr]; ok {
+		return name
+	}
+	return "unknown-syscall(" + value + ")"
+}
+
+func auditHostArch() uint32 {
+	switch runtime.GOARCH {
+	case "386":
+		return AUDIT_ARCH_I386
+	case "arm64":
+		return AUDIT_ARCH_AARCH64
+	}
+	return AUDIT_ARCH_X86_64
+}
+
+// Reverse of AuditSyscallName, used when building rules and queries by name.
+func AuditSyscallNumber(arch uint32, name string) (int, bool) {
+	table, ok := auditSyscallTables[arch]
+	if !ok {
+		table = auditSyscallTables[auditHostArch()]
+	}
+	for nr, n := range table {
+		if n == name {
+			return nr, true
+		}
+	}
+	return 0, false
+}
+
+// The kernel logs the raw command line, arguments separated by NUL.
+func AuditProctitleArgv(value string) []string {
+	return strings.Split(strings.TrimRight(value, "\x00"), "\x00")
+}
+
+// mode=0100644 -> file,644 and mode=041777 -> dir,sticky,777
+func AuditModeString(value string) string {
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil {
+		return value
+	}
+	var parts []string
+	switch mode & syscall.S_IFMT {
+	case syscall.S_IFSOCK:
+		parts = append(parts, "socket")
+	case syscall.S_IFLNK:
+		parts = append(parts, "link")
+	case syscall.S_IFREG:
+		parts = append(parts, "file")
+	case syscall.S_IFBLK:
+		parts = append(parts, "block")
+	case syscall.S_IFDIR:
+		parts = append(parts, "dir")
+	case syscall.S_IFCHR:
+		parts = append(parts, "character")
+	case syscall.S_IFIFO:
+		parts = append(parts, "fifo")
+	}
+	if mode&04000 != 0 {
+		parts = append(parts, "suid")
+	}
+	if mode&02000 != 0 {
+		parts = append(parts, "sgid")
+	}
+	if mode&01000 != 0 {
+		parts = append(parts, "sticky")
+	}
+	parts = append(parts, fmt.Sprintf("%03o", mode&0777))
+	return strings.Join(parts, ",")
+}
+
+// cap_pp=0000003fffffffff -> chown,dac_override,...
+func AuditCapabilitySetNames(value string) string {
+	bits, err := strconv.ParseUint(value, 16, 64)
+	if err != nil {
+		return value
+	}
+	if bits == 0 {
+		return "none"
+	}
+	var names []string
+	for i := 0; i < 64; i++ {
+		if bits&(1<<uint(i)) == 0 {
+			continue
+		}
+		if i < len(auditCapabilityNames) {
+			names = append(names, auditCapabilityNames[i])
+		} else {
+			names = append(names, "cap_"+strconv.Itoa(i))
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// Decodes the hex dump of a struct sockaddr. The family is in host order,
+// ports are in network order.
+func ParseAuditSockaddr(value string) (*AuditSockaddr, error) {
+	b, err := hex.DecodeString(value)
+	if err != nil || len(b) < 2 {
+		return nil, ErrAuditBadSockaddr
+	}
+	family := nativeEndian().Uint16(b[0:2])
+	sa := &AuditSockaddr{}
+	switch family {
+	case 1: //AF_LOCAL
+		sa.Family = "local"
+		path := b[2:]
+		if len(path) > 0 && path[0] == 0 {
+			sa.Path = "@" + strings.TrimRight(string(path[1:]), "\x00")
+		} else {
+			if i := strings.IndexByte(string(path), 0); i >= 0 {
+				path = path[:i]
+			}
+			sa.Path = string(path)
+		}
+	case 2: //AF_INET
+		if len(b) < 8 {
+			return nil, ErrAuditBadSockaddr
+		}
+		sa.Family = "inet"
+		sa.Port = int(binary.BigEndian.Uint16(b[2:4]))
+		sa.IP = net.IP(b[4:8])
+	case 10: //AF_INET6
+		if len(b) < 24 {
+			return nil, ErrAuditBadSockaddr
+		}
+		sa.Family = "inet6"
+		sa.Port = int(binary.BigEndian.Uint16(b[2:4]))
+		sa.IP = net.IP(b[8:24])
+	case 16: //AF_NETLINK
+		if len(b) < 12 {
+			return nil, ErrAuditBadSockaddr
+		}
+		sa.Family = "netlink"
+		sa.Pid = nativeEndian().Uint32(b[4:8])
+		sa.Groups = nativeEndian().Uint32(b[8:12])
+	default:
+		sa.Family = "unknown-family(" + strconv.Itoa(int(family)) + ")"
+	}
+	return sa, nil
+}
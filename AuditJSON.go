@@ -0,0 +1,326 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Bumped whenever a field is renamed, removed or changes type. Adding new
+// optional fields does not change the version.
+const AUDIT_JSON_SCHEMA_VERSION = 1
+
+// JSON form of an assembled event, one object per line. Schema version 1:
+//
+//	schema_version  int       always AUDIT_JSON_SCHEMA_VERSION
+//	timestamp       string    RFC 3339 with nanoseconds, UTC
+//	serial          int       audit serial number
+//	node            string    node= name, offline logs only
+//	complete        bool      false if the event timed out or was evicted
+//	record_types    []string  record type names in arrival order
+//	records         []object  type, type_id, raw, fields, interpreted
+//	process         object    pid, ppid, comm, exe, tty, cwd, argv, title
+//	user            object    auid, uid, euid, suid, fsuid, gid, egid, ses and names
+//	syscall         object    arch, number, name, success, exit, items, keys
+//	file            []object  one per PATH record
+//	network         object    decoded SOCKADDR
+//	container       object    cgroup, runtime, id, pod, namespaces, contid
+//	lost            object    reason, count, from, to, first_serial, last_serial
+//
+// Sections that do not apply to an event are omitted. Numeric ids are JSON
+// numbers, unset ids (4294967295) are kept as is.
+type AuditJSONEvent struct {
+	SchemaVersion int                 `json:"schema_version"`
+	Timestamp     string              `json:"timestamp"`
+	Serial        uint64              `json:"serial"`
+	Node          string              `json:"node,omitempty"`
+	Complete      bool                `json:"complete"`
+	RecordTypes   []string            `json:"record_types"`
+	Records       []AuditJSONRecord   `json:"records"`
+	Process       *AuditJSONProcess   `json:"process,omitempty"`
+	User          *AuditJSONUser      `json:"user,omitempty"`
+	Syscall       *AuditJSONSyscall   `json:"syscall,omitempty"`
+	File          []AuditJSONFile     `json:"file,omitempty"`
+	Network       *AuditJSONNetwork   `json:"network,omitempty"`
+	Container     *AuditJSONContainer `json:"container,omitempty"`
+	Lost          *AuditJSONLost      `json:"lost,omitempty"`
+}
+
+type AuditJSONRecord struct {
+	Type        string            `json:"type"`
+	TypeID      uint16            `json:"type_id"`
+	Raw         string            `json:"raw"`
+	Fields      map[string]string `json:"fields"`
+	Interpreted map[string]string `json:"interpreted,omitempty"`
+}
+
+type AuditJSONProcess struct {
+	Pid   *int64   `json:"pid,omitempty"`
+	Ppid  *int64   `json:"ppid,omitempty"`
+	Comm  string   `json:"comm,omitempty"`
+	Exe   string   `json:"exe,omitempty"`
+	Tty   string   `json:"tty,omitempty"`
+	Cwd   string   `json:"cwd,omitempty"`
+	Argv  []string `json:"argv,omitempty"`
+	Title string   `json:"title,omitempty"`
+}
+
+type AuditJSONUser struct {
+	Auid  *int64            `json:"auid,omitempty"`
+	Uid   *int64            `json:"uid,omitempty"`
+	Euid  *int64            `json:"euid,omitempty"`
+	Suid  *int64            `json:"suid,omitempty"`
+	Fsuid *int64            `json:"fsuid,omitempty"`
+	Gid   *int64            `json:"gid,omitempty"`
+	Egid  *int64            `json:"egid,omitempty"`
+	Ses   *int64            `json:"ses,omitempty"`
+	Names map[string]string `json:"names,omitempty"`
+}
+
+type AuditJSONSyscall struct {
+	Arch    string   `json:"arch,omitempty"`
+	Number  *int64   `json:"number,omitempty"`
+	Name    string   `json:"name,omitempty"`
+	Success *bool    `json:"success,omitempty"`
+	Exit    *int64   `json:"exit,omitempty"`
+	Items   *int64   `json:"items,omitempty"`
+	Keys    []string `json:"keys,omitempty"`
+}
+
+type AuditJSONFile struct {
+	Item     *int64 `json:"item,omitempty"`
+	Path     string `json:"path,omitempty"`
+	Inode    *int64 `json:"inode,omitempty"`
+	Mode     string `json:"mode,omitempty"`
+	Ouid     *int64 `json:"ouid,omitempty"`
+	Ogid     *int64 `json:"ogid,omitempty"`
+	Nametype string `json:"nametype,omitempty"`
+}
+
+type AuditJSONNetwork struct {
+	Family  string `json:"family"`
+	Address string `json:"address,omitempty"`
+	Port    *int64 `json:"port,omitempty"`
+	Path    string `json:"path,omitempty"`
+}
+
+type AuditJSONContainer struct {
+	Cgroup     string            `json:"cgroup,omitempty"`
+	Runtime    string            `json:"runtime,omitempty"`
+	ID         string            `json:"id,omitempty"`
+	Pod        string            `json:"pod,omitempty"`
+	Namespaces map[string]uint64 `json:"namespaces,omitempty"`
+	ContID     string            `json:"contid,omitempty"`
+}
+
+type AuditJSONLost struct {
+	Reason      string `json:"reason"`
+	Count       uint64 `json:"count"`
+	From        string `json:"from,omitempty"`
+	To          string `json:"to,omitempty"`
+	FirstSerial uint64 `json:"first_serial,omitempty"`
+	LastSerial  uint64 `json:"last_serial,omitempty"`
+}
+
+func auditJSONInt(v string) *int64 {
+	if v == "" {
+		return nil
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return nil
+	}
+	return &n
+}
+
+// Converts an event to its schema form. Interpreted values are only present
+// if the event went through an AuditInterpreter first.
+func NewAuditJSONEvent(ev *AuditEvent) *AuditJSONEvent {
+	out := &AuditJSONEvent{
+		SchemaVersion: AUDIT_JSON_SCHEMA_VERSION,
+		Timestamp:     ev.Timestamp.UTC().Format(time.RFC3339Nano),
+		Serial:        ev.Serial,
+		Node:          ev.Node,
+		Complete:      ev.Complete,
+		RecordTypes:   make([]string, 0, len(ev.Records)),
+		Records:       make([]AuditJSONRecord, 0, len(ev.Records)),
+	}
+	for _, r := range ev.Records {
+		out.RecordTypes = append(out.RecordTypes, r.TypeName)
+		jr := AuditJSONRecord{Type: r.TypeName, TypeID: r.Type, Raw: r.Data, Fields: make(map[string]string)}
+		for _, f := range r.Fields {
+			if f.Enriched {
+				continue
+			}
+			if _, dup := jr.Fields[f.Name]; !dup {
+				jr.Fields[f.Name] = f.Value
+			}
+			if f.Interp != "" {
+				if jr.Interpreted == nil {
+					jr.Interpreted = make(map[string]string)
+				}
+				jr.Interpreted[f.Name] = f.Interp
+			}
+		}
+		out.Records = append(out.Records, jr)
+	}
+
+	out.Process = newAuditJSONProcess(ev)
+	out.User = newAuditJSONUser(ev)
+	if sc := ev.Record(AUDIT_SYSCALL); sc != nil {
+		out.Syscall = newAuditJSONSyscall(sc)
+	}
+	for _, r := range ev.Records {
+		if r.Type != AUDIT_PATH {
+			continue
+		}
+		out.File = append(out.File, AuditJSONFile{
+			Item:     auditJSONInt(r.Value("item")),
+			Path:     r.Value("name"),
+			Inode:    auditJSONInt(r.Value("inode")),
+			Mode:     r.Value("mode"),
+			Ouid:     auditJSONInt(r.Value("ouid")),
+			Ogid:     auditJSONInt(r.Value("ogid")),
+			Nametype: r.Value("nametype"),
+		})
+	}
+	if r := ev.Record(AUDIT_SOCKADDR); r != nil {
+		if sa, err := ParseAuditSockaddr(r.Value("saddr")); err == nil {
+			n := &AuditJSONNetwork{Family: sa.Family, Path: sa.Path}
+			if sa.IP != nil {
+				n.Address = sa.IP.String()
+				port := int64(sa.Port)
+				n.Port = &port
+			}
+			out.Network = n
+		}
+	}
+	if c := ev.Container; c != nil {
+		out.Container = &AuditJSONContainer{
+			Cgroup:     c.Cgroup,
+			Runtime:    c.Runtime,
+			ID:         c.ID,
+			Pod:        c.Pod,
+			Namespaces: c.Namespaces,
+			ContID:     c.ContID,
+		}
+	}
+	if l := ev.Lost; l != nil {
+		out.Lost = &AuditJSONLost{Reason: l.Reason, Count: l.Count, FirstSerial: l.FirstSerial, LastSerial: l.LastSerial}
+		if !l.From.IsZero() {
+			out.Lost.From = l.From.UTC().Format(time.RFC3339Nano)
+		}
+		if !l.To.IsZero() {
+			out.Lost.To = l.To.UTC().Format(time.RFC3339Nano)
+		}
+	}
+	return out
+}
+
+func newAuditJSONProcess(ev *AuditEvent) *AuditJSONProcess {
+	p := &AuditJSONProcess{
+		Pid:  auditJSONInt(ev.Value("pid")),
+		Ppid: auditJSONInt(ev.Value("ppid")),
+		Comm: ev.Value("comm"),
+		Exe:  ev.Value("exe"),
+		Tty:  ev.Value("tty"),
+		Argv: ev.Argv,
+	}
+	if r := ev.Record(AUDIT_CWD); r != nil {
+		p.Cwd = r.Value("cwd")
+	}
+	if r := ev.Record(AUDIT_PROCTITLE); r != nil {
+		p.Title = r.Interpreted("proctitle")
+	}
+	if p.Pid == nil && p.Exe == "" && p.Comm == "" && p.Cwd == "" && p.Argv == nil {
+		return nil
+	}
+	return p
+}
+
+func newAuditJSONUser(ev *AuditEvent) *AuditJSONUser {
+	u := &AuditJSONUser{
+		Auid:  auditJSONInt(ev.Value("auid")),
+		Uid:   auditJSONInt(ev.Value("uid")),
+		Euid:  auditJSONInt(ev.Value("euid")),
+		Suid:  auditJSONInt(ev.Value("suid")),
+		Fsuid: auditJSONInt(ev.Value("fsuid")),
+		Gid:   auditJSONInt(ev.Value("gid")),
+		Egid:  auditJSONInt(ev.Value("egid")),
+		Ses:   auditJSONInt(ev.Value("ses")),
+	}
+	for _, name := range []string{"auid", "uid", "euid", "suid", "fsuid", "gid", "egid"} {
+		for _, r := range ev.Records {
+			f, ok := r.Field(name)
+			if !ok {
+				continue
+			}
+			if f.Interp != "" {
+				if u.Names == nil {
+					u.Names = make(map[string]string)
+				}
+				u.Names[name] = f.Interp
+			}
+			break
+		}
+	}
+	if u.Auid == nil && u.Uid == nil && u.Euid == nil && u.Ses == nil {
+		return nil
+	}
+	return u
+}
+
+func newAuditJSONSyscall(r *AuditRecord) *AuditJSONSyscall {
+	s := &AuditJSONSyscall{
+		Arch:   r.Interpreted("arch"),
+		Number: auditJSONInt(r.Value("syscall")),
+		Exit:   auditJSONInt(r.Value("exit")),
+		Items:  auditJSONInt(r.Value("items")),
+		Keys:   r.Keys(),
+	}
+	if f, ok := r.Field("syscall"); ok {
+		s.Name = f.Interp
+	}
+	if v := r.Value("success"); v != "" {
+		ok := v == "yes"
+		s.Success = &ok
+	}
+	return s
+}
+
+// Writes events as newline delimited JSON. Safe for concurrent use; the
+// events are not modified, interpretation happens on a copy.
+type AuditJSONWriter struct {
+	Interp *AuditInterpreter /* optional, fills the interpreted values */
+
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func NewAuditJSONWriter(w io.Writer, interp *AuditInterpreter) *AuditJSONWriter {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return &AuditJSONWriter{Interp: interp, enc: enc}
+}
+
+func (jw *AuditJSONWriter) WriteEvent(ev *AuditEvent) error {
+	if jw.Interp != nil {
+		ev = jw.Interp.InterpretedCopy(ev)
+	}
+	out := NewAuditJSONEvent(ev)
+	jw.mu.Lock()
+	defer jw.mu.Unlock()
+	return jw.enc.Encode(out)
+}
+
+// Writes every event from evchan until it is closed, e.g. the output of
+// AuditEventStream. Write errors go to errchan.
+func AuditJSONStream(jw *AuditJSONWriter, evchan <-chan *AuditEvent, errchan chan<- error) {
+	for ev := range evchan {
+		if err := jw.WriteEvent(ev); err != nil {
+			errchan <- err
+		}
+	}
+}
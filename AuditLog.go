@@ -0,0 +1,443 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log/syslog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	AUDIT_LOG_FILE             = "/var/log/audit/audit.log"
+	AUDIT_LOG_DEFAULT_MAX_SIZE = 8 * 1024 * 1024 /* max_log_file = 8 (MB) */
+	AUDIT_LOG_DEFAULT_NUM_LOGS = 5
+	AUDIT_LOG_DEFAULT_FREQ     = 50
+	AUDIT_LOG_SPACE_CHECK      = 5 * time.Second
+
+	/* flush = */
+	AUDIT_FLUSH_NONE        = 0 /* buffered, written when the buffer fills, on Flush, rotation and Close */
+	AUDIT_FLUSH_INCREMENTAL = 1 /* fdatasync every Freq records */
+	AUDIT_FLUSH_DATA        = 2 /* fdatasync every record */
+	AUDIT_FLUSH_SYNC        = 3 /* fsync every record */
+
+	/* max_log_file_action, space_left_action, admin_space_left_action, disk_full_action */
+	AUDIT_LOG_ACTION_IGNORE     = 0
+	AUDIT_LOG_ACTION_SYSLOG     = 1
+	AUDIT_LOG_ACTION_SUSPEND    = 2 /* stop writing until Resume or space comes back */
+	AUDIT_LOG_ACTION_ROTATE     = 3 /* rotate, dropping logs beyond NumLogs */
+	AUDIT_LOG_ACTION_KEEP_LOGS  = 4 /* rotate without ever deleting */
+	AUDIT_LOG_ACTION_HALT_AUDIT = 5 /* stop writing for good and call OnHalt */
+)
+
+var (
+	ErrAuditLogSuspended = errors.New("audit log: logging suspended")
+	ErrAuditLogHalted    = errors.New("audit log: logging halted")
+)
+
+// Mirrors the log related settings of auditd.conf. Sizes are in bytes.
+type AuditLogConfig struct {
+	Path                 string
+	Node                 string /* written as node= prefix when set */
+	MaxLogFile           int64
+	MaxLogFileAction     int
+	NumLogs              int
+	Flush                int
+	Freq                 int
+	SpaceLeft            int64
+	SpaceLeftAction      int
+	AdminSpaceLeft       int64
+	AdminSpaceLeftAction int
+	DiskFullAction       int
+	OnHalt               func() /* e.g. disable kernel auditing */
+}
+
+func DefaultAuditLogConfig() AuditLogConfig {
+	return AuditLogConfig{
+		Path:                 AUDIT_LOG_FILE,
+		MaxLogFile:           AUDIT_LOG_DEFAULT_MAX_SIZE,
+		MaxLogFileAction:     AUDIT_LOG_ACTION_ROTATE,
+		NumLogs:              AUDIT_LOG_DEFAULT_NUM_LOGS,
+		Flush:                AUDIT_FLUSH_INCREMENTAL,
+		Freq:                 AUDIT_LOG_DEFAULT_FREQ,
+		SpaceLeft:            75 * 1024 * 1024,
+		SpaceLeftAction:      AUDIT_LOG_ACTION_SYSLOG,
+		AdminSpaceLeft:       50 * 1024 * 1024,
+		AdminSpaceLeftAction: AUDIT_LOG_ACTION_SUSPEND,
+		DiskFullAction:       AUDIT_LOG_ACTION_SUSPEND,
+	}
+}
+
+// Writes records in the /var/log/audit/audit.log format so ausearch and
+// aureport can read the result. Safe for concurrent use.
+type AuditLogWriter struct {
+	cfg AuditLogConfig
+
+	mu           sync.Mutex
+	file         *os.File /* nil after a failed reopen, retried on the next write */
+	buf          *bufio.Writer
+	size         int64
+	unsynced     int
+	suspended    bool
+	spaceSuspend bool /* suspended for lack of disk space, lifted when it comes back */
+	halted       bool
+	warnedSize   bool
+	spaceLevel   int /* 0, 1 below space_left, 2 below admin_space_left */
+	lastCheck    time.Time
+	now          func() time.Time
+	freeSpace    func(dir string) (int64, error)
+}
+
+func NewAuditLogWriter(cfg AuditLogConfig) (*AuditLogWriter, error) {
+	if cfg.Path == "" {
+		cfg.Path = AUDIT_LOG_FILE
+	}
+	if cfg.NumLogs < 1 {
+		cfg.NumLogs = 1
+	}
+	if cfg.Freq <= 0 {
+		cfg.Freq = AUDIT_LOG_DEFAULT_FREQ
+	}
+	w := &AuditLogWriter{cfg: cfg, now: time.Now, freeSpace: auditLogFreeSpace}
+	if err := os.MkdirAll(filepath.Dir(cfg.Path), 0700); err != nil {
+		return nil, err
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *AuditLogWriter) open() error {
+	f, err := os.OpenFile(w.cfg.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.file = f
+	w.buf = bufio.NewWriter(f)
+	w.size = st.Size()
+	w.warnedSize = false
+	return nil
+}
+
+func formatAuditStamp(t time.Time, serial uint64) string {
+	return fmt.Sprintf("audit(%d.%03d:%d)", t.Unix(), t.Nanosecond()/int(time.Millisecond), serial)
+}
+
+// type=SYSCALL msg=audit(1364481363.243:24287): arch=c000003e ...
+func FormatAuditLogLine(r *AuditRecord) string {
+	line := "type=" + r.TypeName + " msg=" + formatAuditStamp(r.Timestamp, r.Serial) + ": " + r.Data
+	if r.Node != "" {
+		line = "node=" + r.Node + " " + line
+	}
+	return line
+}
+
+// Same as auditd: the netlink payload is logged verbatim after the type.
+// A "node=" prefix of the payload (see AuditLogReplay) goes in front.
+func FormatAuditLogMessage(m syscall.NetlinkMessage) string {
+	msg := strings.TrimRight(string(m.Data), "\x00\n")
+	node := ""
+	if strings.HasPrefix(msg, "node=") {
+		if end := strings.IndexByte(msg, ' '); end > 0 {
+			node, msg = msg[:end+1], msg[end+1:]
+		}
+	}
+	return node + "type=" + AuditMsgTypeName(m.Header.Type) + " msg=" + msg
+}
+
+func (w *AuditLogWriter) WriteMessage(m syscall.NetlinkMessage) error {
+	if m.Header.Type == AUDIT_EOE || !isAuditEventRecord(m.Header.Type) {
+		return nil
+	}
+	return w.WriteLine(FormatAuditLogMessage(m))
+}
+
+func (w *AuditLogWriter) WriteRecord(r *AuditRecord) error {
+	if r.Type == AUDIT_EOE {
+		return nil
+	}
+	return w.WriteLine(FormatAuditLogLine(r))
+}
+
+func (w *AuditLogWriter) WriteEvent(ev *AuditEvent) error {
+	for _, r := range ev.Records {
+		if err := w.WriteRecord(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Writes one preformatted log line; a trailing newline is added. Node is
+// prepended unless the line already carries one.
+func (w *AuditLogWriter) WriteLine(line string) error {
+	if w.cfg.Node != "" && !strings.HasPrefix(line, "node=") {
+		line = "node=" + w.cfg.Node + " " + line
+	}
+	line = strings.TrimRight(line, "\n") + "\n"
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.halted {
+		return ErrAuditLogHalted
+	}
+	if err := w.checkSpace(); err != nil {
+		return err
+	}
+	if w.suspended {
+		return ErrAuditLogSuspended
+	}
+	if w.file == nil {
+		if err := w.open(); err != nil {
+			return err
+		}
+	}
+	if w.cfg.MaxLogFile > 0 && w.size+int64(len(line)) > w.cfg.MaxLogFile {
+		if err := w.sizeExceeded(); err != nil {
+			return err
+		}
+	}
+
+	n, err := w.buf.WriteString(line)
+	w.size += int64(n)
+	if err == nil {
+		err = w.sync()
+	}
+	if err != nil {
+		if errors.Is(err, syscall.ENOSPC) {
+			w.act(w.cfg.DiskFullAction, true, "audit log: disk full")
+		}
+		return err
+	}
+	return nil
+}
+
+func (w *AuditLogWriter) sync() error {
+	switch w.cfg.Flush {
+	case AUDIT_FLUSH_NONE:
+		return nil
+	case AUDIT_FLUSH_INCREMENTAL:
+		w.unsynced++
+		if w.unsynced < w.cfg.Freq {
+			return nil
+		}
+		w.unsynced = 0
+		if err := w.buf.Flush(); err != nil {
+			return err
+		}
+		return syscall.Fdatasync(int(w.file.Fd()))
+	case AUDIT_FLUSH_DATA:
+		if err := w.buf.Flush(); err != nil {
+			return err
+		}
+		return syscall.Fdatasync(int(w.file.Fd()))
+	default:
+		if err := w.buf.Flush(); err != nil {
+			return err
+		}
+		return w.file.Sync()
+	}
+}
+
+func (w *AuditLogWriter) sizeExceeded() error {
+	switch w.cfg.MaxLogFileAction {
+	case AUDIT_LOG_ACTION_ROTATE, AUDIT_LOG_ACTION_KEEP_LOGS:
+		return w.rotate(w.cfg.MaxLogFileAction == AUDIT_LOG_ACTION_KEEP_LOGS)
+	case AUDIT_LOG_ACTION_IGNORE:
+		return nil
+	}
+	if !w.warnedSize {
+		w.warnedSize = true
+		if err := w.act(w.cfg.MaxLogFileAction, false, "audit log: "+w.cfg.Path+" reached max_log_file"); err != nil {
+			return err
+		}
+	}
+	if w.suspended {
+		return ErrAuditLogSuspended
+	}
+	if w.halted {
+		return ErrAuditLogHalted
+	}
+	return nil
+}
+
+// Checks free space on the log partition every AUDIT_LOG_SPACE_CHECK. Like
+// auditd an action is taken once when free space drops below a threshold,
+// and again only after it has recovered and dropped again.
+func (w *AuditLogWriter) checkSpace() error {
+	now := w.now()
+	if now.Sub(w.lastCheck) < AUDIT_LOG_SPACE_CHECK {
+		return nil
+	}
+	w.lastCheck = now
+
+	free, err := w.freeSpace(filepath.Dir(w.cfg.Path))
+	if err != nil {
+		return nil
+	}
+	level := 0
+	switch {
+	case w.cfg.AdminSpaceLeft > 0 && free < w.cfg.AdminSpaceLeft:
+		level = 2
+	case w.cfg.SpaceLeft > 0 && free < w.cfg.SpaceLeft:
+		level = 1
+	}
+	prev := w.spaceLevel
+	w.spaceLevel = level
+	switch {
+	case level == 2 && prev < 2:
+		return w.act(w.cfg.AdminSpaceLeftAction, true, fmt.Sprintf("audit log: admin_space_left reached, %d bytes free", free))
+	case level == 1 && prev < 1:
+		return w.act(w.cfg.SpaceLeftAction, true, fmt.Sprintf("audit log: space_left reached, %d bytes free", free))
+	case level == 0 && w.suspended && w.spaceSuspend:
+		//Space came back, a max_log_file suspension stays until Resume
+		w.suspended, w.spaceSuspend = false, false
+		auditLogSyslog("audit log: free space recovered, resuming logging")
+	}
+	return nil
+}
+
+func auditLogFreeSpace(dir string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
+
+// Carries out a *_action. space tells whether it was triggered by free
+// disk space, only those suspensions end on their own.
+func (w *AuditLogWriter) act(action int, space bool, msg string) error {
+	switch action {
+	case AUDIT_LOG_ACTION_SYSLOG:
+		auditLogSyslog(msg)
+	case AUDIT_LOG_ACTION_SUSPEND:
+		if !w.suspended {
+			auditLogSyslog(msg + ", suspending logging")
+			w.spaceSuspend = space
+		} else if !space {
+			w.spaceSuspend = false
+		}
+		w.suspended = true
+	case AUDIT_LOG_ACTION_ROTATE, AUDIT_LOG_ACTION_KEEP_LOGS:
+		auditLogSyslog(msg + ", rotating")
+		return w.rotate(action == AUDIT_LOG_ACTION_KEEP_LOGS)
+	case AUDIT_LOG_ACTION_HALT_AUDIT:
+		auditLogSyslog(msg + ", halting audit logging")
+		w.halted = true
+		if w.cfg.OnHalt != nil {
+			go w.cfg.OnHalt()
+		}
+	}
+	return nil
+}
+
+func auditLogSyslog(msg string) {
+	l, err := syslog.New(syslog.LOG_WARNING|syslog.LOG_DAEMON, "auditd")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, msg)
+		return
+	}
+	l.Warning(msg)
+	l.Close()
+}
+
+func (w *AuditLogWriter) Rotate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.rotate(w.cfg.MaxLogFileAction == AUDIT_LOG_ACTION_KEEP_LOGS)
+}
+
+// audit.log -> audit.log.1 -> ... -> audit.log.<NumLogs-1>. With keep the
+// chain is extended instead of dropping the oldest file.
+func (w *AuditLogWriter) rotate(keep bool) error {
+	if w.file != nil {
+		if err := w.buf.Flush(); err != nil {
+			return err
+		}
+		w.file.Sync()
+		w.file.Close()
+		w.file = nil
+	}
+
+	last := w.cfg.NumLogs - 1
+	if keep {
+		last = 1
+		for {
+			if _, err := os.Stat(w.cfg.Path + "." + strconv.Itoa(last)); err != nil {
+				break
+			}
+			last++
+		}
+	} else {
+		os.Remove(w.cfg.Path + "." + strconv.Itoa(last))
+	}
+	for i := last - 1; i >= 1; i-- {
+		os.Rename(w.cfg.Path+"."+strconv.Itoa(i), w.cfg.Path+"."+strconv.Itoa(i+1))
+	}
+	if last >= 1 {
+		if err := os.Rename(w.cfg.Path, w.cfg.Path+".1"); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	} else if err := os.Truncate(w.cfg.Path, 0); err != nil {
+		return err
+	}
+	return w.open()
+}
+
+// Lifts a suspension, like SIGUSR2 to auditd.
+func (w *AuditLogWriter) Resume() {
+	w.mu.Lock()
+	w.suspended, w.spaceSuspend = false, false
+	w.mu.Unlock()
+}
+
+func (w *AuditLogWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	if err := w.buf.Flush(); err != nil {
+		return err
+	}
+	return w.file.Sync()
+}
+
+// Writes out what is still buffered, with AUDIT_FLUSH_NONE that can be
+// everything since the buffer last filled.
+func (w *AuditLogWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	err := w.buf.Flush()
+	if cerr := w.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Logs every message from msgchan until it is closed, e.g. the output of
+// Getreply. Write errors go to errchan.
+func AuditLogStream(w *AuditLogWriter, msgchan <-chan syscall.NetlinkMessage, errchan chan<- error) {
+	for m := range msgchan {
+		if err := w.WriteMessage(m); err != nil {
+			errchan <- err
+		}
+	}
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// A line of a log file that could not be turned into a message. Reading can
+// continue after it.
+type AuditLogParseError struct {
+	File string
+	Line int
+	Err  error
+}
+
+func (e *AuditLogParseError) Error() string {
+	return fmt.Sprintf("%s:%d: %v", e.File, e.Line, e.Err)
+}
+
+// Reads raw audit.log files, plain or gzip'd, and hands out the same
+// netlink messages and records the live Getreply path produces.
+type AuditLogReader struct {
+	files  []string
+	next   int
+	file   string
+	line   int
+	sc     *bufio.Scanner
+	closer []io.Closer /* of the current file, closed once it is read */
+}
+
+// Returns path and its rotated siblings (path.1, path.2.gz ...) oldest first.
+func AuditLogFiles(path string) ([]string, error) {
+	matches, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	type rotated struct {
+		name string
+		n    int
+	}
+	var logs []rotated
+	for _, m := range matches {
+		suffix := strings.TrimSuffix(strings.TrimPrefix(m, path+"."), ".gz")
+		n, err := strconv.Atoi(suffix)
+		if err != nil {
+			continue
+		}
+		logs = append(logs, rotated{m, n})
+	}
+	sort.Slice(logs, func(i, j int) bool { return logs[i].n > logs[j].n })
+
+	files := make([]string, 0, len(logs)+1)
+	for _, l := range logs {
+		files = append(files, l.name)
+	}
+	if _, err := os.Stat(path); err == nil {
+		files = append(files, path)
+	}
+	if len(files) == 0 {
+		return nil, &os.PathError{Op: "open", Path: path, Err: syscall.ENOENT}
+	}
+	return files, nil
+}
+
+// Reads the given files one after the other.
+func NewAuditLogReader(files ...string) *AuditLogReader {
+	return &AuditLogReader{files: files}
+}
+
+// Reads an already open stream, e.g. stdin.
+func NewAuditLogReaderFrom(name string, r io.Reader) (*AuditLogReader, error) {
+	lr := &AuditLogReader{}
+	if err := lr.setReader(name, r); err != nil {
+		return nil, err
+	}
+	return lr, nil
+}
+
+func (lr *AuditLogReader) setReader(name string, r io.Reader) error {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		lr.closer = append(lr.closer, gz)
+		r = gz
+	} else {
+		r = br
+	}
+	lr.sc = bufio.NewScanner(r)
+	lr.sc.Buffer(make([]byte, 0, MAX_AUDIT_MESSAGE_LENGTH), 4*MAX_AUDIT_MESSAGE_LENGTH)
+	lr.file = name
+	lr.line = 0
+	return nil
+}
+
+func (lr *AuditLogReader) openNext() error {
+	if lr.next >= len(lr.files) {
+		return io.EOF
+	}
+	name := lr.files[lr.next]
+	lr.next++
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	lr.closer = append(lr.closer, f)
+	return lr.setReader(name, f)
+}
+
+func (lr *AuditLogReader) readLine() (string, error) {
+	for {
+		if lr.sc == nil {
+			if err := lr.openNext(); err != nil {
+				return "", err
+			}
+		}
+		if lr.sc.Scan() {
+			lr.line++
+			line := lr.sc.Text()
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			return line, nil
+		}
+		if err := lr.sc.Err(); err != nil {
+			return "", err
+		}
+		lr.sc = nil
+		if err := lr.closeCurrent(); err != nil {
+			return "", err
+		}
+		if lr.next >= len(lr.files) {
+			return "", io.EOF
+		}
+	}
+}
+
+// Splits "node=x type=SYSCALL msg=audit(...): ..." into node, type and payload.
+func parseAuditLogLine(line string) (string, uint16, string, error) {
+	node := ""
+	if strings.HasPrefix(line, "node=") {
+		end := strings.IndexByte(line, ' ')
+		if end < 0 {
+			return "", 0, "", ErrAuditNoHeader
+		}
+		node, line = line[len("node="):end], line[end+1:]
+	}
+	if !strings.HasPrefix(line, "type=") {
+		return "", 0, "", fmt.Errorf("missing type=")
+	}
+	end := strings.IndexByte(line, ' ')
+	if end < 0 {
+		return "", 0, "", ErrAuditNoHeader
+	}
+	typ, ok := AuditMsgTypeByName(line[len("type="):end])
+	if !ok {
+		return "", 0, "", fmt.Errorf("unknown record type %q", line[len("type="):end])
+	}
+	msg := strings.TrimPrefix(line[end+1:], "msg=")
+	if !strings.HasPrefix(msg, "audit(") {
+		return "", 0, "", ErrAuditNoHeader
+	}
+	return node, typ, msg, nil
+}
+
+// Returns the next line as the netlink message the kernel sent. The node
+// name is returned separately since netlink messages carry none.
+func (lr *AuditLogReader) ReadMessage() (syscall.NetlinkMessage, string, error) {
+	line, err := lr.readLine()
+	if err != nil {
+		return syscall.NetlinkMessage{}, "", err
+	}
+	node, typ, msg, err := parseAuditLogLine(line)
+	if err != nil {
+		return syscall.NetlinkMessage{}, "", &AuditLogParseError{File: lr.file, Line: lr.line, Err: err}
+	}
+	m := syscall.NetlinkMessage{Data: []byte(msg)}
+	m.Header.Len = uint32(syscall.NLMSG_HDRLEN + len(msg))
+	m.Header.Type = typ
+	return m, node, nil
+}
+
+func (lr *AuditLogReader) ReadRecord() (*AuditRecord, error) {
+	m, node, err := lr.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	r, err := ParseAuditRecord(m)
+	if err != nil {
+		return nil, &AuditLogParseError{File: lr.file, Line: lr.line, Err: err}
+	}
+	r.Node = node
+	return r, nil
+}
+
+func (lr *AuditLogReader) closeCurrent() error {
+	var err error
+	for i := len(lr.closer) - 1; i >= 0; i-- {
+		if e := lr.closer[i].Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	lr.closer = nil
+	return err
+}
+
+func (lr *AuditLogReader) Close() error {
+	err := lr.closeCurrent()
+	lr.sc = nil
+	lr.next = len(lr.files)
+	return err
+}
+
+// Feeds the messages of the log to msgchan like Getreply does for a live
+// socket, so the rest of the pipeline can not tell the difference. Node
+// names are kept as a "node=" prefix of the data, the way audispd forwards
+// records, which ParseAuditRecord understands. msgchan is closed at the end
+// of the log or when done fires.
+func AuditLogReplay(lr *AuditLogReader, msgchan chan<- syscall.NetlinkMessage, errchan chan<- error, done <-chan bool) {
+	defer close(msgchan)
+	for {
+		m, node, err := lr.ReadMessage()
+		if err == nil && node != "" {
+			m.Data = append([]byte("node="+node+" "), m.Data...)
+			m.Header.Len = uint32(syscall.NLMSG_HDRLEN + len(m.Data))
+		}
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			select {
+			case errchan <- err:
+			case <-done:
+				return
+			}
+			if _, ok := err.(*AuditLogParseError); ok {
+				continue
+			}
+			return
+		}
+		select {
+		case msgchan <- m:
+		case <-done:
+			return
+		}
+	}
+}
+
+// Assembles the log into events. Unlike AuditLogReplay the assembler
+// timeout runs on record time rather than wall clock time. evchan is
+// closed at the end of the log or when done fires, reading stops then.
+func AuditLogReplayEvents(lr *AuditLogReader, a *AuditEventAssembler, evchan chan<- *AuditEvent, errchan chan<- error, done <-chan bool) {
+	defer close(evchan)
+	var clock time.Time
+	a.now = func() time.Time { return clock }
+	defer func() { a.now = time.Now }()
+
+	send := func(evs []*AuditEvent) bool {
+		for _, ev := range evs {
+			select {
+			case evchan <- ev:
+			case <-done:
+				return false
+			}
+		}
+		return true
+	}
+	lastExpire := time.Time{}
+	for {
+		r, err := lr.ReadRecord()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			select {
+			case errchan <- err:
+			case <-done:
+				return
+			}
+			if _, ok := err.(*AuditLogParseError); ok {
+				continue
+			}
+			break
+		}
+		if r.Timestamp.After(clock) {
+			clock = r.Timestamp
+		}
+		if !send(a.Push(r)) {
+			return
+		}
+		if clock.Sub(lastExpire) >= a.Timeout {
+			lastExpire = clock
+			if !send(a.Expire()) {
+				return
+			}
+		}
+	}
+	send(a.Flush())
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+const auditTestLog = `node=web1 type=SYSCALL msg=audit(1700000000.123:1): arch=c000003e syscall=59 success=yes
+garbage
+type=EOE msg=audit(1700000000.123:1):
+`
+
+func TestAuditLogReplay(t *testing.T) {
+	lr, err := NewAuditLogReaderFrom("audit.log", strings.NewReader(auditTestLog))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgchan := make(chan syscall.NetlinkMessage, 4)
+	errchan := make(chan error, 4)
+	AuditLogReplay(lr, msgchan, errchan, make(chan bool))
+
+	var msgs []syscall.NetlinkMessage
+	for m := range msgchan {
+		msgs = append(msgs, m)
+	}
+	if len(msgs) != 2 || msgs[0].Header.Type != AUDIT_SYSCALL || msgs[1].Header.Type != AUDIT_EOE {
+		t.Fatalf("got %d messages", len(msgs))
+	}
+	r, err := ParseAuditRecord(msgs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Node != "web1" || r.Serial != 1 || r.Value("syscall") != "59" {
+		t.Fatalf("got %+v", r)
+	}
+	if err, ok := (<-errchan).(*AuditLogParseError); !ok || err.Line != 2 {
+		t.Fatalf("got %v", err)
+	}
+}
+
+// A consumer that stops reading errors can still end the replay with done.
+func TestAuditLogReplayDone(t *testing.T) {
+	lr, err := NewAuditLogReaderFrom("audit.log", strings.NewReader("garbage\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan bool)
+	finished := make(chan bool)
+	go func() {
+		AuditLogReplay(lr, make(chan syscall.NetlinkMessage), make(chan error), done)
+		close(finished)
+	}()
+	close(done)
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("AuditLogReplay blocked on errchan")
+	}
+}
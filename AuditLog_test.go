@@ -0,0 +1,98 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A writer on a temporary directory whose clock and free space the test controls.
+func newAuditTestLogWriter(t *testing.T, cfg AuditLogConfig) (*AuditLogWriter, *time.Time, *int64) {
+	cfg.Path = filepath.Join(t.TempDir(), "audit.log")
+	w, err := NewAuditLogWriter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now, free := time.Unix(1700000000, 0), int64(1<<40)
+	w.now = func() time.Time { return now }
+	w.freeSpace = func(string) (int64, error) { return free, nil }
+	return w, &now, &free
+}
+
+func TestAuditLogWriterFlushNone(t *testing.T) {
+	cfg := DefaultAuditLogConfig()
+	cfg.Flush = AUDIT_FLUSH_NONE
+	w, _, _ := newAuditTestLogWriter(t, cfg)
+	line := "type=USER msg=audit(1.000:1): msg='x'"
+	if err := w.WriteLine(line); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(w.cfg.Path); len(b) != 0 {
+		t.Fatalf("got %q before Close", b)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(w.cfg.Path); string(b) != line+"\n" {
+		t.Fatalf("got %q after Close", b)
+	}
+}
+
+// Suspending for lack of space happens once per threshold crossing, a
+// Resume while space is still low is not undone by the next check.
+func TestAuditLogWriterSpaceLeft(t *testing.T) {
+	cfg := DefaultAuditLogConfig()
+	cfg.SpaceLeft = 100
+	cfg.SpaceLeftAction = AUDIT_LOG_ACTION_SUSPEND
+	cfg.AdminSpaceLeft = 0
+	w, now, free := newAuditTestLogWriter(t, cfg)
+	defer w.Close()
+	write := func() error {
+		*now = now.Add(AUDIT_LOG_SPACE_CHECK)
+		return w.WriteLine("type=USER msg=audit(1.000:1): msg='x'")
+	}
+
+	*free = 10
+	if err := write(); err != ErrAuditLogSuspended {
+		t.Fatalf("got %v, want ErrAuditLogSuspended", err)
+	}
+	w.Resume()
+	if err := write(); err != nil {
+		t.Fatalf("still low after Resume: %v", err)
+	}
+
+	*free = 1000
+	if err := write(); err != nil {
+		t.Fatal(err)
+	}
+	*free = 10
+	if err := write(); err != ErrAuditLogSuspended {
+		t.Fatalf("second crossing: got %v, want ErrAuditLogSuspended", err)
+	}
+	*free = 1000
+	if err := write(); err != nil {
+		t.Fatalf("space came back: %v", err)
+	}
+}
+
+func TestAuditLogWriterRotate(t *testing.T) {
+	cfg := DefaultAuditLogConfig()
+	cfg.MaxLogFile = 100
+	cfg.NumLogs = 2
+	cfg.Flush = AUDIT_FLUSH_DATA
+	w, _, _ := newAuditTestLogWriter(t, cfg)
+	defer w.Close()
+	for i := 0; i < 5; i++ {
+		if err := w.WriteLine("type=USER msg=audit(1.000:1): msg='" + strings.Repeat("x", 40) + "'"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := os.Stat(w.cfg.Path + ".1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(w.cfg.Path + ".2"); !os.IsNotExist(err) {
+		t.Fatalf("kept more than NumLogs files: %v", err)
+	}
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"container/heap"
+	"fmt"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	AUDIT_LOSS_KERNEL     = "kernel"     /* the kernel lost counter went up */
+	AUDIT_LOSS_SERIAL_GAP = "serial_gap" /* serial numbers were skipped */
+
+	AUDIT_LOSS_DEFAULT_WINDOW = 4096
+	AUDIT_LOSS_DEFAULT_DELAY  = 10 * time.Second
+)
+
+// What a synthetic "events lost" event reports.
+type AuditLossInfo struct {
+	Reason      string
+	Count       uint64
+	From        time.Time /* last event (or status poll) known before the loss */
+	To          time.Time /* first event (or status poll) after it */
+	FirstSerial uint64    /* missing serial range, serial gaps only */
+	LastSerial  uint64
+}
+
+type auditLossPending struct {
+	ts   time.Time /* event timestamp */
+	seen time.Time /* when it arrived */
+}
+
+// Serial state of one node.
+type auditSerialTracker struct {
+	next     uint64
+	lastTime time.Time
+	ahead    map[uint64]auditLossPending
+	lowest   auditSerialHeap /* serials of ahead, smallest on top */
+	arrived  []uint64        /* serials of ahead by arrival, may hold ones already gone */
+}
+
+type auditSerialHeap []uint64
+
+func (h auditSerialHeap) Len() int            { return len(h) }
+func (h auditSerialHeap) Less(i, j int) bool  { return h[i] < h[j] }
+func (h auditSerialHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *auditSerialHeap) Push(x interface{}) { *h = append(*h, x.(uint64)) }
+func (h *auditSerialHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// Notices when the trail is incomplete: the kernel lost counter going up
+// and holes in the serial numbers. Events arrive slightly out of order
+// from the assembler, so a missing serial is only reported once Window
+// later serials have been seen or it has been missing for Delay. Serials
+// are tracked per node. Not every hole is a loss, records suppressed by
+// exclude rules still use up a serial. Safe for concurrent use.
+type AuditLossDetector struct {
+	Window int
+	Delay  time.Duration
+
+	mu         sync.Mutex
+	nodes      map[string]*auditSerialTracker
+	haveStatus bool
+	lastLost   uint32
+	lastPoll   time.Time
+	kernelLost uint64
+	gapLost    uint64
+	pending    []*AuditEvent /* from Resync, passed on with the next Add or Expire */
+	now        func() time.Time
+}
+
+func NewAuditLossDetector(window int, delay time.Duration) *AuditLossDetector {
+	if window <= 0 {
+		window = AUDIT_LOSS_DEFAULT_WINDOW
+	}
+	if delay <= 0 {
+		delay = AUDIT_LOSS_DEFAULT_DELAY
+	}
+	return &AuditLossDetector{
+		Window: window,
+		Delay:  delay,
+		nodes:  make(map[string]*auditSerialTracker),
+		now:    time.Now,
+	}
+}
+
+// Loss events carry one DAEMON_ERR record so sinks that only write
+// records still show the gap:
+// op=lost reason=serial_gap lost=3 first_serial=10 last_serial=12 res=failed
+func newAuditLossEvent(node string, info *AuditLossInfo) *AuditEvent {
+	data := fmt.Sprintf("op=lost reason=%s lost=%d", info.Reason, info.Count)
+	if info.Reason == AUDIT_LOSS_SERIAL_GAP {
+		data += fmt.Sprintf(" first_serial=%d last_serial=%d", info.FirstSerial, info.LastSerial)
+	}
+	data += " res=failed"
+	ev := &AuditEvent{Timestamp: info.To, Node: node, Complete: true, Lost: info}
+	var m syscall.NetlinkMessage
+	m.Header.Type = AUDIT_DAEMON_ERR
+	m.Data = []byte(formatAuditStamp(info.To, 0) + ": " + data)
+	if r, err := ParseAuditRecord(m); err == nil {
+		r.Node = node
+		ev.Records = []*AuditRecord{r}
+	}
+	return ev
+}
+
+// Feeds one event and returns the loss events it revealed, to be passed
+// on before ev.
+func (d *AuditLossDetector) Add(ev *AuditEvent) []*AuditEvent {
+	if ev.Lost != nil || ev.Serial == 0 {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	out := d.takePending()
+	t, ok := d.nodes[ev.Node]
+	//A serial far behind means the node rebooted and started over
+	if !ok || ev.Serial+uint64(d.Window) < t.next {
+		d.nodes[ev.Node] = &auditSerialTracker{
+			next:     ev.Serial + 1,
+			lastTime: ev.Timestamp,
+			ahead:    make(map[uint64]auditLossPending),
+		}
+		return out
+	}
+	switch {
+	case ev.Serial < t.next:
+		//Late or duplicate, possibly already reported as lost
+		return out
+	case ev.Serial == t.next:
+		t.next++
+		t.lastTime = ev.Timestamp
+		t.advance()
+	default:
+		if _, dup := t.ahead[ev.Serial]; !dup {
+			t.ahead[ev.Serial] = auditLossPending{ev.Timestamp, d.now()}
+			heap.Push(&t.lowest, ev.Serial)
+			t.arrived = append(t.arrived, ev.Serial)
+		}
+	}
+	return append(out, d.gaps(ev.Node, t, false)...)
+}
+
+// Must be called with mu held.
+func (d *AuditLossDetector) takePending() []*AuditEvent {
+	out := d.pending
+	d.pending = nil
+	return out
+}
+
+// Moves next past the serials that arrived early. Those are always the
+// smallest of ahead, so they come off the top of the heap.
+func (t *auditSerialTracker) advance() {
+	for {
+		p, ok := t.ahead[t.next]
+		if !ok {
+			return
+		}
+		delete(t.ahead, t.next)
+		heap.Pop(&t.lowest)
+		t.lastTime = p.ts
+		t.next++
+	}
+}
+
+// When the longest waiting serial of ahead arrived.
+func (t *auditSerialTracker) oldest() (time.Time, bool) {
+	for len(t.arrived) > 0 {
+		if p, ok := t.ahead[t.arrived[0]]; ok {
+			return p.seen, true
+		}
+		t.arrived = t.arrived[1:]
+	}
+	return time.Time{}, false
+}
+
+// Must be called with mu held. Reports the holes below the serials that
+// are too far ahead or waited too long, all of them if force is set.
+func (d *AuditLossDetector) gaps(node string, t *auditSerialTracker, force bool) []*AuditEvent {
+	var out []*AuditEvent
+	deadline := d.now().Add(-d.Delay)
+	for len(t.ahead) > 0 {
+		min := t.lowest[0]
+		stale := force || len(t.ahead) > d.Window
+		if seen, ok := t.oldest(); ok && seen.Before(deadline) {
+			stale = true
+		}
+		if !stale {
+			break
+		}
+		info := &AuditLossInfo{
+			Reason:      AUDIT_LOSS_SERIAL_GAP,
+			Count:       min - t.next,
+			From:        t.lastTime,
+			To:          t.ahead[min].ts,
+			FirstSerial: t.next,
+			LastSerial:  min - 1,
+		}
+		d.gapLost += info.Count
+		out = append(out, newAuditLossEvent(node, info))
+		t.next = min
+		t.advance()
+	}
+	return out
+}
+
+// Reports the holes that have been open for Delay. Call it periodically.
+func (d *AuditLossDetector) Expire() []*AuditEvent {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	out := d.takePending()
+	for node, t := range d.nodes {
+		out = append(out, d.gaps(node, t, false)...)
+	}
+	sortAuditEvents(out)
+	return out
+}
+
+// Reports every hole still open, used when the event source is exhausted.
+func (d *AuditLossDetector) Flush() []*AuditEvent {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	out := d.takePending()
+	for node, t := range d.nodes {
+		out = append(out, d.gaps(node, t, true)...)
+	}
+	sortAuditEvents(out)
+	return out
+}
+
+// Compares the kernel lost counter with the previous status, returns a
+// loss event if it went up. The first call only records the counter.
+func (d *AuditLossDetector) CheckStatus(st *AuditStatus) *AuditEvent {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := d.now()
+	prev, prevPoll := d.lastLost, d.lastPoll
+	d.lastLost, d.lastPoll = st.Lost, now
+	if !d.haveStatus {
+		d.haveStatus = true
+		return nil
+	}
+	//Smaller after a reset of the counter through AUDIT_SET
+	if st.Lost <= prev {
+		return nil
+	}
+	info := &AuditLossInfo{
+		Reason: AUDIT_LOSS_KERNEL,
+		Count:  uint64(st.Lost - prev),
+		From:   prevPoll,
+		To:     now,
+	}
+	d.kernelLost += info.Count
+	return newAuditLossEvent("", info)
+}
+
+// Reads the status from the control socket ctl right away instead of
+// waiting for the next poll, e.g. from NetlinkSocket.OnOverrun. A loss
+// event is passed on with the next Add or Expire.
+func (d *AuditLossDetector) Resync(ctl NetlinkTransport) error {
+	st, err := AuditGetStatus(ctl)
+	if err != nil {
+		return err
+	}
+	if l := d.CheckStatus(st); l != nil {
+		d.mu.Lock()
+		d.pending = append(d.pending, l)
+		d.mu.Unlock()
+	}
+	return nil
+}
+
+// Totals reported so far.
+func (d *AuditLossDetector) Lost() (kernel, gaps uint64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.kernelLost, d.gapLost
+}
+
+// How often AuditLossStream calls Expire and polls the status.
+func (d *AuditLossDetector) expireInterval() time.Duration {
+	if i := d.Delay / 2; i > 0 {
+		return i
+	}
+	return 1
+}
+
+// Passes the events of in on to out with loss events inserted. If s is not
+// nil it is polled with AUDIT_GET for the kernel lost counter every Delay/2.
+// out is closed once in is closed.
+func AuditLossStream(d *AuditLossDetector, s NetlinkTransport, in <-chan *AuditEvent, out chan<- *AuditEvent) {
+	defer close(out)
+	ticker := time.NewTicker(d.expireInterval())
+	defer ticker.Stop()
+	if s != nil {
+		if st, err := AuditGetStatus(s); err == nil {
+			//Only reports a loss if d has seen a status before
+			if l := d.CheckStatus(st); l != nil {
+				out <- l
+			}
+		}
+	}
+	for {
+		select {
+		case ev, ok := <-in:
+			if !ok {
+				for _, l := range d.Flush() {
+					out <- l
+				}
+				return
+			}
+			for _, l := range d.Add(ev) {
+				out <- l
+			}
+			out <- ev
+		case <-ticker.C:
+			for _, l := range d.Expire() {
+				out <- l
+			}
+			if s == nil {
+				continue
+			}
+			if st, err := AuditGetStatus(s); err == nil {
+				if l := d.CheckStatus(st); l != nil {
+					out <- l
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func auditLossTestEvent(node string, serial uint64) *AuditEvent {
+	return &AuditEvent{Serial: serial, Node: node, Timestamp: time.Unix(1700000000+int64(serial), 0)}
+}
+
+func checkAuditTestLoss(t *testing.T, got []*AuditEvent, first, last uint64) {
+	t.Helper()
+	if len(got) != 1 || got[0].Lost == nil {
+		t.Fatalf("got %d loss events", len(got))
+	}
+	l := got[0].Lost
+	if l.Reason != AUDIT_LOSS_SERIAL_GAP || l.FirstSerial != first || l.LastSerial != last || l.Count != last-first+1 {
+		t.Fatalf("got %+v", l)
+	}
+	if len(got[0].Records) != 1 || got[0].Records[0].Type != AUDIT_DAEMON_ERR {
+		t.Fatalf("got records %+v", got[0].Records)
+	}
+}
+
+func TestAuditLossSerialGap(t *testing.T) {
+	d := NewAuditLossDetector(2, time.Hour)
+	for _, serial := range []uint64{1, 3, 2, 4} {
+		if l := d.Add(auditLossTestEvent("", serial)); len(l) != 0 {
+			t.Fatalf("serial %d: reordering reported as loss", serial)
+		}
+	}
+	//5 and 6 are missing, reported once more than Window serials are ahead
+	d.Add(auditLossTestEvent("", 7))
+	d.Add(auditLossTestEvent("", 8))
+	checkAuditTestLoss(t, d.Add(auditLossTestEvent("", 9)), 5, 6)
+
+	//Serials are per node, and a serial far behind is a reboot
+	if l := d.Add(auditLossTestEvent("web1", 100)); len(l) != 0 {
+		t.Fatal("new node reported as loss")
+	}
+	if l := d.Add(auditLossTestEvent("", 1)); len(l) != 0 {
+		t.Fatal("reboot reported as loss")
+	}
+	d.Add(auditLossTestEvent("", 3))
+	checkAuditTestLoss(t, d.Flush(), 2, 2)
+	if kernel, gaps := d.Lost(); kernel != 0 || gaps != 3 {
+		t.Fatalf("lost %d, %d", kernel, gaps)
+	}
+}
+
+func TestAuditLossDelay(t *testing.T) {
+	d := NewAuditLossDetector(0, time.Minute)
+	now := time.Unix(1700000000, 0)
+	d.now = func() time.Time { return now }
+	d.Add(auditLossTestEvent("", 1))
+	d.Add(auditLossTestEvent("", 3))
+	if l := d.Expire(); len(l) != 0 {
+		t.Fatal("gap reported before Delay")
+	}
+	now = now.Add(2 * time.Minute)
+	checkAuditTestLoss(t, d.Expire(), 2, 2)
+	//Too late now
+	if l := d.Add(auditLossTestEvent("", 2)); len(l) != 0 {
+		t.Fatal("late event reported as loss")
+	}
+}
+
+func TestAuditLossCheckStatus(t *testing.T) {
+	d := NewAuditLossDetector(0, 0)
+	if l := d.CheckStatus(&AuditStatus{Lost: 5}); l != nil {
+		t.Fatal("first status reported as loss")
+	}
+	l := d.CheckStatus(&AuditStatus{Lost: 8})
+	if l == nil || l.Lost.Reason != AUDIT_LOSS_KERNEL || l.Lost.Count != 3 {
+		t.Fatalf("got %+v", l)
+	}
+	//Reset through AUDIT_SET
+	if l := d.CheckStatus(&AuditStatus{Lost: 0}); l != nil {
+		t.Fatal("counter reset reported as loss")
+	}
+	if kernel, _ := d.Lost(); kernel != 3 {
+		t.Fatalf("lost %d", kernel)
+	}
+}
+
+// Delay is exported and may be set below the ticker's minimum.
+func TestAuditLossStreamSmallDelay(t *testing.T) {
+	d := NewAuditLossDetector(0, 0)
+	d.Delay = 0
+	in := make(chan *AuditEvent)
+	out := make(chan *AuditEvent, 4)
+	go AuditLossStream(d, nil, in, out)
+	in <- auditLossTestEvent("", 1)
+	in <- auditLossTestEvent("", 3)
+	close(in)
+
+	var n, lost int
+	for ev := range out {
+		n++
+		if ev.Lost != nil {
+			lost++
+		}
+	}
+	if n != 3 || lost != 1 {
+		t.Fatalf("got %d events, %d loss events", n, lost)
+	}
+}
+
+func TestAuditLossKernel(t *testing.T) {
+	k := NewAuditFakeKernel()
+	s := k.Dial()
+	defer s.Close()
+	d := NewAuditLossDetector(0, 20*time.Millisecond)
+	st := k.Status()
+	d.CheckStatus(&st)
+
+	in := make(chan *AuditEvent)
+	out := make(chan *AuditEvent)
+	go AuditLossStream(d, s, in, out)
+	defer close(in)
+	k.Drop(3)
+	select {
+	case l := <-out:
+		if l.Lost == nil || l.Lost.Reason != AUDIT_LOSS_KERNEL || l.Lost.Count != 3 {
+			t.Fatalf("got %+v", l)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("kernel loss not reported")
+	}
+}
+
+func TestAuditLossResync(t *testing.T) {
+	k := NewAuditFakeKernel()
+	s := k.Dial()
+	defer s.Close()
+	d := NewAuditLossDetector(0, time.Hour)
+	if err := d.Resync(s); err != nil {
+		t.Fatal(err)
+	}
+	k.Drop(2)
+	if err := d.Resync(s); err != nil {
+		t.Fatal(err)
+	}
+	if l := d.Expire(); len(l) != 1 || l[0].Lost.Reason != AUDIT_LOSS_KERNEL || l[0].Lost.Count != 2 {
+		t.Fatalf("got %d loss events", len(l))
+	}
+}
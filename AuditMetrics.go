@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const AUDIT_METRICS_DEFAULT_INTERVAL = 15 * time.Second
+
+// Upper bounds in seconds of the output latency histogram.
+var auditMetricsLatencyBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30}
+
+// Health counters of the audit pipeline, exposed in the Prometheus text
+// format. The kernel status is polled with AUDIT_GET, everything else is
+// counted by the Count* and Observe* methods or the stream helpers. Safe
+// for concurrent use.
+type AuditMetrics struct {
+	Socket    NetlinkTransport     /* control socket polled with AUDIT_GET, nil to skip */
+	Assembler *AuditEventAssembler /* optional, for its timeout and eviction counts */
+	Interval  time.Duration
+
+	mu            sync.Mutex
+	status        *AuditStatus
+	pollErrors    uint64
+	records       map[string]uint64
+	events        uint64
+	incomplete    uint64
+	parseErrors   uint64
+	overruns      uint64
+	receiveErrors uint64
+	latency       []uint64 /* per bucket, not cumulative */
+	latencySum    float64
+	latencyCount  uint64
+	now           func() time.Time
+}
+
+func NewAuditMetrics(s NetlinkTransport, interval time.Duration) *AuditMetrics {
+	if interval <= 0 {
+		interval = AUDIT_METRICS_DEFAULT_INTERVAL
+	}
+	return &AuditMetrics{
+		Socket:   s,
+		Interval: interval,
+		records:  make(map[string]uint64),
+		latency:  make([]uint64, len(auditMetricsLatencyBuckets)),
+		now:      time.Now,
+	}
+}
+
+// Fetches the kernel status once.
+func (m *AuditMetrics) Poll() error {
+	if m.Socket == nil {
+		return nil
+	}
+	st, err := AuditGetStatus(m.Socket)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err != nil {
+		m.pollErrors++
+		return err
+	}
+	m.status = st
+	return nil
+}
+
+// Polls every Interval until done receives a value or is closed.
+func (m *AuditMetrics) Run(done <-chan bool) {
+	ticker := time.NewTicker(m.Interval)
+	defer ticker.Stop()
+	m.Poll()
+	for {
+		select {
+		case <-ticker.C:
+			m.Poll()
+		case <-done:
+			return
+		}
+	}
+}
+
+// Counts a received netlink message by its record type.
+func (m *AuditMetrics) CountMessage(msg syscall.NetlinkMessage) {
+	m.mu.Lock()
+	m.records[AuditMsgTypeName(msg.Header.Type)]++
+	m.mu.Unlock()
+}
+
+// Counts the event records of a batch from AuditReceiver by record type.
+func (m *AuditMetrics) CountRecords(recs []*AuditRecord) {
+	m.mu.Lock()
+	for _, r := range recs {
+		m.records[AuditMsgTypeName(r.Type)]++
+	}
+	m.mu.Unlock()
+}
+
+// Counts an assembled event. Incomplete events are those the assembler
+// had to give up on, by timeout, to make room or on Flush.
+func (m *AuditMetrics) CountEvent(ev *AuditEvent) {
+	m.mu.Lock()
+	m.events++
+	if !ev.Complete {
+		m.incomplete++
+	}
+	m.mu.Unlock()
+}
+
+// Sorts an error from Getreply or AuditEventStream into ENOBUFS overruns,
+// parse errors and other receive errors.
+func (m *AuditMetrics) CountError(err error) {
+	var perr *AuditLogParseError
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch {
+	case errors.Is(err, syscall.ENOBUFS):
+		m.overruns++
+	case err == ErrAuditNoHeader || err == ErrAuditBadHeader || errors.As(err, &perr):
+		m.parseErrors++
+	default:
+		m.receiveErrors++
+	}
+}
+
+// Records the time from the kernel timestamp of ev until now, call it
+// once ev has been written out.
+func (m *AuditMetrics) ObserveOutput(ev *AuditEvent) {
+	m.ObserveLatency(m.now().Sub(ev.Timestamp))
+}
+
+func (m *AuditMetrics) ObserveLatency(d time.Duration) {
+	sec := d.Seconds()
+	if sec < 0 {
+		sec = 0
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.latencySum += sec
+	m.latencyCount++
+	for i, le := range auditMetricsLatencyBuckets {
+		if sec <= le {
+			m.latency[i]++
+			return
+		}
+	}
+}
+
+// Writes all metrics in the Prometheus text exposition format.
+func (m *AuditMetrics) WriteText(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	bw := bufio.NewWriter(w)
+	metric := func(name, typ, help string) {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+
+	if m.status != nil {
+		for _, g := range []struct {
+			name, help string
+			v          uint32
+		}{
+			{"audit_enabled", "Audit enabled flag (0 off, 1 on, 2 locked).", m.status.Enabled},
+			{"audit_failure_mode", "Failure action (0 silent, 1 printk, 2 panic).", m.status.Failure},
+			{"audit_daemon_pid", "Pid registered to receive audit events.", m.status.Pid},
+			{"audit_rate_limit", "Messages per second limit, 0 for none.", m.status.Rate_limit},
+			{"audit_backlog_limit", "Kernel backlog queue limit.", m.status.Backlog_limit},
+			{"audit_backlog", "Messages waiting in the kernel backlog.", m.status.Backlog},
+		} {
+			metric(g.name, "gauge", g.help)
+			fmt.Fprintf(bw, "%s %d\n", g.name, g.v)
+		}
+		metric("audit_lost_total", "counter", "Messages the kernel dropped.")
+		fmt.Fprintf(bw, "audit_lost_total %d\n", m.status.Lost)
+	}
+	metric("audit_status_poll_errors_total", "counter", "Failed AUDIT_GET requests.")
+	fmt.Fprintf(bw, "audit_status_poll_errors_total %d\n", m.pollErrors)
+
+	metric("audit_records_received_total", "counter", "Received netlink messages by record type.")
+	types := make([]string, 0, len(m.records))
+	for t := range m.records {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	for _, t := range types {
+		fmt.Fprintf(bw, "audit_records_received_total{type=%q} %d\n", t, m.records[t])
+	}
+
+	for _, c := range []struct {
+		name, help string
+		v          uint64
+	}{
+		{"audit_events_total", "Assembled events.", m.events},
+		{"audit_events_incomplete_total", "Events emitted incomplete.", m.incomplete},
+		{"audit_parse_errors_total", "Records that could not be parsed.", m.parseErrors},
+		{"audit_enobufs_total", "Netlink receive buffer overruns (ENOBUFS).", m.overruns},
+		{"audit_receive_errors_total", "Other netlink receive errors.", m.receiveErrors},
+	} {
+		metric(c.name, "counter", c.help)
+		fmt.Fprintf(bw, "%s %d\n", c.name, c.v)
+	}
+	if m.Assembler != nil {
+		_, _, evicted, timedOut := m.Assembler.Counters()
+		metric("audit_assembly_timeouts_total", "counter", "Events emitted after no record came for the assembler timeout.")
+		fmt.Fprintf(bw, "audit_assembly_timeouts_total %d\n", timedOut)
+		metric("audit_assembly_evictions_total", "counter", "Events emitted early to stay within the pending limit.")
+		fmt.Fprintf(bw, "audit_assembly_evictions_total %d\n", evicted)
+	}
+
+	metric("audit_output_latency_seconds", "histogram", "Time from the kernel event timestamp to output.")
+	var cum uint64
+	for i, le := range auditMetricsLatencyBuckets {
+		cum += m.latency[i]
+		fmt.Fprintf(bw, "audit_output_latency_seconds_bucket{le=\"%g\"} %d\n", le, cum)
+	}
+	fmt.Fprintf(bw, "audit_output_latency_seconds_bucket{le=\"+Inf\"} %d\n", m.latencyCount)
+	fmt.Fprintf(bw, "audit_output_latency_seconds_sum %g\n", m.latencySum)
+	fmt.Fprintf(bw, "audit_output_latency_seconds_count %d\n", m.latencyCount)
+	return bw.Flush()
+}
+
+// Serves the metrics, mount it on /metrics.
+func (m *AuditMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.WriteText(w)
+}
+
+// Counts every message of in and passes it on to out, which is closed
+// once in is closed.
+func AuditMetricsMessageStream(m *AuditMetrics, in <-chan syscall.NetlinkMessage, out chan<- syscall.NetlinkMessage) {
+	defer close(out)
+	for msg := range in {
+		m.CountMessage(msg)
+		out <- msg
+	}
+}
+
+// Counts the records of every batch of in, e.g. the output of
+// AuditReceiver.Run, and passes it on to out, which is closed once in is
+// closed.
+func AuditMetricsRecordStream(m *AuditMetrics, in <-chan []*AuditRecord, out chan<- []*AuditRecord) {
+	defer close(out)
+	for recs := range in {
+		m.CountRecords(recs)
+		out <- recs
+	}
+}
+
+// Counts every event of in and passes it on to out, which is closed once
+// in is closed.
+func AuditMetricsStream(m *AuditMetrics, in <-chan *AuditEvent, out chan<- *AuditEvent) {
+	defer close(out)
+	for ev := range in {
+		m.CountEvent(ev)
+		out <- ev
+	}
+}
+
+// Counts the errors of in and passes them on to out (if not nil).
+func AuditMetricsErrorStream(m *AuditMetrics, in <-chan error, out chan<- error) {
+	if out != nil {
+		defer close(out)
+	}
+	for err := range in {
+		m.CountError(err)
+		if out != nil {
+			out <- err
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func auditTestMetricsText(t *testing.T, m *AuditMetrics) string {
+	t.Helper()
+	var b bytes.Buffer
+	if err := m.WriteText(&b); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+func checkAuditTestMetrics(t *testing.T, text string, lines ...string) {
+	t.Helper()
+	for _, line := range lines {
+		if !strings.Contains(text, "\n"+line+"\n") {
+			t.Errorf("missing %q in\n%s", line, text)
+		}
+	}
+}
+
+func TestAuditMetricsCounters(t *testing.T) {
+	m := NewAuditMetrics(nil, 0)
+	var msg syscall.NetlinkMessage
+	msg.Header.Type = AUDIT_SYSCALL
+	m.CountMessage(msg)
+	m.CountRecords([]*AuditRecord{{Type: AUDIT_SYSCALL}, {Type: AUDIT_PATH}})
+	m.CountEvent(&AuditEvent{Complete: true})
+	m.CountEvent(&AuditEvent{})
+
+	for _, err := range []error{
+		syscall.ENOBUFS,
+		fmt.Errorf("receive: %w", syscall.ENOBUFS),
+		ErrAuditBadHeader,
+		ErrAuditNoHeader,
+		&AuditLogParseError{File: "audit.log", Line: 1, Err: ErrAuditNoHeader},
+		syscall.EBADF,
+	} {
+		m.CountError(err)
+	}
+
+	text := auditTestMetricsText(t, m)
+	if strings.Contains(text, "audit_enabled") || strings.Contains(text, "audit_assembly_") {
+		t.Errorf("kernel or assembler metrics without a source:\n%s", text)
+	}
+	checkAuditTestMetrics(t, text,
+		"# TYPE audit_records_received_total counter",
+		`audit_records_received_total{type="PATH"} 1`,
+		`audit_records_received_total{type="SYSCALL"} 2`,
+		"audit_events_total 2",
+		"audit_events_incomplete_total 1",
+		"audit_enobufs_total 2",
+		"audit_parse_errors_total 3",
+		"audit_receive_errors_total 1",
+		"audit_status_poll_errors_total 0",
+	)
+}
+
+func TestAuditMetricsAssembler(t *testing.T) {
+	a, now := newAuditTestAssembler(time.Second, 1)
+	pushAuditTestRecord(t, a, AUDIT_SYSCALL, 1, "syscall=2")
+	pushAuditTestRecord(t, a, AUDIT_SYSCALL, 2, "syscall=2")
+	*now = now.Add(time.Minute)
+	a.Expire()
+
+	m := NewAuditMetrics(nil, 0)
+	m.Assembler = a
+	checkAuditTestMetrics(t, auditTestMetricsText(t, m),
+		"audit_assembly_timeouts_total 1",
+		"audit_assembly_evictions_total 1",
+	)
+}
+
+func TestAuditMetricsLatency(t *testing.T) {
+	m := NewAuditMetrics(nil, 0)
+	for _, d := range []time.Duration{-time.Second, 2 * time.Millisecond, 500 * time.Millisecond, time.Minute} {
+		m.ObserveLatency(d)
+	}
+	m.now = func() time.Time { return time.Unix(1700000001, 0) }
+	m.ObserveOutput(&AuditEvent{Timestamp: time.Unix(1700000000, 0)})
+
+	checkAuditTestMetrics(t, auditTestMetricsText(t, m),
+		"# TYPE audit_output_latency_seconds histogram",
+		`audit_output_latency_seconds_bucket{le="0.001"} 1`,
+		`audit_output_latency_seconds_bucket{le="0.005"} 2`,
+		`audit_output_latency_seconds_bucket{le="0.1"} 2`,
+		`audit_output_latency_seconds_bucket{le="0.5"} 3`,
+		`audit_output_latency_seconds_bucket{le="1"} 4`,
+		`audit_output_latency_seconds_bucket{le="30"} 4`,
+		`audit_output_latency_seconds_bucket{le="+Inf"} 5`,
+		"audit_output_latency_seconds_sum 61.502",
+		"audit_output_latency_seconds_count 5",
+	)
+}
@@ -0,0 +1,266 @@
+//go:build ignore
+
+// Generates AuditMsgTypes.go from the kernel's linux/audit.h plus the
+// userspace message types that only live in libaudit.h.
+//
+//	go run AuditMsgTypesGen.go [-h /usr/include/linux/audit.h] [-o AuditMsgTypes.go]
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Userspace message types from audit-userspace lib/libaudit.h that the
+// kernel header does not carry.
+const libauditTypes = `
+#define AUDIT_CONTAINER_OP	1020	/* Define the container id and info */
+#define AUDIT_CONTAINER_ID	1021	/* Container ID */
+#define AUDIT_USER_AUTH		1100	/* User system access authentication */
+#define AUDIT_USER_ACCT		1101	/* User system access authorization */
+#define AUDIT_USER_MGMT		1102	/* User acct attribute change */
+#define AUDIT_CRED_ACQ		1103	/* User credential acquired */
+#define AUDIT_CRED_DISP		1104	/* User credential disposed */
+#define AUDIT_USER_START	1105	/* User session start */
+#define AUDIT_USER_END		1106	/* User session end */
+#define AUDIT_USER_CHAUTHTOK	1108	/* User acct password or pin changed */
+#define AUDIT_USER_ERR		1109	/* User acct state error */
+#define AUDIT_CRED_REFR		1110	/* User credential refreshed */
+#define AUDIT_USYS_CONFIG	1111	/* User space system config change */
+#define AUDIT_USER_LOGIN	1112	/* User has logged in */
+#define AUDIT_USER_LOGOUT	1113	/* User has logged out */
+#define AUDIT_ADD_USER		1114	/* User account added */
+#define AUDIT_DEL_USER		1115	/* User account deleted */
+#define AUDIT_ADD_GROUP		1116	/* Group account added */
+#define AUDIT_DEL_GROUP		1117	/* Group account deleted */
+#define AUDIT_DAC_CHECK		1118	/* User space DAC check results */
+#define AUDIT_CHGRP_ID		1119	/* User space group ID changed */
+#define AUDIT_TEST		1120	/* Used for test success messages */
+#define AUDIT_TRUSTED_APP	1121	/* Trusted app msg - freestyle text */
+#define AUDIT_USER_SELINUX_ERR	1122	/* SE Linux user space error */
+#define AUDIT_USER_CMD		1123	/* User shell command and args */
+#define AUDIT_CHUSER_ID		1125	/* Changed user ID supplemental data */
+#define AUDIT_GRP_AUTH		1126	/* Authentication for group password */
+#define AUDIT_SYSTEM_BOOT	1127	/* System boot */
+#define AUDIT_SYSTEM_SHUTDOWN	1128	/* System shutdown */
+#define AUDIT_SYSTEM_RUNLEVEL	1129	/* System runlevel change */
+#define AUDIT_SERVICE_START	1130	/* Service (daemon) start */
+#define AUDIT_SERVICE_STOP	1131	/* Service (daemon) stop */
+#define AUDIT_GRP_MGMT		1132	/* Group account attr was modified */
+#define AUDIT_GRP_CHAUTHTOK	1133	/* Group acct password or pin changed */
+#define AUDIT_MAC_CHECK		1134	/* User space MAC decision results */
+#define AUDIT_ACCT_LOCK		1135	/* User's account locked by admin */
+#define AUDIT_ACCT_UNLOCK	1136	/* User's account unlocked by admin */
+#define AUDIT_USER_DEVICE	1137	/* User space hotplug device changes */
+#define AUDIT_SOFTWARE_UPDATE	1138	/* Software update event */
+#define AUDIT_FIRST_DAEMON	1200
+#define AUDIT_LAST_DAEMON	1299
+#define AUDIT_DAEMON_RECONFIG	1204	/* Auditd should reconfigure */
+#define AUDIT_DAEMON_ROTATE	1205	/* Auditd should rotate logs */
+#define AUDIT_DAEMON_RESUME	1206	/* Auditd should resume logging */
+#define AUDIT_DAEMON_ACCEPT	1207	/* Auditd accepted remote connection */
+#define AUDIT_DAEMON_CLOSE	1208	/* Auditd closed remote connection */
+#define AUDIT_DAEMON_ERR	1209	/* Auditd internal error */
+#define AUDIT_FIRST_EVENT	1300
+#define AUDIT_LAST_EVENT	1399
+#define AUDIT_FIRST_SELINUX	1400
+#define AUDIT_LAST_SELINUX	1499
+#define AUDIT_FIRST_APPARMOR	1500
+#define AUDIT_LAST_APPARMOR	1599
+#define AUDIT_AA		1500	/* Not upstream yet */
+#define AUDIT_APPARMOR_AUDIT	1501
+#define AUDIT_APPARMOR_ALLOWED	1502
+#define AUDIT_APPARMOR_DENIED	1503
+#define AUDIT_APPARMOR_HINT	1504
+#define AUDIT_APPARMOR_STATUS	1505
+#define AUDIT_APPARMOR_ERROR	1506
+#define AUDIT_APPARMOR_KILL	1507
+#define AUDIT_FIRST_KERN_CRYPTO_MSG	1600
+#define AUDIT_LAST_KERN_CRYPTO_MSG	1699
+#define AUDIT_FIRST_ANOM_MSG	2100
+#define AUDIT_LAST_ANOM_MSG	2199
+#define AUDIT_ANOM_LOGIN_FAILURES	2100	/* Failed login limit reached */
+#define AUDIT_ANOM_LOGIN_TIME	2101	/* Login attempted at bad time */
+#define AUDIT_ANOM_LOGIN_SESSIONS	2102	/* Max concurrent sessions reached */
+#define AUDIT_ANOM_LOGIN_ACCT	2103	/* Login attempted to watched acct */
+#define AUDIT_ANOM_LOGIN_LOCATION	2104	/* Login from forbidden location */
+#define AUDIT_ANOM_MAX_DAC	2105	/* Max DAC failures reached */
+#define AUDIT_ANOM_MAX_MAC	2106	/* Max MAC failures reached */
+#define AUDIT_ANOM_AMTU_FAIL	2107	/* AMTU failure */
+#define AUDIT_ANOM_RBAC_FAIL	2108	/* RBAC self test failure */
+#define AUDIT_ANOM_RBAC_INTEGRITY_FAIL	2109	/* RBAC file integrity failure */
+#define AUDIT_ANOM_CRYPTO_FAIL	2110	/* Crypto system test failure */
+#define AUDIT_ANOM_ACCESS_FS	2111	/* Access of file or dir */
+#define AUDIT_ANOM_EXEC		2112	/* Execution of file */
+#define AUDIT_ANOM_MK_EXEC	2113	/* Make an executable */
+#define AUDIT_ANOM_ADD_ACCT	2114	/* Adding an acct */
+#define AUDIT_ANOM_DEL_ACCT	2115	/* Deleting an acct */
+#define AUDIT_ANOM_MOD_ACCT	2116	/* Changing an acct */
+#define AUDIT_ANOM_ROOT_TRANS	2117	/* User became root */
+#define AUDIT_ANOM_LOGIN_SERVICE	2118	/* Service acct attempted login */
+#define AUDIT_ANOM_LOGIN_ROOT	2119	/* Root login attempted */
+#define AUDIT_ANOM_ORIGIN_FAILURES	2120	/* Origin has too many failed login */
+#define AUDIT_ANOM_SESSION	2121	/* The user session is bound to an unexpected origin */
+#define AUDIT_FIRST_ANOM_RESP	2200
+#define AUDIT_LAST_ANOM_RESP	2299
+#define AUDIT_RESP_ANOMALY	2200	/* Anomaly not reacted to */
+#define AUDIT_RESP_ALERT	2201	/* Alert email was sent */
+#define AUDIT_RESP_KILL_PROC	2202	/* Kill program */
+#define AUDIT_RESP_TERM_ACCESS	2203	/* Terminate session */
+#define AUDIT_RESP_ACCT_REMOTE	2204	/* Acct locked from remote access*/
+#define AUDIT_RESP_ACCT_LOCK_TIMED	2205	/* User acct locked for time */
+#define AUDIT_RESP_ACCT_UNLOCK_TIMED	2206	/* User acct unlocked from time */
+#define AUDIT_RESP_ACCT_LOCK	2207	/* User acct was locked */
+#define AUDIT_RESP_TERM_LOCK	2208	/* Terminal was locked */
+#define AUDIT_RESP_SEBOOL	2209	/* Set an SE Linux boolean */
+#define AUDIT_RESP_EXEC		2210	/* Execute a script */
+#define AUDIT_RESP_SINGLE	2211	/* Go to single user mode */
+#define AUDIT_RESP_HALT		2212	/* take the system down */
+#define AUDIT_RESP_ORIGIN_BLOCK	2213	/* Address blocked by iptables */
+#define AUDIT_RESP_ORIGIN_BLOCK_TIMED	2214	/* Address blocked for time */
+#define AUDIT_RESP_ORIGIN_UNBLOCK_TIMED	2215	/* Address unblocked from timed block */
+#define AUDIT_FIRST_USER_LSPP_MSG	2300
+#define AUDIT_LAST_USER_LSPP_MSG	2399
+#define AUDIT_USER_ROLE_CHANGE	2300	/* User changed to a new role */
+#define AUDIT_ROLE_ASSIGN	2301	/* Admin assigned user to role */
+#define AUDIT_ROLE_REMOVE	2302	/* Admin removed user from role */
+#define AUDIT_LABEL_OVERRIDE	2303	/* Admin is overriding a label */
+#define AUDIT_LABEL_LEVEL_CHANGE	2304	/* Object's level was changed */
+#define AUDIT_USER_LABELED_EXPORT	2305	/* Object exported with label */
+#define AUDIT_USER_UNLABELED_EXPORT	2306	/* Object exported without label */
+#define AUDIT_DEV_ALLOC		2307	/* Device was allocated */
+#define AUDIT_DEV_DEALLOC	2308	/* Device was deallocated */
+#define AUDIT_FS_RELABEL	2309	/* Filesystem relabeled */
+#define AUDIT_USER_MAC_POLICY_LOAD	2310	/* Userspc daemon loaded policy */
+#define AUDIT_ROLE_MODIFY	2311	/* Admin modified a role */
+#define AUDIT_USER_MAC_CONFIG_CHANGE	2312	/* Change made to MAC policy */
+#define AUDIT_USER_MAC_STATUS	2313	/* Userspc daemon enforcing change */
+#define AUDIT_FIRST_CRYPTO_MSG	2400
+#define AUDIT_LAST_CRYPTO_MSG	2499
+#define AUDIT_CRYPTO_TEST_USER	2400	/* Crypto test results */
+#define AUDIT_CRYPTO_PARAM_CHANGE_USER	2401	/* Crypto attribute change */
+#define AUDIT_CRYPTO_LOGIN	2402	/* Logged in as crypto officer */
+#define AUDIT_CRYPTO_LOGOUT	2403	/* Logged out from crypto */
+#define AUDIT_CRYPTO_KEY_USER	2404	/* Create,delete,negate */
+#define AUDIT_CRYPTO_FAILURE_USER	2405	/* Fail decrypt,encrypt,randomize */
+#define AUDIT_CRYPTO_REPLAY_USER	2406	/* Crypto replay detected */
+#define AUDIT_CRYPTO_SESSION	2407	/* Record parameters set during TLS session establishment */
+#define AUDIT_CRYPTO_IKE_SA	2408	/* Record parameters related to IKE SA */
+#define AUDIT_CRYPTO_IPSEC_SA	2409	/* Record parameters related to IPSEC SA */
+#define AUDIT_FIRST_VIRT_MSG	2500
+#define AUDIT_LAST_VIRT_MSG	2599
+#define AUDIT_VIRT_CONTROL	2500	/* Start, Pause, Stop VM */
+#define AUDIT_VIRT_RESOURCE	2501	/* Resource assignment */
+#define AUDIT_VIRT_MACHINE_ID	2502	/* Binding of label to VM */
+#define AUDIT_VIRT_INTEGRITY_CHECK	2503	/* Guest integrity results */
+#define AUDIT_VIRT_CREATE	2504	/* Creation of guest image */
+#define AUDIT_VIRT_DESTROY	2505	/* Destruction of guest image */
+#define AUDIT_VIRT_MIGRATE_IN	2506	/* Inbound guest migration info */
+#define AUDIT_VIRT_MIGRATE_OUT	2507	/* Outbound guest migration info */
+`
+
+var defineRe = regexp.MustCompile(`^#define\s+(AUDIT_[A-Z0-9_]+)\s+([0-9]+)\s*(?:/\*\s*(.*?)\s*\*/)?`)
+
+type msgType struct {
+	name    string
+	value   int
+	comment string
+}
+
+func isRangeMarker(name string) bool {
+	return strings.HasPrefix(name, "AUDIT_FIRST_") || strings.HasPrefix(name, "AUDIT_LAST_")
+}
+
+func parse(r io.Reader, types map[string]msgType) error {
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		m := defineRe.FindStringSubmatch(strings.TrimSpace(sc.Text()))
+		if m == nil {
+			continue
+		}
+		v, err := strconv.Atoi(m[2])
+		if err != nil || v < 1000 || v > 2999 {
+			continue
+		}
+		if _, ok := types[m[1]]; ok {
+			continue
+		}
+		types[m[1]] = msgType{name: m[1], value: v, comment: m[3]}
+	}
+	return sc.Err()
+}
+
+func main() {
+	header := flag.String("h", "/usr/include/linux/audit.h", "kernel audit header")
+	out := flag.String("o", "AuditMsgTypes.go", "output file")
+	flag.Parse()
+
+	types := make(map[string]msgType)
+	f, err := os.Open(*header)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := parse(f, types); err != nil {
+		log.Fatal(err)
+	}
+	f.Close()
+	if err := parse(strings.NewReader(libauditTypes), types); err != nil {
+		log.Fatal(err)
+	}
+
+	list := make([]msgType, 0, len(types))
+	for _, t := range types {
+		list = append(list, t)
+	}
+	//Range markers sort after the real type sharing their value
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].value != list[j].value {
+			return list[i].value < list[j].value
+		}
+		if isRangeMarker(list[i].name) != isRangeMarker(list[j].name) {
+			return !isRangeMarker(list[i].name)
+		}
+		return list[i].name < list[j].name
+	})
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by AuditMsgTypesGen.go; DO NOT EDIT.\n\npackage main\n\n")
+	fmt.Fprintf(&b, "const (\n")
+	for _, t := range list {
+		if t.comment != "" {
+			fmt.Fprintf(&b, "\t%s = %d /* %s */\n", t.name, t.value, t.comment)
+		} else {
+			fmt.Fprintf(&b, "\t%s = %d\n", t.name, t.value)
+		}
+	}
+	fmt.Fprintf(&b, ")\n\n")
+
+	fmt.Fprintf(&b, "var auditMsgTypeNames = map[uint16]string{\n")
+	seen := make(map[int]bool)
+	for _, t := range list {
+		if isRangeMarker(t.name) || seen[t.value] {
+			continue
+		}
+		seen[t.value] = true
+		fmt.Fprintf(&b, "\t%s: %q,\n", t.name, strings.TrimPrefix(t.name, "AUDIT_"))
+	}
+	fmt.Fprintf(&b, "}\n")
+
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(*out, src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
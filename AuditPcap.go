@@ -0,0 +1,408 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	AUDIT_PCAP_MAGIC         = 0xa1b2c3d4 /* microsecond timestamps */
+	AUDIT_PCAP_MAGIC_NSEC    = 0xa1b23c4d
+	AUDIT_PCAP_SNAPLEN       = 256 << 10
+	AUDIT_PCAP_LINKTYPE      = 253 /* LINKTYPE_NETLINK */
+	AUDIT_PCAP_COOKED_HDRLEN = 16
+	AUDIT_PCAP_ARPHRD        = 824 /* ARPHRD_NETLINK */
+	AUDIT_PCAP_PACKET_HOST   = 0   /* received from the kernel */
+	AUDIT_PCAP_PACKET_OUT    = 4   /* sent to the kernel */
+	auditPcapFileHdrLen      = 24
+	auditPcapRecordHdrLen    = 16
+)
+
+var (
+	ErrAuditPcapFormat    = errors.New("audit pcap: not a pcap file")
+	ErrAuditPcapLinkType  = errors.New("audit pcap: link type is not LINKTYPE_NETLINK")
+	ErrAuditPcapByteOrder = errors.New("audit pcap: captured on a host of the other byte order")
+	ErrAuditPcapDiverged  = errors.New("audit pcap: request does not match the capture")
+)
+
+// One captured datagram.
+type AuditPcapPacket struct {
+	Time     time.Time
+	Outgoing bool   /* sent to the kernel */
+	Pid      uint32 /* port id of the socket, 0 if the capture has none */
+	Data     []byte /* netlink messages as on the wire */
+}
+
+// Wraps a transport and writes every datagram it sends or receives to a
+// pcap file with LINKTYPE_NETLINK, which tcpdump and wireshark read like
+// a capture on an nlmon device. Each packet starts with the 16 byte cooked
+// header: packet type, ARPHRD_NETLINK, the port id of the socket as
+// address and NETLINK_AUDIT as protocol. Receive errors such as ENOBUFS
+// are not captured.
+type AuditPcapRecorder struct {
+	NetlinkTransport
+
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer /* the file of CreateAuditPcapRecorder */
+	err    error     /* first write error, recording stops after it */
+	now    func() time.Time
+}
+
+// Starts a capture on w, writing the file header right away.
+func NewAuditPcapRecorder(t NetlinkTransport, w io.Writer) (*AuditPcapRecorder, error) {
+	b := make([]byte, auditPcapFileHdrLen)
+	e := nativeEndian()
+	e.PutUint32(b[0:4], AUDIT_PCAP_MAGIC)
+	e.PutUint16(b[4:6], 2)
+	e.PutUint16(b[6:8], 4)
+	e.PutUint32(b[16:20], AUDIT_PCAP_SNAPLEN)
+	e.PutUint32(b[20:24], AUDIT_PCAP_LINKTYPE)
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	return &AuditPcapRecorder{NetlinkTransport: t, w: w, now: time.Now}, nil
+}
+
+// Starts a capture into the file path, closed together with the recorder.
+func CreateAuditPcapRecorder(t NetlinkTransport, path string) (*AuditPcapRecorder, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	r, err := NewAuditPcapRecorder(t, f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	r.closer = f
+	return r, nil
+}
+
+// The first error writing the capture, if any.
+func (r *AuditPcapRecorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+func (r *AuditPcapRecorder) record(outgoing bool, data []byte) {
+	ts := r.now()
+	n := len(data)
+	if n > AUDIT_PCAP_SNAPLEN-AUDIT_PCAP_COOKED_HDRLEN {
+		n = AUDIT_PCAP_SNAPLEN - AUDIT_PCAP_COOKED_HDRLEN
+	}
+	b := make([]byte, auditPcapRecordHdrLen+AUDIT_PCAP_COOKED_HDRLEN, auditPcapRecordHdrLen+AUDIT_PCAP_COOKED_HDRLEN+n)
+	e := nativeEndian()
+	e.PutUint32(b[0:4], uint32(ts.Unix()))
+	e.PutUint32(b[4:8], uint32(ts.Nanosecond()/int(time.Microsecond)))
+	e.PutUint32(b[8:12], uint32(AUDIT_PCAP_COOKED_HDRLEN+n))
+	e.PutUint32(b[12:16], uint32(AUDIT_PCAP_COOKED_HDRLEN+len(data)))
+	//The cooked header is in network byte order
+	c := b[auditPcapRecordHdrLen:]
+	pkttype := uint16(AUDIT_PCAP_PACKET_HOST)
+	if outgoing {
+		pkttype = AUDIT_PCAP_PACKET_OUT
+	}
+	binary.BigEndian.PutUint16(c[0:2], pkttype)
+	binary.BigEndian.PutUint16(c[2:4], AUDIT_PCAP_ARPHRD)
+	binary.BigEndian.PutUint16(c[4:6], 4)
+	binary.BigEndian.PutUint32(c[6:10], r.Pid())
+	binary.BigEndian.PutUint16(c[14:16], syscall.NETLINK_AUDIT)
+	b = append(b, data[:n]...)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return
+	}
+	_, r.err = r.w.Write(b)
+}
+
+func (r *AuditPcapRecorder) Send(request *NetlinkAuditRequest) error {
+	r.record(true, request.ToWireFormat())
+	return r.NetlinkTransport.Send(request)
+}
+
+func (r *AuditPcapRecorder) Receive(bytesize int, block int) ([]syscall.NetlinkMessage, error) {
+	return receiveNetlinkMessages(r, bytesize, block)
+}
+
+func (r *AuditPcapRecorder) Recvfrom(b []byte, flags int) (int, error) {
+	n, err := r.NetlinkTransport.Recvfrom(b, flags)
+	if err == nil && n > 0 {
+		r.record(false, b[:n])
+	}
+	return n, err
+}
+
+func (r *AuditPcapRecorder) countOverrun() {
+	if c, ok := r.NetlinkTransport.(netlinkOverrunCounter); ok {
+		c.countOverrun()
+	}
+}
+
+func (r *AuditPcapRecorder) SetReceiveTimeout(d time.Duration) error {
+	if t, ok := r.NetlinkTransport.(netlinkReceiveTimeout); ok {
+		return t.SetReceiveTimeout(d)
+	}
+	return nil
+}
+
+// Closes the transport and the file of CreateAuditPcapRecorder.
+func (r *AuditPcapRecorder) Close() {
+	r.NetlinkTransport.Close()
+	if r.closer != nil {
+		r.closer.Close()
+	}
+}
+
+// Reads the NETLINK_AUDIT datagrams of a LINKTYPE_NETLINK capture, packets
+// of other netlink families (nlmon captures them all) are skipped.
+func ReadAuditPcap(rd io.Reader) ([]AuditPcapPacket, error) {
+	hdr := make([]byte, auditPcapFileHdrLen)
+	if _, err := io.ReadFull(rd, hdr); err != nil {
+		return nil, ErrAuditPcapFormat
+	}
+	var e binary.ByteOrder
+	var nsec bool
+	for _, o := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		switch o.Uint32(hdr[0:4]) {
+		case AUDIT_PCAP_MAGIC:
+			e = o
+		case AUDIT_PCAP_MAGIC_NSEC:
+			e, nsec = o, true
+		}
+	}
+	if e == nil {
+		return nil, ErrAuditPcapFormat
+	}
+	//The netlink messages are in the byte order of the capturing host
+	if e != nativeEndian() {
+		return nil, ErrAuditPcapByteOrder
+	}
+	if e.Uint32(hdr[20:24])&0xffff != AUDIT_PCAP_LINKTYPE {
+		return nil, ErrAuditPcapLinkType
+	}
+
+	var pkts []AuditPcapPacket
+	rec := make([]byte, auditPcapRecordHdrLen)
+	for {
+		if _, err := io.ReadFull(rd, rec); err == io.EOF {
+			return pkts, nil
+		} else if err != nil {
+			return pkts, err
+		}
+		sec, frac, incl := e.Uint32(rec[0:4]), e.Uint32(rec[4:8]), e.Uint32(rec[8:12])
+		if incl > AUDIT_PCAP_SNAPLEN {
+			return pkts, ErrAuditPcapFormat
+		}
+		b := make([]byte, incl)
+		if _, err := io.ReadFull(rd, b); err != nil {
+			return pkts, err
+		}
+		if len(b) < AUDIT_PCAP_COOKED_HDRLEN || binary.BigEndian.Uint16(b[14:16]) != syscall.NETLINK_AUDIT {
+			continue
+		}
+		if !nsec {
+			frac *= uint32(time.Microsecond)
+		}
+		p := AuditPcapPacket{
+			Time:     time.Unix(int64(sec), int64(frac)),
+			Outgoing: binary.BigEndian.Uint16(b[0:2]) == AUDIT_PCAP_PACKET_OUT,
+			Data:     b[AUDIT_PCAP_COOKED_HDRLEN:],
+		}
+		if binary.BigEndian.Uint16(b[4:6]) == 4 {
+			p.Pid = binary.BigEndian.Uint32(b[6:10])
+		}
+		pkts = append(pkts, p)
+	}
+}
+
+// Plays a capture back as a transport. Received datagrams are handed out
+// in capture order, byte for byte, so parse failures reproduce. Reads stop
+// at a captured request until the code under test sends one of the same
+// type; its sequence number then replaces the captured one in the replies
+// that follow. A request of another type fails with ErrAuditPcapDiverged.
+// Once the capture is used up reads behave like a quiet kernel.
+type AuditPcapReplay struct {
+	mu       sync.Mutex
+	packets  []AuditPcapPacket
+	pos      int               /* next packet to read */
+	sendPos  int               /* packets before it are matched requests */
+	seqs     map[uint32]uint32 /* captured sequence number to replayed one */
+	pid      uint32
+	timeout  time.Duration
+	wake     chan struct{}
+	closed   chan struct{}
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+func NewAuditPcapReplay(packets []AuditPcapPacket) *AuditPcapReplay {
+	p := &AuditPcapReplay{
+		packets: packets,
+		seqs:    make(map[uint32]uint32),
+		wake:    make(chan struct{}, 1),
+		closed:  make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	for _, pkt := range packets {
+		if pkt.Pid != 0 {
+			p.pid = pkt.Pid
+			break
+		}
+	}
+	if len(packets) == 0 {
+		close(p.done)
+	}
+	return p
+}
+
+func OpenAuditPcapReplay(path string) (*AuditPcapReplay, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	pkts, err := ReadAuditPcap(f)
+	if err != nil {
+		return nil, err
+	}
+	return NewAuditPcapReplay(pkts), nil
+}
+
+// Closed once every packet of the capture has been sent or read.
+func (p *AuditPcapReplay) Done() <-chan struct{} {
+	return p.done
+}
+
+// Must be called with mu held. Moves pos past the matched requests.
+func (p *AuditPcapReplay) checkDone() {
+	for p.pos < p.sendPos && p.packets[p.pos].Outgoing {
+		p.pos++
+	}
+	if p.pos >= len(p.packets) {
+		p.doneOnce.Do(func() { close(p.done) })
+	}
+}
+
+func (p *AuditPcapReplay) Send(request *NetlinkAuditRequest) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	select {
+	case <-p.closed:
+		return syscall.EBADF
+	default:
+	}
+	j := p.sendPos
+	if j < p.pos {
+		j = p.pos
+	}
+	for j < len(p.packets) && !p.packets[j].Outgoing {
+		j++
+	}
+	if j == len(p.packets) {
+		return ErrAuditPcapDiverged
+	}
+	data := p.packets[j].Data
+	if len(data) < syscall.NLMSG_HDRLEN || nativeEndian().Uint16(data[4:6]) != request.Header.Type {
+		return ErrAuditPcapDiverged
+	}
+	p.seqs[nativeEndian().Uint32(data[8:12])] = request.Header.Seq
+	p.sendPos = j + 1
+	p.checkDone()
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Replaces captured sequence numbers in the headers of b, and in the
+// request an NLMSG_ERROR echoes. Stops at the first malformed header and
+// leaves the rest as captured.
+func (p *AuditPcapReplay) rewrite(b []byte) {
+	e := nativeEndian()
+	for off := 0; off+syscall.NLMSG_HDRLEN <= len(b); {
+		l := int(e.Uint32(b[off : off+4]))
+		if l < syscall.NLMSG_HDRLEN || off+l > len(b) {
+			return
+		}
+		if seq, ok := p.seqs[e.Uint32(b[off+8:off+12])]; ok {
+			e.PutUint32(b[off+8:off+12], seq)
+		}
+		if e.Uint16(b[off+4:off+6]) == syscall.NLMSG_ERROR && l >= syscall.NLMSG_HDRLEN+4+syscall.NLMSG_HDRLEN {
+			s := b[off+syscall.NLMSG_HDRLEN+4+8 : off+syscall.NLMSG_HDRLEN+4+12]
+			if seq, ok := p.seqs[e.Uint32(s)]; ok {
+				e.PutUint32(s, seq)
+			}
+		}
+		off += nlmAlignOf(l)
+	}
+}
+
+func (p *AuditPcapReplay) Recvfrom(b []byte, flags int) (int, error) {
+	var timeout <-chan time.Time
+	for {
+		p.mu.Lock()
+		p.checkDone()
+		if p.pos < len(p.packets) && !p.packets[p.pos].Outgoing {
+			d := append([]byte(nil), p.packets[p.pos].Data...)
+			p.pos++
+			p.rewrite(d)
+			p.checkDone()
+			p.mu.Unlock()
+			return copy(b, d), nil
+		}
+		d := p.timeout
+		p.mu.Unlock()
+
+		if flags&syscall.MSG_DONTWAIT != 0 {
+			return 0, syscall.EAGAIN
+		}
+		if timeout == nil && d > 0 {
+			t := time.NewTimer(d)
+			defer t.Stop()
+			timeout = t.C
+		}
+		select {
+		case <-p.wake:
+		case <-timeout:
+			return 0, syscall.EAGAIN
+		case <-p.closed:
+			return 0, syscall.EBADF
+		}
+	}
+}
+
+func (p *AuditPcapReplay) Receive(bytesize int, block int) ([]syscall.NetlinkMessage, error) {
+	return receiveNetlinkMessages(p, bytesize, block)
+}
+
+func (p *AuditPcapReplay) SetReceiveTimeout(d time.Duration) error {
+	p.mu.Lock()
+	p.timeout = d
+	p.mu.Unlock()
+	return nil
+}
+
+// The port id of the captured socket.
+func (p *AuditPcapReplay) Pid() uint32 {
+	return p.pid
+}
+
+func (p *AuditPcapReplay) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	select {
+	case <-p.closed:
+	default:
+		close(p.closed)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Records a status round trip and one event against the fake kernel.
+func recordAuditTestPcap(t *testing.T) (*AuditFakeKernel, []AuditPcapPacket) {
+	k, s := newAuditFakeDaemon(t)
+	var b bytes.Buffer
+	r, err := NewAuditPcapRecorder(s, &b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.now = func() time.Time { return time.Unix(1700000000, 123456000) }
+	if _, err := AuditGetStatus(r); err != nil {
+		t.Fatal(err)
+	}
+	if err := AuditSetStatus(r, &AuditStatus{Mask: AUDIT_STATUS_RATE_LIMIT, Rate_limit: 50}); err != nil {
+		t.Fatal(err)
+	}
+	k.Emit(AUDIT_USER, "msg='x'")
+	r.SetReceiveTimeout(time.Second)
+	if _, err := r.Receive(MAX_AUDIT_MESSAGE_LENGTH, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	pkts, err := ReadAuditPcap(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k, pkts
+}
+
+func TestAuditPcapRecorder(t *testing.T) {
+	_, pkts := recordAuditTestPcap(t)
+	//GET, its ACK and reply, SET and its ACK, the event
+	if len(pkts) != 6 {
+		t.Fatalf("got %d packets", len(pkts))
+	}
+	for i, out := range []bool{true, false, false, true, false, false} {
+		if pkts[i].Outgoing != out || pkts[i].Pid == 0 {
+			t.Fatalf("packet %d: got %+v", i, pkts[i])
+		}
+	}
+	if !pkts[0].Time.Equal(time.Unix(1700000000, 123456000)) {
+		t.Fatalf("got time %v", pkts[0].Time)
+	}
+	msgs, err := ParseAuditNetlinkMessage(pkts[5].Data)
+	if err != nil || msgs[0].Header.Type != AUDIT_USER {
+		t.Fatalf("got %v %v", msgs, err)
+	}
+
+	if _, err := ReadAuditPcap(bytes.NewReader(make([]byte, auditPcapFileHdrLen))); err != ErrAuditPcapFormat {
+		t.Fatalf("got %v, want ErrAuditPcapFormat", err)
+	}
+}
+
+// The code that made a capture runs unchanged against its replay, with
+// sequence numbers of its own.
+func TestAuditPcapReplay(t *testing.T) {
+	_, pkts := recordAuditTestPcap(t)
+	p := NewAuditPcapReplay(pkts)
+	defer p.Close()
+
+	st, err := AuditGetStatus(p)
+	if err != nil || st.Enabled != 1 {
+		t.Fatalf("got %+v %v", st, err)
+	}
+	if err := AuditSetStatus(p, &AuditStatus{Mask: AUDIT_STATUS_RATE_LIMIT, Rate_limit: 50}); err != nil {
+		t.Fatal(err)
+	}
+	msgs, err := p.Receive(MAX_AUDIT_MESSAGE_LENGTH, syscall.MSG_DONTWAIT)
+	if err != nil || msgs[0].Header.Type != AUDIT_USER {
+		t.Fatalf("got %v %v", msgs, err)
+	}
+	select {
+	case <-p.Done():
+	default:
+		t.Fatal("capture not used up")
+	}
+	if _, err := p.Recvfrom(make([]byte, 16), syscall.MSG_DONTWAIT); err != syscall.EAGAIN {
+		t.Fatalf("got %v, want EAGAIN", err)
+	}
+
+	p = NewAuditPcapReplay(pkts)
+	defer p.Close()
+	if err := AuditSetStatus(p, &AuditStatus{Mask: AUDIT_STATUS_RATE_LIMIT}); err != ErrAuditPcapDiverged {
+		t.Fatalf("got %v, want ErrAuditPcapDiverged", err)
+	}
+}
+
+func TestAuditPcapFile(t *testing.T) {
+	k, s := newAuditFakeDaemon(t)
+	path := filepath.Join(t.TempDir(), "audit.pcap")
+	r, err := CreateAuditPcapRecorder(s, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.Emit(AUDIT_USER, "msg='x'")
+	b := make([]byte, MAX_AUDIT_MESSAGE_LENGTH)
+	r.SetReceiveTimeout(time.Second)
+	n, err := r.Recvfrom(b, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+
+	p, err := OpenAuditPcapReplay(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	got := make([]byte, MAX_AUDIT_MESSAGE_LENGTH)
+	if m, err := p.Recvfrom(got, syscall.MSG_DONTWAIT); err != nil || !bytes.Equal(got[:m], b[:n]) {
+		t.Fatalf("got %q %v, want %q", got[:m], err, b[:n])
+	}
+	if p.Pid() != s.Pid() {
+		t.Fatalf("got pid %d, want %d", p.Pid(), s.Pid())
+	}
+}
+
+func auditTestNetlinkMessage(typ uint16, seq uint32, data []byte) []byte {
+	rr := &NetlinkAuditRequest{Data: data}
+	rr.Header.Len = uint32(syscall.NLMSG_HDRLEN + len(data))
+	rr.Header.Type = typ
+	rr.Header.Seq = seq
+	return rr.ToWireFormat()
+}
+
+func TestAuditPcapReplayRewrite(t *testing.T) {
+	p := NewAuditPcapReplay(nil)
+	p.seqs[7] = 42
+	e := nativeEndian()
+
+	//A NACK echoing request 7, a reply to it padded to alignment, one to
+	//an unknown request and a truncated header
+	echo := append(make([]byte, 4), auditTestNetlinkMessage(AUDIT_GET, 7, nil)...)
+	errno := int32(syscall.EPERM)
+	e.PutUint32(echo[0:4], uint32(-errno))
+	b := auditTestNetlinkMessage(syscall.NLMSG_ERROR, 7, echo)
+	reply := auditTestNetlinkMessage(AUDIT_GET, 7, []byte{1})
+	b = append(b, reply...)
+	b = append(b, make([]byte, nlmAlignOf(len(reply))-len(reply))...)
+	b = append(b, auditTestNetlinkMessage(AUDIT_GET, 8, nil)...)
+	b = append(b, 0xff, 0xff, 0, 0)
+
+	p.rewrite(b)
+	echoSeq := syscall.NLMSG_HDRLEN + 4 + 8
+	next := syscall.NLMSG_HDRLEN + len(echo)
+	for off, want := range map[int]uint32{
+		8:                         42,
+		echoSeq:                   42,
+		next + 8:                  42,
+		next + nlmAlignOf(17) + 8: 8,
+	} {
+		if got := e.Uint32(b[off : off+4]); got != want {
+			t.Errorf("seq at %d: got %d, want %d", off, got, want)
+		}
+	}
+}
@@ -4,23 +4,19 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"os"
 	"syscall"
 	"unsafe"
 	"sync/atomic"
 	"time"
 )
 
+//Message types (AUDIT_GET, AUDIT_SYSCALL ...) are generated into AuditMsgTypes.go
 const (
 	MAX_AUDIT_MESSAGE_LENGTH = 8960
-	AUDIT_GET                = 1000
-	AUDIT_SET                = 1001 /* Set status (enable/disable/auditd) */
-	AUDIT_LIST               = 1002
-	AUDIT_LIST_RULES         = 1013
-	AUDIT_ADD_RULE           = 1011 /* Add syscall filtering rule */
-	AUDIT_FIRST_USER_MSG     = 1100 /* Userspace messages mostly uninteresting to kernel */
+	AUDIT_DEFAULT_RCVBUF     = 8 << 20 /* bytes, for SetReceiveBuffer */
 	AUDIT_MAX_FIELDS         = 64
 	AUDIT_BITMASK_SIZE       = 64
-	AUDIT_GET_FEATURE        = 1019
 	//Rule Flags
 	AUDIT_FILTER_USER  = 0x00 /* Apply rule to user-generated messages */
 	AUDIT_FILTER_TASK  = 0x01 /* Apply rule at task creation (not syscall) */
@@ -87,6 +83,7 @@ const (
 	/* distinguish syscall tables */
 	__AUDIT_ARCH_64BIT  = 0x80000000
 	__AUDIT_ARCH_LE     = 0x40000000
+	AUDIT_ARCH_AARCH64  = (EM_AARCH64 | __AUDIT_ARCH_64BIT | __AUDIT_ARCH_LE)
 	AUDIT_ARCH_ALPHA    = (EM_ALPHA | __AUDIT_ARCH_64BIT | __AUDIT_ARCH_LE)
 	AUDIT_ARCH_ARM      = (EM_ARM | __AUDIT_ARCH_LE)
 	AUDIT_ARCH_ARMEB    = (EM_ARM)
@@ -105,6 +102,7 @@ const (
 	//	AUDIT_ARCH_PARISC64 = (EM_PARISC | __AUDIT_ARCH_64BIT)
 	AUDIT_ARCH_PPC     = (EM_PPC)
 	AUDIT_ARCH_PPC64   = (EM_PPC64 | __AUDIT_ARCH_64BIT)
+	AUDIT_ARCH_PPC64LE = (EM_PPC64 | __AUDIT_ARCH_64BIT | __AUDIT_ARCH_LE)
 	AUDIT_ARCH_S390    = (EM_S390)
 	AUDIT_ARCH_S390X   = (EM_S390 | __AUDIT_ARCH_64BIT)
 	AUDIT_ARCH_SH      = (EM_SH)
@@ -195,6 +193,30 @@ type AuditRuleData struct {
 type NetlinkSocket struct {
 	fd  int
 	lsa syscall.SockaddrNetlink
+	pid uint32 /* port id the kernel assigned on bind */
+
+	overruns  uint64             /* ENOBUFS seen by Getreply, atomic */
+	OnOverrun func(total uint64) /* called by Getreply after ENOBUFS, e.g. to resync status */
+}
+
+// What the library needs from an audit netlink socket. NetlinkSocket is
+// the real one, AuditFakeKernel hands out in-process ones for tests.
+type NetlinkTransport interface {
+	Send(request *NetlinkAuditRequest) error
+	Receive(bytesize int, block int) ([]syscall.NetlinkMessage, error)
+	Recvfrom(b []byte, flags int) (int, error) /* one datagram, flags 0 or syscall.MSG_DONTWAIT */
+	Pid() uint32                               /* port id replies are addressed to */
+	Close()
+}
+
+// Optional for transports: counts the ENOBUFS Getreply sees.
+type netlinkOverrunCounter interface {
+	countOverrun()
+}
+
+// Optional for transports: bounds blocking reads so readers can poll done.
+type netlinkReceiveTimeout interface {
+	SetReceiveTimeout(d time.Duration) error
 }
 
 type NetlinkAuditRequest struct {
@@ -215,13 +237,14 @@ func nativeEndian() binary.ByteOrder {
 //The recvfrom in go takes only a byte [] to put the data recieved from the kernel that removes the need
 //for having a separate audit_reply Struct for recieving data from kernel.
 func (rr *NetlinkAuditRequest) ToWireFormat() []byte {
-	b := make([]byte, rr.Header.Len)
-	*(*uint32)(unsafe.Pointer(&b[0:4][0])) = rr.Header.Len
-	*(*uint16)(unsafe.Pointer(&b[4:6][0])) = rr.Header.Type
-	*(*uint16)(unsafe.Pointer(&b[6:8][0])) = rr.Header.Flags
-	*(*uint32)(unsafe.Pointer(&b[8:12][0])) = rr.Header.Seq
-	*(*uint32)(unsafe.Pointer(&b[12:16][0])) = rr.Header.Pid
-	b = append(b[:16], rr.Data[:]...) //Important b[:16]
+	b := make([]byte, syscall.NLMSG_HDRLEN, rr.Header.Len)
+	e := nativeEndian()
+	e.PutUint32(b[0:4], rr.Header.Len)
+	e.PutUint16(b[4:6], rr.Header.Type)
+	e.PutUint16(b[6:8], rr.Header.Flags)
+	e.PutUint32(b[8:12], rr.Header.Seq)
+	e.PutUint32(b[12:16], rr.Header.Pid)
+	b = append(b, rr.Data[:]...)
 	return b
 }
 
@@ -246,7 +269,6 @@ func ParseAuditNetlinkMessage(b []byte) ([]syscall.NetlinkMessage, error) {
 	for len(b) >= syscall.NLMSG_HDRLEN {
 		h, dbuf, dlen, err := netlinkMessageHeaderAndData(b)
 		if err != nil {
-			fmt.Println("Error in parsing")
 			return nil, err
 		}
 		m := syscall.NetlinkMessage{Header: *h, Data: dbuf[:int(h.Len)-syscall.NLMSG_HDRLEN]}
@@ -257,16 +279,23 @@ func ParseAuditNetlinkMessage(b []byte) ([]syscall.NetlinkMessage, error) {
 }
 
 func netlinkMessageHeaderAndData(b []byte) (*syscall.NlMsghdr, []byte, int, error) {
-
-	h := (*syscall.NlMsghdr)(unsafe.Pointer(&b[0]))
+	e := nativeEndian()
+	h := &syscall.NlMsghdr{
+		Len:   e.Uint32(b[0:4]),
+		Type:  e.Uint16(b[4:6]),
+		Flags: e.Uint16(b[6:8]),
+		Seq:   e.Uint32(b[8:12]),
+		Pid:   e.Uint32(b[12:16]),
+	}
 	if int(h.Len) < syscall.NLMSG_HDRLEN || int(h.Len) > len(b) {
-		foo := int32(nativeEndian().Uint32(b[0:4]))
-		fmt.Println(foo) //!!!!While recv message this is causing bug ! FIX THIS
-
-		fmt.Println("Error due to....HDRLEN:", syscall.NLMSG_HDRLEN, " Header Length:", h.Len, " Length of BYTE Array:", len(b))
 		return nil, nil, 0, syscall.EINVAL
 	}
-	return h, b[syscall.NLMSG_HDRLEN:], nlmAlignOf(int(h.Len)), nil
+	//The last message of a datagram may lack its alignment padding
+	next := nlmAlignOf(int(h.Len))
+	if next > len(b) {
+		next = len(b)
+	}
+	return h, b[syscall.NLMSG_HDRLEN:], next, nil
 }
 
 // This function makes a conncetion with kernel space and is to be used for all further socket communication
@@ -287,9 +316,54 @@ func GetNetlinkSocket() (*NetlinkSocket, error) {
 		syscall.Close(fd)
 		return nil, err
 	}
+	//Looked up once, replies are matched against it for every message
+	lsa, err := syscall.Getsockname(fd)
+	if err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	if nl, ok := lsa.(*syscall.SockaddrNetlink); ok {
+		s.pid = nl.Pid
+	}
 	return s, nil
 }
 
+// Sizes the receive buffer so bursts fit in between reads. SO_RCVBUFFORCE
+// needs CAP_NET_ADMIN and ignores rmem_max, SO_RCVBUF is the fallback.
+func (s *NetlinkSocket) SetReceiveBuffer(bytes int) error {
+	if err := syscall.SetsockoptInt(s.fd, syscall.SOL_SOCKET, syscall.SO_RCVBUFFORCE, bytes); err == nil {
+		return nil
+	}
+	return syscall.SetsockoptInt(s.fd, syscall.SOL_SOCKET, syscall.SO_RCVBUF, bytes)
+}
+
+// The receive buffer size the kernel granted (twice the requested size).
+func (s *NetlinkSocket) ReceiveBuffer() (int, error) {
+	return syscall.GetsockoptInt(s.fd, syscall.SOL_SOCKET, syscall.SO_RCVBUF)
+}
+
+// Number of receive buffer overruns (ENOBUFS) Getreply has seen.
+func (s *NetlinkSocket) Overruns() uint64 {
+	return atomic.LoadUint64(&s.overruns)
+}
+
+func (s *NetlinkSocket) countOverrun() {
+	total := atomic.AddUint64(&s.overruns, 1)
+	if s.OnOverrun != nil {
+		s.OnOverrun(total)
+	}
+}
+
+// Makes blocking reads return EAGAIN after d, 0 blocks forever.
+func (s *NetlinkSocket) SetReceiveTimeout(d time.Duration) error {
+	tv := syscall.NsecToTimeval(int64(d))
+	return syscall.SetsockoptTimeval(s.fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv)
+}
+
+func (s *NetlinkSocket) Pid() uint32 {
+	return s.pid
+}
+
 //To end the socket conncetion
 func (s *NetlinkSocket) Close() {
 	syscall.Close(s.fd)
@@ -302,9 +376,19 @@ func (s *NetlinkSocket) Send(request *NetlinkAuditRequest) error {
 	return nil
 }
 
+func (s *NetlinkSocket) Recvfrom(b []byte, flags int) (int, error) {
+	nr, _, err := syscall.Recvfrom(s.fd, b, flags)
+	return nr, err
+}
+
 func (s *NetlinkSocket) Receive(bytesize int, block int) ([]syscall.NetlinkMessage, error) {
+	return receiveNetlinkMessages(s, bytesize, block)
+}
+
+// Reads one datagram of at most bytesize bytes from t and parses it.
+func receiveNetlinkMessages(t NetlinkTransport, bytesize int, block int) ([]syscall.NetlinkMessage, error) {
 	rb := make([]byte, bytesize)
-	nr, _, err := syscall.Recvfrom(s.fd, rb, 0|block)
+	nr, err := t.Recvfrom(rb, 0|block)
 	//nr, _, err := syscall.Recvfrom(s, rb, syscall.MSG_PEEK|syscall.MSG_DONTWAIT)
 
 	if err != nil {
@@ -317,7 +401,7 @@ func (s *NetlinkSocket) Receive(bytesize int, block int) ([]syscall.NetlinkMessa
 	return ParseAuditNetlinkMessage(rb) //Or syscall.ParseNetlinkMessage(rb)
 }
 
-func AuditSend(s *NetlinkSocket, proto int, data []byte, sizedata /*,seq */ int) error {
+func AuditSend(s NetlinkTransport, proto int, data []byte, sizedata /*,seq */ int) error {
 
 	wb := newNetlinkAuditRequest(proto, syscall.AF_NETLINK, sizedata)
 	wb.Data = append(wb.Data[:], data[:]...)
@@ -327,7 +411,7 @@ func AuditSend(s *NetlinkSocket, proto int, data []byte, sizedata /*,seq */ int)
 	return nil
 }
 
-func AuditGetReply(s *NetlinkSocket, bytesize, block, seq int) error {
+func AuditGetReply(s NetlinkTransport, bytesize, block, seq int) error {
 done:
 	for {
 		msgs, err := s.Receive(bytesize, block) //ParseAuditNetlinkMessage(rb)
@@ -335,55 +419,23 @@ done:
 			return err
 		}
 		for _, m := range msgs {
-			lsa, err := syscall.Getsockname(s.fd)
-			if err != nil {
-				return err
-			}
-			switch v := lsa.(type) {
-			case *syscall.SockaddrNetlink:
-
-				if m.Header.Seq != uint32(seq) || m.Header.Pid != v.Pid {
-					fmt.Println("foo", seq, m.Header.Seq)
-					return syscall.EINVAL
-				}
-			default:
+			//Replies from the kernel carry port id 0, only ACKs carry ours
+			if m.Header.Seq != uint32(seq) || (m.Header.Pid != 0 && m.Header.Pid != s.Pid()) {
 				return syscall.EINVAL
-
 			}
 
 			if m.Header.Type == syscall.NLMSG_DONE {
-				fmt.Println("Done")
 				break done
 			}
 			if m.Header.Type == syscall.NLMSG_ERROR {
-				error := int32(nativeEndian().Uint32(m.Data[0:4]))
-				if error == 0 {
-					fmt.Println("furr")
-					break done
+				if len(m.Data) < 4 {
+					return syscall.EINVAL
+				}
+				//A NACK carries the negative errno, an ACK 0
+				if errno := -int32(nativeEndian().Uint32(m.Data[0:4])); errno != 0 {
+					return syscall.Errno(errno)
 				}
-
-				fmt.Println("NLMSG_ERROR")
 				break done
-				//return nil
-			}
-			if m.Header.Type == AUDIT_GET {
-				fmt.Println("AUDIT_GET")
-				//				break done
-			}
-			if m.Header.Type == AUDIT_FIRST_USER_MSG {
-				fmt.Println("AUDIT_FIRST_USER_MS")
-				//break done
-			}
-			if m.Header.Type == AUDIT_LIST_RULES {
-				fmt.Println("AUDIT_LIST_RULES")
-				//break done
-			}
-			if m.Header.Type == AUDIT_FIRST_USER_MSG {
-				fmt.Println("AUDIT_FIRST_USER_MSG")
-				//break done
-			}
-			if m.Header.Type == 1009 {
-				fmt.Println("Watchlist")
 			}
 
 		}
@@ -392,7 +444,7 @@ done:
 
 }
 
-func AuditSetEnabled(s *NetlinkSocket, seq int) error {
+func AuditSetEnabled(s NetlinkTransport, seq int) error {
 	var status AuditStatus
 	status.Enabled = 1
 	status.Mask = AUDIT_STATUS_ENABLED
@@ -415,7 +467,7 @@ func AuditSetEnabled(s *NetlinkSocket, seq int) error {
 	return nil
 }
 
-func AuditIsEnabled(s *NetlinkSocket, seq int) error {
+func AuditIsEnabled(s NetlinkTransport) error {
 	wb := newNetlinkAuditRequest(AUDIT_GET, syscall.AF_NETLINK, 0)
 
 	if err := s.Send(wb); err != nil {
@@ -431,26 +483,12 @@ done:
 		}
 
 		for _, m := range msgs {
-			lsa, er := syscall.Getsockname(s.fd)
-			if er != nil {
-				return nil
-			}
-			switch v := lsa.(type) {
-			case *syscall.SockaddrNetlink:
-
-				if m.Header.Seq != uint32(seq) || m.Header.Pid != v.Pid {
-					return syscall.EINVAL
-				}
-			default:
+			//Replies from the kernel carry port id 0, only ACKs carry ours
+			if m.Header.Seq != wb.Header.Seq || (m.Header.Pid != 0 && m.Header.Pid != s.Pid()) {
 				return syscall.EINVAL
 			}
 			if m.Header.Type == syscall.NLMSG_DONE {
-				fmt.Println("Done")
 				break done
-
-			}
-			if m.Header.Type == syscall.NLMSG_ERROR {
-				fmt.Println("NLMSG_ERROR\n")
 			}
 			if m.Header.Type == AUDIT_GET {
 				//Conversion of the data part written to AuditStatus struct
@@ -458,12 +496,10 @@ done:
 				b := m.Data[:]
 				buf := bytes.NewBuffer(b)
 				var dumm AuditStatus
-				err = binary.Read(buf, nativeEndian(), &dumm)
+				if err := binary.Read(buf, nativeEndian(), &dumm); err != nil {
+					return err
+				}
 				ParsedResult = dumm
-				//fmt.Println("\nstruct :", dumm, err)
-				//fmt.Println("\nStatus: ", dumm.Enabled)
-
-				fmt.Println("ENABLED")
 				break done
 			}
 
@@ -473,7 +509,7 @@ done:
 	return nil
 
 }
-func AuditSetPid(s *NetlinkSocket, pid uint32 /*,Wait mode WAIT_YES | WAIT_NO */) error {
+func AuditSetPid(s NetlinkTransport, pid uint32 /*,Wait mode WAIT_YES | WAIT_NO */) error {
 	var status AuditStatus
 	status.Mask = AUDIT_STATUS_PID
 	status.Pid = pid
@@ -519,7 +555,7 @@ func AuditRuleSyscallData(rule *AuditRuleData, scall int) error {
 	return nil
 }
 
-func AuditAddRuleData(s *NetlinkSocket, rule *AuditRuleData, flags int, action int) error {
+func AuditAddRuleData(s NetlinkTransport, rule *AuditRuleData, flags int, action int) error {
 
 	if flags == AUDIT_FILTER_ENTRY {
 		fmt.Println("Use of entry filter is deprecated")
@@ -557,18 +593,31 @@ func isDone(msgchan chan<- syscall.NetlinkMessage, errchan chan<- error, done <-
 	return d
 }
 
-func Getreply(s *NetlinkSocket, msgchan chan<- syscall.NetlinkMessage, errchan chan<- error, done <-chan bool) {
+func Getreply(s NetlinkTransport, msgchan chan<- syscall.NetlinkMessage, errchan chan<- error, done <-chan bool) {
 
 	rb := make([]byte, MAX_AUDIT_MESSAGE_LENGTH)
 
 	for {
 
-		nr, _, err := syscall.Recvfrom(s.fd, rb, 0 /*Do not use syscall.MSG_DONTWAIT*/)
+		nr, err := s.Recvfrom(rb, 0 /*Do not use syscall.MSG_DONTWAIT*/)
 
 		if isDone(msgchan, errchan, done) {
 			return
 		}
 
+		if err == syscall.ENOBUFS {
+			//The kernel dropped messages because the buffer was full, the
+			//socket itself is fine so keep reading. The overrun is counted,
+			//reporting it must not hold up the reads that drain the backlog
+			if c, ok := s.(netlinkOverrunCounter); ok {
+				c.countOverrun()
+			}
+			select {
+			case errchan <- err:
+			default:
+			}
+			continue
+		}
 		if err != nil {
 			errchan <- err
 			continue
@@ -578,64 +627,18 @@ func Getreply(s *NetlinkSocket, msgchan chan<- syscall.NetlinkMessage, errchan c
 			continue
 		}
 
-		rb = rb[:nr]
-
-		msgs, err := ParseAuditNetlinkMessage(rb) //Or syscall.ParseNetlinkMessage(rb)
+		//The messages reference their buffer and outlive this iteration,
+		//rb itself stays full size for the next read
+		buf := make([]byte, nr)
+		copy(buf, rb[:nr])
+		msgs, err := ParseAuditNetlinkMessage(buf) //Or syscall.ParseNetlinkMessage(rb)
 
 		if err != nil {
 			errchan <- err
 			continue
 		}
 		for _, m := range msgs {
-			/*
-				Not needed while receiving from kernel ?
-							lsa, err := syscall.Getsockname(s.fd)
-							if err != nil {
-								errchan <- err
-								continue
-								//return err
-							}
-								switch v := lsa.(type) {
-								case *syscall.SockaddrNetlink:
-
-									if m.Header.Seq != uint32(seq) || m.Header.Pid != v.Pid {
-										fmt.Println("foo", seq, m.Header.Seq)
-										errchan <- syscall.EINVAL
-										//return syscall.EINVAL
-									}
-								default:
-									errchan <- syscall.EINVAL
-									//return syscall.EINVAL
-
-								}
-			*/
-			//Decide on various message Types
-			if m.Header.Type == syscall.NLMSG_DONE {
-				fmt.Println("Done")
-				msgchan <- m
-				//continue
-			}
-			if m.Header.Type == syscall.NLMSG_ERROR {
-				err := int32(nativeEndian().Uint32(m.Data[0:4]))
-				if err == 0 {
-					fmt.Println("furr")
-					//continue
-				}
-
-				fmt.Println("NLMSG_ERROR")
-				msgchan <- m
-				//continue
-			}
-			if m.Header.Type == AUDIT_GET {
-				fmt.Println("AUDIT_GET")
-				msgchan <- m
-				//continue
-			}
-			if m.Header.Type == AUDIT_FIRST_USER_MSG {
-				fmt.Println("AUDIT_FIRST_USER_MSG")
-				msgchan <- m
-				//continue
-			}
+			msgchan <- m
 		}
 
 	}
@@ -662,7 +665,7 @@ func main() {
 	defer s.Close()
 
 	netlinkAudit.AuditSetEnabled(s, 1)
-	err = netlinkAudit.AuditIsEnabled(s, 2)
+	err = netlinkAudit.AuditIsEnabled(s)
 	fmt.Println("parsedResult")
 	fmt.Println(netlinkAudit.ParsedResult)
 	if err == nil {
@@ -681,9 +684,10 @@ func main() {
 		fmt.Println(err)
 	}
 	defer s.Close()
+	s.SetReceiveBuffer(AUDIT_DEFAULT_RCVBUF)
 
 	AuditSetEnabled(s, 1)
-	err = AuditIsEnabled(s, 2)
+	err = AuditIsEnabled(s)
 	fmt.Println("parsedResult")
 	fmt.Println(ParsedResult)
 	if err == nil {
@@ -707,15 +711,27 @@ func main() {
 	msgchan := make(chan syscall.NetlinkMessage)
 	errchan := make(chan error)
 
+	evchan := make(chan *AuditEvent)
+	parseErrchan := make(chan error)
+
 	go Getreply(s, msgchan, errchan, done)
+	go AuditEventStream(NewAuditEventAssembler(0, 0), msgchan, evchan, parseErrchan)
 
+	jsonw := NewAuditJSONWriter(os.Stdout, NewAuditInterpreter())
 	go func() {
 		for {
 			select {
 			case ev := <-errchan:
 				fmt.Println("Eror", ev)
-			case ev := <-msgchan:
-				fmt.Println("Message", ev)
+			case ev := <-parseErrchan:
+				fmt.Println("Parse error", ev)
+			case ev, ok := <-evchan:
+				if !ok {
+					return
+				}
+				if err := jsonw.WriteEvent(ev); err != nil {
+					fmt.Println("Eror", err)
+				}
 			}
 
 		}
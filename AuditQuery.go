@@ -0,0 +1,548 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Field names understood by the query language besides plain record fields.
+//
+//	time, ts          event time: RFC 3339, "2006-01-02 15:04:05", "2006-01-02",
+//	                  "15:04[:05]" (today), epoch seconds, now, recent, today, yesterday
+//	type              record type name or number, matches any record of the event
+//	key               rule key, any of the keys of the event
+//	success           yes/no, from success= or res=
+//	syscall           syscall name or number
+//	path, file        any PATH name (relative names are joined with the cwd)
+//	host, node        node name
+//	serial            audit serial number
+//	uid, auid, ...    numeric id or user/group name
+//
+// Anything else is compared to the record fields of the same name.
+// Operators: = != < <= > >= and ~ (substring). Terms are combined with
+// and, or, not and parentheses:
+//
+//	key=identity and auid=1001 and time>="10:00" and time<"11:00" and success=no
+type AuditQuery struct {
+	Expr   string
+	Interp *AuditInterpreter /* resolves user and group names, optional */
+
+	root auditQueryNode
+}
+
+type auditQueryNode interface {
+	match(q *AuditQuery, ev *AuditEvent) bool
+}
+
+type auditQueryAnd struct{ l, r auditQueryNode }
+type auditQueryOr struct{ l, r auditQueryNode }
+type auditQueryNot struct{ n auditQueryNode }
+
+type auditQueryCmp struct {
+	field string
+	op    string
+	value string
+	num   int64
+	isNum bool
+	when  time.Time
+	//now, recent, today, yesterday and times of day move with the clock
+	relative bool
+}
+
+func (n *auditQueryAnd) match(q *AuditQuery, ev *AuditEvent) bool {
+	return n.l.match(q, ev) && n.r.match(q, ev)
+}
+
+func (n *auditQueryOr) match(q *AuditQuery, ev *AuditEvent) bool {
+	return n.l.match(q, ev) || n.r.match(q, ev)
+}
+
+func (n *auditQueryNot) match(q *AuditQuery, ev *AuditEvent) bool {
+	return !n.n.match(q, ev)
+}
+
+// An empty expression matches every event.
+func ParseAuditQuery(expr string) (*AuditQuery, error) {
+	q := &AuditQuery{Expr: expr, Interp: NewAuditInterpreter()}
+	toks, err := tokenizeAuditQuery(expr)
+	if err != nil {
+		return nil, err
+	}
+	if len(toks) == 0 {
+		return q, nil
+	}
+	p := &auditQueryParser{toks: toks}
+	q.root, err = p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.toks) {
+		return nil, fmt.Errorf("audit query: unexpected %q", p.toks[p.pos].text)
+	}
+	return q, nil
+}
+
+func (q *AuditQuery) Match(ev *AuditEvent) bool {
+	if q == nil || q.root == nil {
+		return true
+	}
+	return q.root.match(q, ev)
+}
+
+type auditQueryToken struct {
+	text   string
+	quoted bool
+}
+
+func tokenizeAuditQuery(s string) ([]auditQueryToken, error) {
+	var toks []auditQueryToken
+	i := 0
+	for i < len(s) {
+		c := s[i]
+		switch {
+		case isAuditQuerySpace(c):
+			i++
+		case c == '(' || c == ')':
+			toks = append(toks, auditQueryToken{text: string(c)})
+			i++
+		case c == '"' || c == '\'':
+			end := strings.IndexByte(s[i+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("audit query: unterminated string at %d", i)
+			}
+			toks = append(toks, auditQueryToken{text: s[i+1 : i+1+end], quoted: true})
+			i += end + 2
+		case strings.IndexByte("=!<>~", c) >= 0:
+			op := string(c)
+			if i+1 < len(s) && s[i+1] == '=' && c != '=' && c != '~' {
+				op += "="
+			}
+			if op == "!" {
+				return nil, fmt.Errorf("audit query: lone ! at %d", i)
+			}
+			toks = append(toks, auditQueryToken{text: op})
+			i += len(op)
+		default:
+			j := i
+			//Stops only on bytes the cases above consume, so j > i. Bytes
+			//of multibyte UTF-8 characters are part of the word.
+			for j < len(s) && !isAuditQuerySpace(s[j]) && strings.IndexByte("()\"'=!<>~", s[j]) < 0 {
+				j++
+			}
+			toks = append(toks, auditQueryToken{text: s[i:j]})
+			i = j
+		}
+	}
+	return toks, nil
+}
+
+func isAuditQuerySpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f'
+}
+
+type auditQueryParser struct {
+	toks []auditQueryToken
+	pos  int
+}
+
+func (p *auditQueryParser) peekWord(w string) bool {
+	return p.pos < len(p.toks) && !p.toks[p.pos].quoted && strings.EqualFold(p.toks[p.pos].text, w)
+}
+
+func (p *auditQueryParser) parseOr() (auditQueryNode, error) {
+	l, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekWord("or") || p.peekWord("||") {
+		p.pos++
+		r, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l = &auditQueryOr{l, r}
+	}
+	return l, nil
+}
+
+func (p *auditQueryParser) parseAnd() (auditQueryNode, error) {
+	l, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekWord("and") || p.peekWord("&&") {
+		p.pos++
+		r, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		l = &auditQueryAnd{l, r}
+	}
+	return l, nil
+}
+
+func (p *auditQueryParser) parseNot() (auditQueryNode, error) {
+	if p.peekWord("not") {
+		p.pos++
+		n, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &auditQueryNot{n}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *auditQueryParser) parsePrimary() (auditQueryNode, error) {
+	if p.pos >= len(p.toks) {
+		return nil, fmt.Errorf("audit query: unexpected end of expression")
+	}
+	if p.peekWord("(") {
+		p.pos++
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.peekWord(")") {
+			return nil, fmt.Errorf("audit query: missing )")
+		}
+		p.pos++
+		return n, nil
+	}
+	if p.pos+2 >= len(p.toks) {
+		return nil, fmt.Errorf("audit query: incomplete term %q", p.toks[p.pos].text)
+	}
+	field, op, value := p.toks[p.pos], p.toks[p.pos+1], p.toks[p.pos+2]
+	if field.quoted || op.quoted || strings.IndexByte("=!<>~", op.text[0]) < 0 {
+		return nil, fmt.Errorf("audit query: expected field op value at %q", field.text)
+	}
+	p.pos += 3
+	return newAuditQueryCmp(strings.ToLower(field.text), op.text, value.text)
+}
+
+func newAuditQueryCmp(field, op, value string) (*auditQueryCmp, error) {
+	c := &auditQueryCmp{field: field, op: op, value: value}
+	switch field {
+	case "time", "ts":
+		t, err := parseAuditQueryTime(value, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		c.when, c.relative = t, isAuditQueryRelativeTime(value)
+		return c, nil
+	case "type":
+		t, ok := AuditMsgTypeByName(value)
+		if !ok {
+			return nil, fmt.Errorf("audit query: unknown record type %q", value)
+		}
+		c.num, c.isNum = int64(t), true
+		return c, nil
+	case "success":
+		switch strings.ToLower(value) {
+		case "yes", "success", "1", "true":
+			c.value = "yes"
+		case "no", "failed", "fail", "0", "false":
+			c.value = "no"
+		default:
+			return nil, fmt.Errorf("audit query: bad success value %q", value)
+		}
+		return c, nil
+	}
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+		c.num, c.isNum = n, true
+	}
+	if (op == "<" || op == "<=" || op == ">" || op == ">=") && !c.isNum {
+		return nil, fmt.Errorf("audit query: %s needs a number, got %q", op, value)
+	}
+	return c, nil
+}
+
+func isAuditQueryRelativeTime(v string) bool {
+	switch strings.ToLower(v) {
+	case "now", "recent", "today", "yesterday":
+		return true
+	}
+	for _, layout := range []string{"15:04:05", "15:04"} {
+		if _, err := time.Parse(layout, v); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func parseAuditQueryTime(v string, now time.Time) (time.Time, error) {
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	switch strings.ToLower(v) {
+	case "now":
+		return now, nil
+	case "recent":
+		return now.Add(-10 * time.Minute), nil
+	case "today":
+		return midnight, nil
+	case "yesterday":
+		return midnight.AddDate(0, 0, -1), nil
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, v, now.Location()); err == nil {
+			return t, nil
+		}
+	}
+	for _, layout := range []string{"15:04:05", "15:04"} {
+		if t, err := time.ParseInLocation(layout, v, now.Location()); err == nil {
+			return midnight.Add(time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second), nil
+		}
+	}
+	if secs, err := strconv.ParseFloat(v, 64); err == nil {
+		return time.Unix(0, int64(secs*float64(time.Second))), nil
+	}
+	return time.Time{}, fmt.Errorf("audit query: bad time %q", v)
+}
+
+func (c *auditQueryCmp) match(q *AuditQuery, ev *AuditEvent) bool {
+	switch c.field {
+	case "time", "ts":
+		when := c.when
+		if c.relative {
+			//Resolved per match so a long running filter keeps up with the clock
+			when, _ = parseAuditQueryTime(c.value, time.Now())
+		}
+		return compareAuditQuery(c.op, ev.Timestamp.Compare(when))
+	case "serial":
+		return compareAuditQuery(c.op, int64Compare(int64(ev.Serial), c.num))
+	case "host", "node":
+		return c.matchStrings([]string{ev.Node})
+	case "type":
+		var types []int64
+		for _, r := range ev.Records {
+			types = append(types, int64(r.Type))
+		}
+		return c.matchNumbers(types)
+	case "key":
+		var keys []string
+		for _, r := range ev.Records {
+			keys = append(keys, r.Keys()...)
+		}
+		return c.matchStrings(keys)
+	case "success":
+		return c.matchStrings([]string{auditEventSuccess(ev)})
+	case "syscall":
+		return c.matchSyscall(ev)
+	case "path", "file":
+		return c.matchStrings(auditEventPaths(ev))
+	}
+
+	var values []string
+	for _, r := range ev.Records {
+		for _, f := range r.Fields {
+			if f.Name == c.field && !f.Enriched {
+				values = append(values, f.Value)
+			}
+		}
+	}
+	if auditUidFields[c.field] || auditGidFields[c.field] {
+		return c.matchIds(q, values)
+	}
+	if c.isNum {
+		var nums []int64
+		for _, v := range values {
+			if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+				nums = append(nums, n)
+			}
+		}
+		return c.matchNumbers(nums)
+	}
+	return c.matchStrings(values)
+}
+
+// != holds when no value is equal, the other operators when any value matches.
+func (c *auditQueryCmp) matchStrings(values []string) bool {
+	if c.op == "!=" {
+		for _, v := range values {
+			if v == c.value {
+				return false
+			}
+		}
+		return true
+	}
+	for _, v := range values {
+		switch c.op {
+		case "=":
+			if v == c.value {
+				return true
+			}
+		case "~":
+			if strings.Contains(v, c.value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c *auditQueryCmp) matchNumbers(values []int64) bool {
+	if c.op == "!=" {
+		for _, v := range values {
+			if v == c.num {
+				return false
+			}
+		}
+		return true
+	}
+	for _, v := range values {
+		if c.op != "~" && compareAuditQuery(c.op, int64Compare(v, c.num)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *auditQueryCmp) matchIds(q *AuditQuery, values []string) bool {
+	if c.isNum {
+		var nums []int64
+		for _, v := range values {
+			if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+				nums = append(nums, n)
+			}
+		}
+		return c.matchNumbers(nums)
+	}
+	names := make([]string, 0, len(values))
+	for _, v := range values {
+		if q.Interp == nil {
+			names = append(names, v)
+		} else if auditGidFields[c.field] {
+			names = append(names, q.Interp.GroupName(v))
+		} else {
+			names = append(names, q.Interp.UserName(v))
+		}
+	}
+	return c.matchStrings(names)
+}
+
+func (c *auditQueryCmp) matchSyscall(ev *AuditEvent) bool {
+	r := ev.Record(AUDIT_SYSCALL)
+	if r == nil {
+		return c.op == "!="
+	}
+	nr := r.Value("syscall")
+	if c.isNum {
+		n, err := strconv.ParseInt(nr, 10, 64)
+		return err == nil && c.matchNumbers([]int64{n})
+	}
+	arch := uint32(0)
+	if a, err := strconv.ParseUint(r.Value("arch"), 16, 32); err == nil {
+		arch = uint32(a)
+	}
+	return c.matchStrings([]string{AuditSyscallName(arch, nr)})
+}
+
+func compareAuditQuery(op string, cmp int) bool {
+	switch op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
+
+func int64Compare(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// "yes", "no" or "" for events that carry no result.
+func auditEventSuccess(ev *AuditEvent) string {
+	for _, r := range ev.Records {
+		if v := r.Value("success"); v != "" {
+			return v
+		}
+		switch r.Value("res") {
+		case "success", "yes", "1":
+			return "yes"
+		case "failed", "no", "0":
+			return "no"
+		}
+	}
+	return ""
+}
+
+// Names of the PATH records, relative ones resolved against the CWD record.
+func auditEventPaths(ev *AuditEvent) []string {
+	cwd := ""
+	if r := ev.Record(AUDIT_CWD); r != nil {
+		cwd = r.Value("cwd")
+	}
+	var paths []string
+	for _, r := range ev.Records {
+		if r.Type != AUDIT_PATH {
+			continue
+		}
+		name, ok := r.Field("name")
+		if !ok || name.Null {
+			continue
+		}
+		p := name.Value
+		if !strings.HasPrefix(p, "/") && cwd != "" {
+			p = path.Join(cwd, p)
+		}
+		paths = append(paths, p)
+	}
+	return paths
+}
+
+// Runs q over a recorded log and calls fn for each matching event until fn
+// returns false. Parse errors of single lines are skipped.
+func AuditSearch(lr *AuditLogReader, q *AuditQuery, fn func(*AuditEvent) bool) error {
+	evchan := make(chan *AuditEvent)
+	errchan := make(chan error)
+	done := make(chan bool)
+	go AuditLogReplayEvents(lr, NewAuditEventAssembler(0, 0), evchan, errchan, done)
+
+	var err error
+	stop := false
+	for {
+		select {
+		case ev, ok := <-evchan:
+			if !ok {
+				return err
+			}
+			//Stop reading the log, then drain until the replay goroutine is gone
+			if !stop && q.Match(ev) && !fn(ev) {
+				stop = true
+				close(done)
+			}
+		case e := <-errchan:
+			if _, ok := e.(*AuditLogParseError); !ok && !stop {
+				err = e
+			}
+		}
+	}
+}
+
+// Live filter: forwards the events of in that match q to out and closes
+// out once in is closed.
+func AuditFilterStream(q *AuditQuery, in <-chan *AuditEvent, out chan<- *AuditEvent) {
+	defer close(out)
+	for ev := range in {
+		if q.Match(ev) {
+			out <- ev
+		}
+	}
+}
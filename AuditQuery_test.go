@@ -0,0 +1,99 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTokenizeAuditQuery(t *testing.T) {
+	for expr, want := range map[string][]string{
+		"key=x":                 {"key", "=", "x"},
+		"key=x\r":               {"key", "=", "x"},
+		"key=x\v\fand\r\nuid=0": {"key", "=", "x", "and", "uid", "=", "0"},
+		"exe=/tmp/à":            {"exe", "=", "/tmp/à"},
+		"comm=\u0085x":          {"comm", "=", "\u0085x"},
+		"a!=1 b<=2 c>3 d~e":     {"a", "!=", "1", "b", "<=", "2", "c", ">", "3", "d", "~", "e"},
+		`(key="a b")`:           {"(", "key", "=", "a b", ")"},
+		"not(x='y')":            {"not", "(", "x", "=", "y", ")"},
+		" \t":                   nil,
+	} {
+		toks, err := tokenizeAuditQuery(expr)
+		if err != nil {
+			t.Errorf("%q: %v", expr, err)
+			continue
+		}
+		var got []string
+		for _, tok := range toks {
+			got = append(got, tok.text)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%q: got %q, want %q", expr, got, want)
+		}
+	}
+	for _, expr := range []string{`key="x`, "a!b", "a ! b"} {
+		if _, err := tokenizeAuditQuery(expr); err == nil {
+			t.Errorf("%q: no error", expr)
+		}
+	}
+}
+
+func TestParseAuditQueryErrors(t *testing.T) {
+	for _, expr := range []string{
+		"key",
+		"key=",
+		"(key=x",
+		"key=x)",
+		"key=x and",
+		"not",
+		"key x y",
+		`"key"=x`,
+		"type=NOPE",
+		"success=maybe",
+		"pid<abc",
+		"time>whenever",
+	} {
+		if _, err := ParseAuditQuery(expr); err == nil {
+			t.Errorf("%q: no error", expr)
+		}
+	}
+}
+
+func TestAuditQueryMatch(t *testing.T) {
+	ev := &AuditEvent{Serial: 42, Timestamp: time.Unix(1700000000, 0), Node: "web1", Records: []*AuditRecord{
+		parseAuditTestRecord(t, AUDIT_SYSCALL, `audit(1700000000.000:42): arch=c000003e syscall=59 success=no exit=-13 pid=1001 auid=1000 comm="cat" key="secrets"`),
+		parseAuditTestRecord(t, AUDIT_CWD, `audit(1700000000.000:42): cwd="/etc"`),
+		parseAuditTestRecord(t, AUDIT_PATH, `audit(1700000000.000:42): item=0 name="shadow"`),
+	}}
+	for expr, want := range map[string]bool{
+		"":                          true,
+		"key=secrets":               true,
+		"key=other":                 false,
+		"key!=other":                true,
+		"success=no and pid>1000":   true,
+		"success=yes or comm~ca":    true,
+		"not (comm=cat)":            false,
+		"type=SYSCALL and type=CWD": true,
+		"type=EXECVE":               false,
+		"syscall=execve":            true,
+		"syscall=59":                true,
+		"path=/etc/shadow":          true,
+		"host=web1 and serial>=42":  true,
+		"serial<42":                 false,
+		"auid=1000":                 true,
+		"time>=2023-11-14T22:00:00Z and time<now": true,
+		"time>2023-11-14T23:00:00+01:00":          true,
+		"time>2023-11-14T23:00:00Z":               false,
+		"exit=-13 && (pid=1 || pid=1001)":         true,
+	} {
+		q, err := ParseAuditQuery(expr)
+		if err != nil {
+			t.Errorf("%q: %v", expr, err)
+			continue
+		}
+		q.Interp = nil
+		if got := q.Match(ev); got != want {
+			t.Errorf("%q: got %v, want %v", expr, got, want)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	AUDIT_RECEIVER_BATCH_BYTES = 256 << 10 /* one pooled buffer per batch */
+	AUDIT_RECEIVER_BATCH_MAX   = 128       /* datagrams per batch */
+	AUDIT_RECEIVER_DATAGRAM    = 16 << 10  /* room kept free for the next read */
+	AUDIT_RECEIVER_POLL        = 250 * time.Millisecond
+)
+
+// Reads one datagram into b. flags is 0 or syscall.MSG_DONTWAIT.
+type auditRecvFunc func(b []byte, flags int) (int, error)
+
+// A batch of datagrams packed into one pooled buffer.
+type auditRecvBatch struct {
+	seq     uint64
+	buf     *[]byte
+	spans   [][2]int /* datagram start and end offsets in buf */
+	records []*AuditRecord
+	errs    []error
+}
+
+type AuditReceiverStats struct {
+	Datagrams uint64
+	Records   uint64
+	Batches   uint64
+	Overruns  uint64 /* ENOBUFS */
+	Errors    uint64 /* parse and other receive errors */
+}
+
+// Receive path for high record rates. One goroutine reads as many queued
+// datagrams as fit into a pooled buffer per wakeup, Workers goroutines
+// split them into messages without copying and parse the records, and the
+// batches are put back into read order before they go out. The buffer
+// returns to the pool once its records are parsed, records hold their own
+// copies of the data.
+type AuditReceiver struct {
+	Workers int
+
+	recv  auditRecvFunc
+	pool  sync.Pool
+	stats AuditReceiverStats /* atomic */
+}
+
+func newAuditReceiver(recv auditRecvFunc, workers int) *AuditReceiver {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	r := &AuditReceiver{Workers: workers, recv: recv}
+	r.pool.New = func() interface{} {
+		b := make([]byte, AUDIT_RECEIVER_BATCH_BYTES)
+		return &b
+	}
+	return r
+}
+
+// Receiver on s. A receive timeout is set on the transport, if it takes
+// one, so Run notices done while the kernel is quiet.
+func NewAuditReceiver(s NetlinkTransport, workers int) (*AuditReceiver, error) {
+	if t, ok := s.(netlinkReceiveTimeout); ok {
+		if err := t.SetReceiveTimeout(AUDIT_RECEIVER_POLL); err != nil {
+			return nil, err
+		}
+	}
+	return newAuditReceiver(s.Recvfrom, workers), nil
+}
+
+func (r *AuditReceiver) Stats() AuditReceiverStats {
+	return AuditReceiverStats{
+		Datagrams: atomic.LoadUint64(&r.stats.Datagrams),
+		Records:   atomic.LoadUint64(&r.stats.Records),
+		Batches:   atomic.LoadUint64(&r.stats.Batches),
+		Overruns:  atomic.LoadUint64(&r.stats.Overruns),
+		Errors:    atomic.LoadUint64(&r.stats.Errors),
+	}
+}
+
+func isAuditRecvRetry(err error) bool {
+	return err == syscall.EAGAIN || err == syscall.EWOULDBLOCK || err == syscall.EINTR
+}
+
+// Fills one batch: a blocking read (up to the socket timeout), then
+// whatever else is already queued. The batch ends at the first error.
+// Returns nil when nothing was read, and false once the socket is closed
+// or done fired.
+func (r *AuditReceiver) read(errchan chan<- error, done <-chan bool) (*auditRecvBatch, bool) {
+	buf := r.pool.Get().(*[]byte)
+	b := &auditRecvBatch{buf: buf}
+	off, flags := 0, 0
+	alive := true
+	for len(b.spans) < AUDIT_RECEIVER_BATCH_MAX && len(*buf)-off >= AUDIT_RECEIVER_DATAGRAM {
+		n, err := r.recv((*buf)[off:], flags)
+		flags = syscall.MSG_DONTWAIT
+		if err == nil && n < syscall.NLMSG_HDRLEN {
+			err = syscall.EINVAL
+		}
+		if err != nil {
+			if isAuditRecvRetry(err) {
+				break
+			}
+			if err == syscall.ENOBUFS {
+				atomic.AddUint64(&r.stats.Overruns, 1)
+			} else {
+				atomic.AddUint64(&r.stats.Errors, 1)
+			}
+			alive = err != syscall.EBADF
+			select {
+			case errchan <- err:
+			case <-done:
+				alive = false
+			}
+			break
+		}
+		b.spans = append(b.spans, [2]int{off, off + n})
+		off += n
+	}
+	if len(b.spans) == 0 {
+		r.pool.Put(buf)
+		return nil, alive
+	}
+	atomic.AddUint64(&r.stats.Datagrams, uint64(len(b.spans)))
+	atomic.AddUint64(&r.stats.Batches, 1)
+	return b, alive
+}
+
+// Parses the event records of a batch and releases its buffer.
+func (r *AuditReceiver) parse(b *auditRecvBatch) {
+	for _, span := range b.spans {
+		msgs, err := ParseAuditNetlinkMessage((*b.buf)[span[0]:span[1]])
+		if err != nil {
+			b.errs = append(b.errs, err)
+			continue
+		}
+		for _, m := range msgs {
+			if !isAuditEventRecord(m.Header.Type) {
+				continue
+			}
+			rec, err := ParseAuditRecord(m)
+			if err != nil {
+				b.errs = append(b.errs, err)
+				continue
+			}
+			b.records = append(b.records, rec)
+		}
+	}
+	r.pool.Put(b.buf)
+	b.buf, b.spans = nil, nil
+	atomic.AddUint64(&r.stats.Records, uint64(len(b.records)))
+	atomic.AddUint64(&r.stats.Errors, uint64(len(b.errs)))
+}
+
+// Reads until done receives a value or is closed, or the socket is closed
+// (EBADF), and sends the event records of every batch to out in the order
+// they were received. Errors go to errchan, ENOBUFS included. out is
+// closed on return.
+func (r *AuditReceiver) Run(out chan<- []*AuditRecord, errchan chan<- error, done <-chan bool) {
+	work := make(chan *auditRecvBatch, r.Workers)
+	parsed := make(chan *auditRecvBatch, r.Workers)
+
+	var wg sync.WaitGroup
+	for i := 0; i < r.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for b := range work {
+				r.parse(b)
+				parsed <- b
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(parsed)
+	}()
+
+	go func() {
+		defer close(work)
+		var seq uint64
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			b, alive := r.read(errchan, done)
+			if b != nil {
+				b.seq = seq
+				seq++
+				work <- b
+			}
+			if !alive {
+				return
+			}
+		}
+	}()
+
+	//Workers finish out of order, hold batches back until their turn
+	defer close(out)
+	pending := make(map[uint64]*auditRecvBatch)
+	var next uint64
+	for b := range parsed {
+		pending[b.seq] = b
+		for {
+			b, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next++
+			for _, err := range b.errs {
+				errchan <- err
+			}
+			if len(b.records) > 0 {
+				out <- b.records
+			}
+		}
+	}
+}
+
+// Assembles the record batches of in into events, see AuditEventStream.
+// evchan is closed once in is closed.
+func AuditRecordBatchStream(a *AuditEventAssembler, in <-chan []*AuditRecord, evchan chan<- *AuditEvent) {
+	ticker := time.NewTicker(a.expireInterval())
+	defer ticker.Stop()
+	defer close(evchan)
+	for {
+		select {
+		case recs, ok := <-in:
+			if !ok {
+				for _, ev := range a.Flush() {
+					evchan <- ev
+				}
+				return
+			}
+			for _, rec := range recs {
+				for _, ev := range a.Push(rec) {
+					evchan <- ev
+				}
+			}
+		case <-ticker.C:
+			for _, ev := range a.Expire() {
+				evchan <- ev
+			}
+		}
+	}
+}
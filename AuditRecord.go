@@ -0,0 +1,345 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	AUDIT_NULL_VALUE         = "(null)"
+	AUDIT_ENRICHED_SEPARATOR = '\x1d' /* Separates raw fields from the enriched ones (log_format = ENRICHED) */
+	AUDIT_KEY_SEPARATOR      = '\x01' /* Separates multiple keys in a single key= field */
+)
+
+var (
+	ErrAuditNoHeader  = errors.New("audit record: missing audit(timestamp:serial) header")
+	ErrAuditBadHeader = errors.New("audit record: malformed audit(timestamp:serial) header")
+)
+
+// Fields whose unquoted values are hex encoded by the kernel (audit_log_untrustedstring)
+// when they contain spaces, quotes or control characters.
+var auditEncodedFields = map[string]bool{
+	"acct":      true,
+	"cmd":       true,
+	"comm":      true,
+	"cwd":       true,
+	"dir":       true,
+	"exe":       true,
+	"file":      true,
+	"key":       true,
+	"name":      true,
+	"ocomm":     true,
+	"path":      true,
+	"proctitle": true,
+	"vm":        true,
+	"watch":     true,
+}
+
+// Fields that are only hex encoded in records of some types. Elsewhere the
+// same names carry plain numbers, e.g. old= in CONFIG_CHANGE.
+var auditEncodedTypeFields = map[string]map[uint16]bool{
+	"data": {AUDIT_TTY: true, AUDIT_USER_TTY: true},
+}
+
+// A single key=value pair of an audit record. Value is always the decoded
+// form, Raw is exactly what the kernel sent.
+type AuditField struct {
+	Name     string
+	Value    string
+	Raw      string
+	Quoted   bool   /* value was enclosed in double quotes */
+	Encoded  bool   /* value was hex encoded */
+	Null     bool   /* value was (null) */
+	Enriched bool   /* field appeared after AUDIT_ENRICHED_SEPARATOR */
+	Interp   string /* human readable value, set by AuditInterpreter */
+}
+
+// One netlink audit message, e.g.
+//
+//	audit(1364481363.243:24287): arch=c000003e syscall=2 success=no ...
+type AuditRecord struct {
+	Type      uint16
+	TypeName  string
+	Timestamp time.Time
+	Serial    uint64
+	Node      string
+	Fields    []AuditField
+	Data      string /* everything after "audit(...): " */
+}
+
+//go:generate go run AuditMsgTypesGen.go
+
+var auditMsgTypeValues = make(map[string]uint16, len(auditMsgTypeNames))
+
+func init() {
+	for t, name := range auditMsgTypeNames {
+		auditMsgTypeValues[name] = t
+	}
+}
+
+// Returns the ausearch style name of a message type, e.g. SYSCALL for 1300.
+// Netlink control messages get their NLMSG_ name, unknown types UNKNOWN[n].
+func AuditMsgTypeName(t uint16) string {
+	switch t {
+	case syscall.NLMSG_NOOP:
+		return "NLMSG_NOOP"
+	case syscall.NLMSG_ERROR:
+		return "NLMSG_ERROR"
+	case syscall.NLMSG_DONE:
+		return "NLMSG_DONE"
+	case syscall.NLMSG_OVERRUN:
+		return "NLMSG_OVERRUN"
+	}
+	if name, ok := auditMsgTypeNames[t]; ok {
+		return name
+	}
+	return "UNKNOWN[" + strconv.Itoa(int(t)) + "]"
+}
+
+// Reverse of AuditMsgTypeName. Accepts names with or without the AUDIT_
+// prefix, UNKNOWN[n] and plain numbers.
+func AuditMsgTypeByName(name string) (uint16, bool) {
+	name = strings.TrimPrefix(strings.ToUpper(name), "AUDIT_")
+	if t, ok := auditMsgTypeValues[name]; ok {
+		return t, true
+	}
+	if strings.HasPrefix(name, "UNKNOWN[") && strings.HasSuffix(name, "]") {
+		name = name[len("UNKNOWN[") : len(name)-1]
+	}
+	t, err := strconv.ParseUint(name, 10, 16)
+	if err != nil {
+		return 0, false
+	}
+	return uint16(t), true
+}
+
+// Returns the first raw (non enriched) field with the given name.
+func (r *AuditRecord) Field(name string) (AuditField, bool) {
+	for _, f := range r.Fields {
+		if f.Name == name && !f.Enriched {
+			return f, true
+		}
+	}
+	return AuditField{}, false
+}
+
+// Returns the decoded value of a field or "" if it is not present.
+func (r *AuditRecord) Value(name string) string {
+	f, _ := r.Field(name)
+	return f.Value
+}
+
+// Returns the interpreted value of a field, falling back to the decoded one.
+func (r *AuditRecord) Interpreted(name string) string {
+	f, _ := r.Field(name)
+	if f.Interp != "" {
+		return f.Interp
+	}
+	return f.Value
+}
+
+// Returns the enriched value the kernel/auditd appended for a field (UID, AUID, ARCH, SYSCALL...).
+func (r *AuditRecord) EnrichedValue(name string) (string, bool) {
+	for _, f := range r.Fields {
+		if f.Enriched && f.Name == name {
+			return f.Value, true
+		}
+	}
+	return "", false
+}
+
+// Keys are hex encoded and joined with AUDIT_KEY_SEPARATOR when a rule carries several of them.
+func (r *AuditRecord) Keys() []string {
+	f, ok := r.Field("key")
+	if !ok || f.Null {
+		return nil
+	}
+	return strings.Split(f.Value, string(AUDIT_KEY_SEPARATOR))
+}
+
+func ParseAuditRecord(m syscall.NetlinkMessage) (*AuditRecord, error) {
+	return ParseAuditRecordData(m.Header.Type, m.Data)
+}
+
+func ParseAuditRecordData(typ uint16, data []byte) (*AuditRecord, error) {
+	s := strings.TrimRight(string(data), "\x00\n")
+
+	node := ""
+	if strings.HasPrefix(s, "node=") {
+		end := strings.IndexByte(s, ' ')
+		if end < 0 {
+			return nil, ErrAuditNoHeader
+		}
+		node = s[len("node="):end]
+	}
+	ts, serial, rest, err := parseAuditHeader(s)
+	if err != nil {
+		return nil, err
+	}
+	r := &AuditRecord{
+		Type:      typ,
+		TypeName:  AuditMsgTypeName(typ),
+		Timestamp: ts,
+		Serial:    serial,
+		Node:      node,
+		Data:      rest,
+	}
+	r.Fields = parseAuditFields(rest, typ, false)
+	return r, nil
+}
+
+// Splits "audit(1364481363.243:24287): rest" into its parts.
+func parseAuditHeader(s string) (time.Time, uint64, string, error) {
+	start := strings.Index(s, "audit(")
+	if start < 0 {
+		return time.Time{}, 0, "", ErrAuditNoHeader
+	}
+	s = s[start+len("audit("):]
+	end := strings.IndexByte(s, ')')
+	if end < 0 {
+		return time.Time{}, 0, "", ErrAuditBadHeader
+	}
+	stamp := s[:end]
+	rest := strings.TrimPrefix(s[end+1:], ":")
+	rest = strings.TrimLeft(rest, " ")
+
+	colon := strings.IndexByte(stamp, ':')
+	if colon < 0 {
+		return time.Time{}, 0, "", ErrAuditBadHeader
+	}
+	serial, err := strconv.ParseUint(stamp[colon+1:], 10, 64)
+	if err != nil {
+		return time.Time{}, 0, "", ErrAuditBadHeader
+	}
+	secs, msecs := stamp[:colon], "0"
+	if dot := strings.IndexByte(secs, '.'); dot >= 0 {
+		secs, msecs = secs[:dot], secs[dot+1:]
+	}
+	sec, err := strconv.ParseInt(secs, 10, 64)
+	if err != nil {
+		return time.Time{}, 0, "", ErrAuditBadHeader
+	}
+	msec, err := strconv.ParseInt(msecs, 10, 64)
+	if err != nil {
+		return time.Time{}, 0, "", ErrAuditBadHeader
+	}
+	return time.Unix(sec, msec*int64(time.Millisecond)), serial, rest, nil
+}
+
+func parseAuditFields(s string, typ uint16, enriched bool) []AuditField {
+	var fields []AuditField
+	i := 0
+	for i < len(s) {
+		c := s[i]
+		if c == ' ' || c == '\n' || c == ',' {
+			i++
+			continue
+		}
+		if c == AUDIT_ENRICHED_SEPARATOR {
+			enriched = true
+			i++
+			continue
+		}
+		//AVC permission sets: "avc:  denied  { read write } for ..."
+		if c == '{' {
+			end := strings.IndexByte(s[i:], '}')
+			if end < 0 {
+				end = len(s) - i
+			}
+			perms := strings.TrimSpace(s[i+1 : i+end])
+			fields = append(fields, AuditField{Name: "seperms", Value: perms, Raw: perms, Enriched: enriched})
+			i += end + 1
+			continue
+		}
+
+		j := i
+		for j < len(s) && s[j] != '=' && s[j] != ' ' && s[j] != AUDIT_ENRICHED_SEPARATOR {
+			j++
+		}
+		if j >= len(s) || s[j] != '=' {
+			//Bare word such as "avc:" or "denied"
+			word := s[i:j]
+			if word == "denied" || word == "granted" {
+				fields = append(fields, AuditField{Name: "seresult", Value: word, Raw: word, Enriched: enriched})
+			}
+			i = j
+			continue
+		}
+		name := s[i:j]
+		j++
+
+		var raw string
+		quote := byte(0)
+		if j < len(s) && (s[j] == '"' || s[j] == '\'') {
+			quote = s[j]
+			end := strings.IndexByte(s[j+1:], quote)
+			if end < 0 {
+				raw = s[j:]
+				j = len(s)
+			} else {
+				raw = s[j : j+end+2]
+				j += end + 2
+			}
+		} else {
+			k := j
+			for k < len(s) && s[k] != ' ' && s[k] != AUDIT_ENRICHED_SEPARATOR {
+				k++
+			}
+			raw = s[j:k]
+			j = k
+		}
+		i = j
+
+		//USER_* messages carry their payload in msg='...', flatten it into the record.
+		if quote == '\'' {
+			fields = append(fields, parseAuditFields(strings.Trim(raw, "'"), typ, enriched)...)
+			continue
+		}
+		fields = append(fields, newAuditField(name, raw, typ, enriched))
+	}
+	return fields
+}
+
+func newAuditField(name, raw string, typ uint16, enriched bool) AuditField {
+	f := AuditField{Name: name, Value: raw, Raw: raw, Enriched: enriched}
+	switch {
+	case raw == AUDIT_NULL_VALUE:
+		f.Null = true
+		f.Value = ""
+	case len(raw) >= 2 && raw[0] == '"' && raw[len(raw)-1] == '"':
+		f.Quoted = true
+		f.Value = raw[1 : len(raw)-1]
+	case !enriched && isAuditEncodedField(name, typ):
+		if v, ok := decodeAuditHex(raw); ok {
+			f.Encoded = true
+			f.Value = v
+		}
+	}
+	return f
+}
+
+func isAuditEncodedField(name string, typ uint16) bool {
+	if auditEncodedFields[name] || auditEncodedTypeFields[name][typ] {
+		return true
+	}
+	//EXECVE arguments: a0, a1, ... a3[0], a3[1]
+	if typ == AUDIT_EXECVE && len(name) > 1 && name[0] == 'a' && name[1] >= '0' && name[1] <= '9' && !strings.HasSuffix(name, "_len") {
+		return true
+	}
+	return false
+}
+
+func decodeAuditHex(s string) (string, bool) {
+	if len(s) == 0 || len(s)%2 != 0 {
+		return "", false
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return "", false
+	}
+	return string(b), true
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func parseAuditTestRecord(t *testing.T, typ uint16, data string) *AuditRecord {
+	t.Helper()
+	r, err := ParseAuditRecordData(typ, []byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestParseAuditRecordHeader(t *testing.T) {
+	r := parseAuditTestRecord(t, AUDIT_SYSCALL, "node=web1 audit(1364481363.243:24287): arch=c000003e syscall=2\x00")
+	if r.Node != "web1" || r.Serial != 24287 || r.TypeName != "SYSCALL" {
+		t.Fatalf("got node=%q serial=%d type=%q", r.Node, r.Serial, r.TypeName)
+	}
+	if want := time.Unix(1364481363, 243*int64(time.Millisecond)); !r.Timestamp.Equal(want) {
+		t.Fatalf("got %v, want %v", r.Timestamp, want)
+	}
+	if r.Data != "arch=c000003e syscall=2" {
+		t.Fatalf("got data %q", r.Data)
+	}
+
+	for _, bad := range []struct {
+		data string
+		err  error
+	}{
+		{"arch=c000003e", ErrAuditNoHeader},
+		{"audit(1364481363.243:24287: x=1", ErrAuditBadHeader},
+		{"audit(1364481363.243): x=1", ErrAuditBadHeader},
+		{"audit(136448136x.243:1): x=1", ErrAuditBadHeader},
+	} {
+		if _, err := ParseAuditRecordData(AUDIT_SYSCALL, []byte(bad.data)); err != bad.err {
+			t.Errorf("%q: got %v, want %v", bad.data, err, bad.err)
+		}
+	}
+}
+
+func TestParseAuditRecordFields(t *testing.T) {
+	r := parseAuditTestRecord(t, AUDIT_SYSCALL, `audit(1.000:1): arch=c000003e success=yes comm="ls" exe=2F746D702F612062 key=(null) tty=(none)`)
+	for _, want := range []AuditField{
+		{Name: "arch", Value: "c000003e", Raw: "c000003e"},
+		{Name: "success", Value: "yes", Raw: "yes"},
+		{Name: "comm", Value: "ls", Raw: `"ls"`, Quoted: true},
+		{Name: "exe", Value: "/tmp/a b", Raw: "2F746D702F612062", Encoded: true},
+		{Name: "key", Raw: "(null)", Null: true},
+		{Name: "tty", Value: "(none)", Raw: "(none)"},
+	} {
+		got, ok := r.Field(want.Name)
+		if !ok {
+			t.Errorf("%s missing", want.Name)
+			continue
+		}
+		if got != want {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	}
+	if keys := r.Keys(); keys != nil {
+		t.Errorf("null key: got %q", keys)
+	}
+}
+
+// old= and new= are numbers outside of hex encoded record types.
+func TestParseAuditRecordPlainHexLookalikes(t *testing.T) {
+	r := parseAuditTestRecord(t, AUDIT_CONFIG_CHANGE, "audit(1.000:1): op=set audit_backlog_limit=8192 old=64 auid=0 res=1")
+	if f, _ := r.Field("old"); f.Value != "64" || f.Encoded {
+		t.Fatalf("got %+v", f)
+	}
+	r = parseAuditTestRecord(t, AUDIT_TTY, "audit(1.000:1): tty=pts0 data=6C730D")
+	if f, _ := r.Field("data"); f.Value != "ls\r" || !f.Encoded {
+		t.Fatalf("got %+v", f)
+	}
+}
+
+func TestParseAuditRecordUserMessage(t *testing.T) {
+	r := parseAuditTestRecord(t, AUDIT_USER_LOGIN, `audit(1.000:1): pid=10 uid=0 auid=1000 ses=3 msg='op=login acct="root" exe="/usr/sbin/sshd" addr=10.0.0.1 res=success'`)
+	for name, want := range map[string]string{"pid": "10", "op": "login", "acct": "root", "exe": "/usr/sbin/sshd", "res": "success"} {
+		if got := r.Value(name); got != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestParseAuditRecordEnriched(t *testing.T) {
+	r := parseAuditTestRecord(t, AUDIT_SYSCALL, "audit(1.000:1): arch=c000003e uid=0\x1dARCH=x86_64 UID=\"root\"")
+	if r.Value("uid") != "0" {
+		t.Fatalf("got uid %q", r.Value("uid"))
+	}
+	if v, ok := r.EnrichedValue("UID"); !ok || v != "root" {
+		t.Fatalf("got UID %q %v", v, ok)
+	}
+	if _, ok := r.Field("ARCH"); ok {
+		t.Fatal("enriched field returned as raw")
+	}
+}
+
+func TestParseAuditRecordKeys(t *testing.T) {
+	//"a\x01b" hex encoded: a rule with two keys
+	r := parseAuditTestRecord(t, AUDIT_SYSCALL, "audit(1.000:1): key=610162")
+	keys := r.Keys()
+	if len(keys) != 2 || keys[0] != "a" || keys[1] != "b" {
+		t.Fatalf("got %q", keys)
+	}
+}
+
+func TestParseAuditRecordAVC(t *testing.T) {
+	r := parseAuditTestRecord(t, AUDIT_AVC, `audit(1.000:1): avc:  denied  { read write } for  pid=1 comm="cat" tclass=file permissive=0`)
+	if r.Value("seresult") != "denied" || r.Value("seperms") != "read write" || r.Value("tclass") != "file" {
+		t.Fatalf("got %+v", r.Fields)
+	}
+}
+
+func TestAuditMsgTypeNames(t *testing.T) {
+	for name, want := range map[string]uint16{"SYSCALL": AUDIT_SYSCALL, "AUDIT_EOE": AUDIT_EOE, "UNKNOWN[9999]": 9999, "1300": AUDIT_SYSCALL} {
+		if got, ok := AuditMsgTypeByName(name); !ok || got != want {
+			t.Errorf("%s: got %d %v, want %d", name, got, ok, want)
+		}
+	}
+	if name := AuditMsgTypeName(9999); name != "UNKNOWN[9999]" {
+		t.Errorf("got %q", name)
+	}
+	if _, ok := AuditMsgTypeByName("NOPE"); ok {
+		t.Error("NOPE resolved")
+	}
+}
@@ -0,0 +1,604 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// audisp-remote wire protocol (lib/private.h of audit-userspace): every
+// message starts with a 16 byte header, big endian: magic (4), version (1),
+// type (1), payload length (4), sequence number (4) and 2 bytes of padding.
+// The client sends MESSAGE and HEARTBEAT, the server answers each with an
+// ACK carrying the same sequence number, or with DISKLOW (stored, but
+// running out of space), DISKFULL or DISKERROR when it could not store it.
+const (
+	AUDIT_RMW_HEADER_SIZE = 16
+	AUDIT_RMW_MAGIC       = 0xff0000fe
+	AUDIT_RMW_VERSION     = 0
+	AUDIT_RMW_MAX_PAYLOAD = 2 * MAX_AUDIT_MESSAGE_LENGTH
+
+	AUDIT_RMW_TYPE_MESSAGE   = 0x00
+	AUDIT_RMW_TYPE_HEARTBEAT = 0x01
+	AUDIT_RMW_TYPE_ACK       = 0x40
+	AUDIT_RMW_TYPE_ENDING    = 0x41 /* server shutting down */
+	AUDIT_RMW_TYPE_DISKLOW   = 0x50
+	AUDIT_RMW_TYPE_DISKFULL  = 0x60
+	AUDIT_RMW_TYPE_DISKERROR = 0x61
+
+	AUDIT_RMW_TYPE_REPLYMASK = 0x40 /* sent by the server */
+	AUDIT_RMW_TYPE_WARNMASK  = 0x10 /* message stored anyway */
+	AUDIT_RMW_TYPE_FATALMASK = 0x20 /* message not stored */
+
+	AUDIT_REMOTE_PORT              = 60
+	AUDIT_REMOTE_DEFAULT_HEARTBEAT = 30 * time.Second
+	AUDIT_REMOTE_DEFAULT_TIMEOUT   = 10 * time.Second
+	AUDIT_REMOTE_DEFAULT_QUEUE     = 2000
+	AUDIT_REMOTE_MIN_BACKOFF       = time.Second
+	AUDIT_REMOTE_MAX_BACKOFF       = time.Minute
+)
+
+var (
+	ErrAuditRemoteBadMagic = errors.New("audit remote: bad header magic")
+	ErrAuditRemoteTooLong  = errors.New("audit remote: message too long")
+	ErrAuditRemoteEnding   = errors.New("audit remote: server is shutting down")
+	ErrAuditRemoteDisk     = errors.New("audit remote: server can not store messages")
+	ErrAuditRemoteClosed   = errors.New("audit remote: client closed")
+)
+
+type AuditRemoteHeader struct {
+	Version uint8
+	Type    uint8
+	Len     uint32
+	Seq     uint32
+}
+
+func (h *AuditRemoteHeader) Pack() []byte {
+	b := make([]byte, AUDIT_RMW_HEADER_SIZE)
+	binary.BigEndian.PutUint32(b[0:4], AUDIT_RMW_MAGIC)
+	b[4] = h.Version
+	b[5] = h.Type
+	binary.BigEndian.PutUint32(b[6:10], h.Len)
+	binary.BigEndian.PutUint32(b[10:14], h.Seq)
+	return b
+}
+
+func ParseAuditRemoteHeader(b []byte) (*AuditRemoteHeader, error) {
+	if len(b) < AUDIT_RMW_HEADER_SIZE {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if binary.BigEndian.Uint32(b[0:4]) != AUDIT_RMW_MAGIC {
+		return nil, ErrAuditRemoteBadMagic
+	}
+	return &AuditRemoteHeader{
+		Version: b[4],
+		Type:    b[5],
+		Len:     binary.BigEndian.Uint32(b[6:10]),
+		Seq:     binary.BigEndian.Uint32(b[10:14]),
+	}, nil
+}
+
+func ReadAuditRemoteMessage(r io.Reader) (*AuditRemoteHeader, []byte, error) {
+	hb := make([]byte, AUDIT_RMW_HEADER_SIZE)
+	if _, err := io.ReadFull(r, hb); err != nil {
+		return nil, nil, err
+	}
+	h, err := ParseAuditRemoteHeader(hb)
+	if err != nil {
+		return nil, nil, err
+	}
+	if h.Len > AUDIT_RMW_MAX_PAYLOAD {
+		return nil, nil, ErrAuditRemoteTooLong
+	}
+	payload := make([]byte, h.Len)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, nil, err
+	}
+	return h, payload, nil
+}
+
+func WriteAuditRemoteMessage(w io.Writer, typ uint8, seq uint32, payload []byte) error {
+	if len(payload) > AUDIT_RMW_MAX_PAYLOAD {
+		return ErrAuditRemoteTooLong
+	}
+	h := AuditRemoteHeader{Version: AUDIT_RMW_VERSION, Type: typ, Len: uint32(len(payload)), Seq: seq}
+	_, err := w.Write(append(h.Pack(), payload...))
+	return err
+}
+
+// Settings of the sending side, modelled on audisp-remote.conf.
+type AuditRemoteConfig struct {
+	Address    string                      /* host:port of the collector */
+	Node       string                      /* node= added to lines without one */
+	Heartbeat  time.Duration               /* heartbeat_timeout, sent when idle that long */
+	Timeout    time.Duration               /* connect and ack timeout */
+	QueueDepth int                         /* in memory queue */
+	QueueFile  string                      /* disk queue used while the collector is unreachable, "" for none */
+	OnDisk     func(typ uint8, msg string) /* DISKLOW/DISKFULL/DISKERROR from the server */
+}
+
+// Forwards log lines to an audisp-remote compatible collector. Each line
+// is sent as one MESSAGE and held until the server acked it. Lines that
+// can not be delivered are appended to the disk queue and sent first once
+// the connection is back, so delivery is at least once. Without a disk
+// queue they wait in memory, up to QueueDepth.
+type AuditRemoteClient struct {
+	cfg   AuditRemoteConfig
+	queue chan string
+	done  chan bool
+	wg    sync.WaitGroup
+
+	mu       sync.Mutex
+	seq      uint32
+	sent     uint64
+	spooled  uint64
+	dropped  uint64
+	spoolOff int64 /* bytes of QueueFile already delivered */
+	pending  bool  /* QueueFile has lines past spoolOff */
+	closed   bool
+
+	held []string /* failed lines without a QueueFile, only used by run */
+}
+
+func NewAuditRemoteClient(cfg AuditRemoteConfig) *AuditRemoteClient {
+	if cfg.Heartbeat <= 0 {
+		cfg.Heartbeat = AUDIT_REMOTE_DEFAULT_HEARTBEAT
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = AUDIT_REMOTE_DEFAULT_TIMEOUT
+	}
+	if cfg.QueueDepth <= 0 {
+		cfg.QueueDepth = AUDIT_REMOTE_DEFAULT_QUEUE
+	}
+	c := &AuditRemoteClient{
+		cfg:   cfg,
+		queue: make(chan string, cfg.QueueDepth),
+		done:  make(chan bool),
+	}
+	c.wg.Add(1)
+	go c.run()
+	return c
+}
+
+// Queues one log line. When the memory queue is full the line goes to the
+// disk queue, or is dropped if there is none.
+func (c *AuditRemoteClient) Send(line string) error {
+	if c.cfg.Node != "" && !strings.HasPrefix(line, "node=") {
+		line = "node=" + c.cfg.Node + " " + line
+	}
+	line = strings.TrimRight(line, "\n")
+	c.mu.Lock()
+	closed := c.closed
+	c.mu.Unlock()
+	if closed {
+		return ErrAuditRemoteClosed
+	}
+	select {
+	case c.queue <- line:
+		return nil
+	default:
+	}
+	return c.spool(line)
+}
+
+func (c *AuditRemoteClient) SendMessage(m syscall.NetlinkMessage) error {
+	if m.Header.Type == AUDIT_EOE || !isAuditEventRecord(m.Header.Type) {
+		return nil
+	}
+	return c.Send(FormatAuditLogMessage(m))
+}
+
+func (c *AuditRemoteClient) SendEvent(ev *AuditEvent) error {
+	for _, r := range ev.Records {
+		if r.Type == AUDIT_EOE {
+			continue
+		}
+		if err := c.Send(FormatAuditLogLine(r)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Appends a line to the disk queue.
+func (c *AuditRemoteClient) spool(line string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cfg.QueueFile == "" {
+		c.dropped++
+		return nil
+	}
+	f, err := os.OpenFile(c.cfg.QueueFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		c.dropped++
+		return err
+	}
+	defer f.Close()
+	if _, err := f.WriteString(line + "\n"); err != nil {
+		c.dropped++
+		return err
+	}
+	c.spooled++
+	c.pending = true
+	return nil
+}
+
+// Puts back a line that could not be delivered: into the disk queue or,
+// without one, in front of the memory queue for the next connection.
+func (c *AuditRemoteClient) requeue(line string) {
+	if c.cfg.QueueFile != "" {
+		c.spool(line)
+		return
+	}
+	c.held = append(c.held, line)
+}
+
+// Sends what is in the disk queue. The file is truncated once all of it
+// has been acked.
+func (c *AuditRemoteClient) drainSpool(conn net.Conn) error {
+	if c.cfg.QueueFile == "" {
+		return nil
+	}
+	f, err := os.Open(c.cfg.QueueFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	c.mu.Lock()
+	off := c.spoolOff
+	c.mu.Unlock()
+	if _, err := f.Seek(off, io.SeekStart); err != nil {
+		return err
+	}
+	br := bufio.NewReaderSize(f, AUDIT_RMW_MAX_PAYLOAD)
+	for {
+		line, err := br.ReadString('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := c.deliver(conn, AUDIT_RMW_TYPE_MESSAGE, strings.TrimRight(line, "\n")); err != nil {
+			return err
+		}
+		c.mu.Lock()
+		c.spoolOff += int64(len(line))
+		c.mu.Unlock()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	//Lines spooled by Send meanwhile are past spoolOff, only truncate if none came in
+	if st, err := os.Stat(c.cfg.QueueFile); err == nil && st.Size() == c.spoolOff {
+		os.Truncate(c.cfg.QueueFile, 0)
+		c.spoolOff = 0
+		c.pending = false
+	}
+	return nil
+}
+
+// Drains the disk queue if lines were spooled since the last drain, so
+// they go out before anything newer.
+func (c *AuditRemoteClient) drainPending(conn net.Conn) error {
+	c.mu.Lock()
+	pending := c.pending
+	c.mu.Unlock()
+	if !pending {
+		return nil
+	}
+	return c.drainSpool(conn)
+}
+
+// Sends one message and waits for its ack.
+func (c *AuditRemoteClient) deliver(conn net.Conn, typ uint8, line string) error {
+	c.mu.Lock()
+	c.seq++
+	seq := c.seq
+	c.mu.Unlock()
+
+	conn.SetDeadline(time.Now().Add(c.cfg.Timeout))
+	if err := WriteAuditRemoteMessage(conn, typ, seq, []byte(line)); err != nil {
+		return err
+	}
+	for {
+		h, payload, err := ReadAuditRemoteMessage(conn)
+		if err != nil {
+			return err
+		}
+		if h.Type&AUDIT_RMW_TYPE_REPLYMASK == 0 {
+			//Only the server's replies travel towards us
+			continue
+		}
+		if h.Type == AUDIT_RMW_TYPE_ENDING {
+			return ErrAuditRemoteEnding
+		}
+		if h.Type&(AUDIT_RMW_TYPE_WARNMASK|AUDIT_RMW_TYPE_FATALMASK) != 0 && c.cfg.OnDisk != nil {
+			c.cfg.OnDisk(h.Type, string(payload))
+		}
+		if h.Type&AUDIT_RMW_TYPE_FATALMASK != 0 {
+			return ErrAuditRemoteDisk
+		}
+		if h.Seq != seq {
+			continue
+		}
+		//ACK, or a warning that the message was stored all the same
+		if typ == AUDIT_RMW_TYPE_MESSAGE {
+			c.mu.Lock()
+			c.sent++
+			c.mu.Unlock()
+		}
+		return nil
+	}
+}
+
+func (c *AuditRemoteClient) run() {
+	defer c.wg.Done()
+	backoff := AUDIT_REMOTE_MIN_BACKOFF
+	for {
+		conn, err := net.DialTimeout("tcp", c.cfg.Address, c.cfg.Timeout)
+		if err == nil {
+			err = c.serve(conn)
+			conn.Close()
+			if err == ErrAuditRemoteClosed {
+				return
+			}
+			backoff = AUDIT_REMOTE_MIN_BACKOFF
+		}
+
+		//Unreachable: keep the memory queue moving into the disk queue if
+		//there is one, otherwise lines wait in memory until it is full
+		retry := time.After(backoff)
+		var queue <-chan string
+		if c.cfg.QueueFile != "" {
+			queue = c.queue
+		}
+	wait:
+		for {
+			select {
+			case line := <-queue:
+				c.spool(line)
+			case <-retry:
+				break wait
+			case <-c.done:
+				c.flushToSpool()
+				return
+			}
+		}
+		backoff *= 2
+		if backoff > AUDIT_REMOTE_MAX_BACKOFF {
+			backoff = AUDIT_REMOTE_MAX_BACKOFF
+		}
+	}
+}
+
+// Runs one connection until it fails or the client is closed.
+func (c *AuditRemoteClient) serve(conn net.Conn) error {
+	if err := c.drainSpool(conn); err != nil {
+		return err
+	}
+	for len(c.held) > 0 {
+		if err := c.deliver(conn, AUDIT_RMW_TYPE_MESSAGE, c.held[0]); err != nil {
+			return err
+		}
+		c.held = c.held[1:]
+	}
+	heartbeat := time.NewTicker(c.cfg.Heartbeat)
+	defer heartbeat.Stop()
+	last := time.Now()
+	for {
+		select {
+		case line := <-c.queue:
+			if err := c.drainPending(conn); err != nil {
+				c.requeue(line)
+				return err
+			}
+			if err := c.deliver(conn, AUDIT_RMW_TYPE_MESSAGE, line); err != nil {
+				c.requeue(line)
+				return err
+			}
+			last = time.Now()
+		case <-heartbeat.C:
+			if err := c.drainPending(conn); err != nil {
+				return err
+			}
+			if time.Since(last) < c.cfg.Heartbeat {
+				continue
+			}
+			if err := c.deliver(conn, AUDIT_RMW_TYPE_HEARTBEAT, ""); err != nil {
+				return err
+			}
+			last = time.Now()
+		case <-c.done:
+			//Deliver what is queued before leaving
+			for {
+				select {
+				case line := <-c.queue:
+					err := c.drainPending(conn)
+					if err == nil {
+						err = c.deliver(conn, AUDIT_RMW_TYPE_MESSAGE, line)
+					}
+					if err != nil {
+						c.requeue(line)
+						c.flushToSpool()
+						return ErrAuditRemoteClosed
+					}
+				default:
+					return ErrAuditRemoteClosed
+				}
+			}
+		}
+	}
+}
+
+// Spools, or without a disk queue drops, everything not yet delivered.
+func (c *AuditRemoteClient) flushToSpool() {
+	for _, line := range c.held {
+		c.spool(line)
+	}
+	c.held = nil
+	for {
+		select {
+		case line := <-c.queue:
+			c.spool(line)
+		default:
+			return
+		}
+	}
+}
+
+// Sent, spooled and dropped line counts.
+func (c *AuditRemoteClient) Stats() (sent, spooled, dropped uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sent, c.spooled, c.dropped
+}
+
+// Delivers or spools what is still queued and stops the client.
+func (c *AuditRemoteClient) Close() {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	c.closed = true
+	c.mu.Unlock()
+	close(c.done)
+	c.wg.Wait()
+}
+
+// Forwards every message of msgchan until it is closed, e.g. the output
+// of Getreply. Spooling errors go to errchan.
+func AuditRemoteStream(c *AuditRemoteClient, msgchan <-chan syscall.NetlinkMessage, errchan chan<- error) {
+	for m := range msgchan {
+		if err := c.SendMessage(m); err != nil {
+			errchan <- err
+		}
+	}
+}
+
+// Receiving side: accepts audisp-remote connections and writes every
+// message into an AuditLogWriter, answering with an ACK or, when the log
+// can not take it, DISKFULL/DISKERROR.
+type AuditRemoteServer struct {
+	Writer  *AuditLogWriter
+	MaxIdle time.Duration /* tcp_client_max_idle, 0 for no limit */
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]bool
+	wg       sync.WaitGroup
+	closed   bool
+}
+
+func NewAuditRemoteServer(w *AuditLogWriter) *AuditRemoteServer {
+	return &AuditRemoteServer{Writer: w, conns: make(map[net.Conn]bool)}
+}
+
+func (s *AuditRemoteServer) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Accepts connections on l until Close.
+func (s *AuditRemoteServer) Serve(l net.Listener) error {
+	s.mu.Lock()
+	s.listener = l
+	s.mu.Unlock()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+		s.mu.Lock()
+		s.conns[conn] = true
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go s.handle(conn)
+	}
+}
+
+func (s *AuditRemoteServer) handle(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+	host, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	for {
+		if s.MaxIdle > 0 {
+			conn.SetReadDeadline(time.Now().Add(s.MaxIdle))
+		}
+		h, payload, err := ReadAuditRemoteMessage(conn)
+		if err != nil {
+			return
+		}
+		switch h.Type {
+		case AUDIT_RMW_TYPE_MESSAGE:
+			line := string(payload)
+			//Keep the origin, the writer would label it with our own node
+			if !strings.HasPrefix(line, "node=") && host != "" {
+				line = "node=" + host + " " + line
+			}
+			typ, text := uint8(AUDIT_RMW_TYPE_ACK), ""
+			if err := s.Writer.WriteLine(line); err != nil {
+				typ, text = AUDIT_RMW_TYPE_DISKERROR, err.Error()
+				if errors.Is(err, syscall.ENOSPC) || err == ErrAuditLogSuspended || err == ErrAuditLogHalted {
+					typ = AUDIT_RMW_TYPE_DISKFULL
+				}
+			}
+			if err := WriteAuditRemoteMessage(conn, typ, h.Seq, []byte(text)); err != nil {
+				return
+			}
+		case AUDIT_RMW_TYPE_HEARTBEAT:
+			if err := WriteAuditRemoteMessage(conn, AUDIT_RMW_TYPE_ACK, h.Seq, nil); err != nil {
+				return
+			}
+		default:
+			//Replies only travel towards the client
+		}
+	}
+}
+
+// Tells the clients the server is going away, then closes everything.
+func (s *AuditRemoteServer) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for conn := range s.conns {
+		conn.SetWriteDeadline(time.Now().Add(time.Second))
+		WriteAuditRemoteMessage(conn, AUDIT_RMW_TYPE_ENDING, 0, nil)
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+func (h *AuditRemoteHeader) String() string {
+	return fmt.Sprintf("v%d type=%d len=%d seq=%d", h.Version, h.Type, h.Len, h.Seq)
+}
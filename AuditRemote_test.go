@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A collector on a loopback port writing into a temporary log.
+func newAuditTestRemoteServer(t *testing.T, addr string) (*AuditRemoteServer, string, string) {
+	cfg := DefaultAuditLogConfig()
+	cfg.Path = filepath.Join(t.TempDir(), "audit.log")
+	cfg.Flush = AUDIT_FLUSH_DATA
+	w, err := NewAuditLogWriter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewAuditRemoteServer(w)
+	go s.Serve(l)
+	t.Cleanup(func() {
+		s.Close()
+		w.Close()
+	})
+	return s, l.Addr().String(), cfg.Path
+}
+
+func waitAuditRemoteSent(t *testing.T, c *AuditRemoteClient, n uint64) {
+	t.Helper()
+	waitAuditTest(t, "delivery", func() bool {
+		sent, _, _ := c.Stats()
+		return sent >= n
+	})
+}
+
+func TestAuditRemoteHeader(t *testing.T) {
+	var b bytes.Buffer
+	if err := WriteAuditRemoteMessage(&b, AUDIT_RMW_TYPE_MESSAGE, 7, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if b.Len() != AUDIT_RMW_HEADER_SIZE+5 || !bytes.HasPrefix(b.Bytes(), []byte{0xff, 0, 0, 0xfe, AUDIT_RMW_VERSION, AUDIT_RMW_TYPE_MESSAGE}) {
+		t.Fatalf("got % x", b.Bytes())
+	}
+	h, payload, err := ReadAuditRemoteMessage(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Type != AUDIT_RMW_TYPE_MESSAGE || h.Seq != 7 || string(payload) != "hello" {
+		t.Fatalf("got %v %q", h, payload)
+	}
+	if _, _, err := ReadAuditRemoteMessage(bytes.NewReader(make([]byte, AUDIT_RMW_HEADER_SIZE))); err != ErrAuditRemoteBadMagic {
+		t.Fatalf("got %v, want ErrAuditRemoteBadMagic", err)
+	}
+}
+
+func TestAuditRemoteLoopback(t *testing.T) {
+	_, addr, path := newAuditTestRemoteServer(t, "127.0.0.1:0")
+	c := NewAuditRemoteClient(AuditRemoteConfig{Address: addr, Node: "web1"})
+	lines := []string{
+		"type=USER msg=audit(1.000:1): msg='a'",
+		"node=db1 type=USER msg=audit(1.000:2): msg='b'",
+	}
+	for _, line := range lines {
+		if err := c.Send(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitAuditRemoteSent(t, c, 2)
+	c.Close()
+	if err := c.Send(lines[0]); err != ErrAuditRemoteClosed {
+		t.Fatalf("send after close: got %v", err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "node=web1 " + lines[0] + "\n" + lines[1] + "\n"
+	if string(b) != want {
+		t.Fatalf("got %q, want %q", b, want)
+	}
+}
+
+// Without a disk queue, lines sent while the collector is unreachable wait
+// in memory and are delivered once it is back.
+func TestAuditRemoteReconnect(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	c := NewAuditRemoteClient(AuditRemoteConfig{Address: addr, Timeout: time.Second})
+	defer c.Close()
+	for i := 0; i < 3; i++ {
+		if err := c.Send("type=USER msg=audit(1.000:1): msg='x'"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	_, _, path := newAuditTestRemoteServer(t, addr)
+	waitAuditRemoteSent(t, c, 3)
+	if _, spooled, dropped := c.Stats(); spooled != 0 || dropped != 0 {
+		t.Fatalf("spooled %d, dropped %d", spooled, dropped)
+	}
+	b, _ := os.ReadFile(path)
+	if n := strings.Count(string(b), "\n"); n != 3 {
+		t.Fatalf("collector logged %d lines", n)
+	}
+}
+
+// With a disk queue, lines that can not be delivered are spooled and sent
+// first on the next connection.
+func TestAuditRemoteSpool(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	spool := filepath.Join(t.TempDir(), "spool")
+	c := NewAuditRemoteClient(AuditRemoteConfig{Address: addr, Timeout: time.Second, QueueFile: spool})
+	defer c.Close()
+	c.Send("type=USER msg=audit(1.000:1): msg='x'")
+	waitAuditTest(t, "spooling", func() bool {
+		_, spooled, _ := c.Stats()
+		return spooled == 1
+	})
+
+	newAuditTestRemoteServer(t, addr)
+	waitAuditRemoteSent(t, c, 1)
+	waitAuditTest(t, "spool truncation", func() bool {
+		st, err := os.Stat(spool)
+		return err == nil && st.Size() == 0
+	})
+}
+
+func TestAuditRemoteDiskFull(t *testing.T) {
+	s, addr, _ := newAuditTestRemoteServer(t, "127.0.0.1:0")
+	s.Writer.mu.Lock()
+	s.Writer.halted = true
+	s.Writer.mu.Unlock()
+
+	got := make(chan uint8, 1)
+	c := NewAuditRemoteClient(AuditRemoteConfig{Address: addr, OnDisk: func(typ uint8, msg string) {
+		select {
+		case got <- typ:
+		default:
+		}
+	}})
+	defer c.Close()
+	c.Send("type=USER msg=audit(1.000:1): msg='x'")
+	select {
+	case typ := <-got:
+		if typ != AUDIT_RMW_TYPE_DISKFULL {
+			t.Fatalf("got type %#x, want DISKFULL", typ)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no disk warning")
+	}
+}
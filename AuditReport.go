@@ -0,0 +1,419 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// One row of a "name -> count" summary.
+type AuditReportCount struct {
+	Name  string `json:"name"`
+	Count uint64 `json:"count"`
+}
+
+// A login or authentication attempt from a USER_LOGIN/USER_AUTH record.
+type AuditReportLogin struct {
+	Time     time.Time `json:"time"`
+	Serial   uint64    `json:"serial"`
+	User     string    `json:"user"`
+	Host     string    `json:"host,omitempty"`
+	Terminal string    `json:"terminal,omitempty"`
+	Exe      string    `json:"exe,omitempty"`
+	Success  bool      `json:"success"`
+}
+
+type AuditReportAnomaly struct {
+	Time   time.Time `json:"time"`
+	Serial uint64    `json:"serial"`
+	Type   string    `json:"type"`
+	Pid    string    `json:"pid,omitempty"`
+	User   string    `json:"user,omitempty"`
+	Exe    string    `json:"exe,omitempty"`
+}
+
+type AuditReportUser struct {
+	User     string `json:"user"`
+	Events   uint64 `json:"events"`
+	Failed   uint64 `json:"failed"`
+	Commands uint64 `json:"commands"`
+}
+
+// JSON form of a report, the counts sorted by count, largest first.
+type AuditReportSummary struct {
+	Start           time.Time            `json:"start"`
+	End             time.Time            `json:"end"`
+	Events          uint64               `json:"events"`
+	FailedEvents    uint64               `json:"failed_events"`
+	Logins          []AuditReportLogin   `json:"logins"`
+	FailedLogins    uint64               `json:"failed_logins"`
+	Authentications []AuditReportLogin   `json:"authentications"`
+	FailedAuths     uint64               `json:"failed_authentications"`
+	Executables     []AuditReportCount   `json:"executables"`
+	Files           []AuditReportCount   `json:"files"`
+	Anomalies       []AuditReportAnomaly `json:"anomalies"`
+	Syscalls        []AuditReportCount   `json:"syscalls"`
+	Keys            []AuditReportCount   `json:"keys"`
+	Users           []AuditReportUser    `json:"users"`
+}
+
+// Aggregates assembled events into aureport style summaries. Safe for
+// concurrent use, so a live stream can keep adding while a report is
+// written out.
+type AuditReport struct {
+	Interp *AuditInterpreter /* resolves user names, optional */
+
+	mu           sync.Mutex
+	start        time.Time
+	end          time.Time
+	events       uint64
+	failedEvents uint64
+	logins       []AuditReportLogin
+	auths        []AuditReportLogin
+	anomalies    []AuditReportAnomaly
+	executables  map[string]uint64
+	files        map[string]uint64
+	syscalls     map[string]uint64
+	keys         map[string]uint64
+	users        map[string]*AuditReportUser
+}
+
+func NewAuditReport(interp *AuditInterpreter) *AuditReport {
+	rep := &AuditReport{Interp: interp}
+	rep.Reset()
+	return rep
+}
+
+// Drops everything aggregated so far.
+func (rep *AuditReport) Reset() {
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+	rep.start, rep.end = time.Time{}, time.Time{}
+	rep.events, rep.failedEvents = 0, 0
+	rep.logins, rep.auths, rep.anomalies = nil, nil, nil
+	rep.executables = make(map[string]uint64)
+	rep.files = make(map[string]uint64)
+	rep.syscalls = make(map[string]uint64)
+	rep.keys = make(map[string]uint64)
+	rep.users = make(map[string]*AuditReportUser)
+}
+
+func isAuditAnomaly(t uint16) bool {
+	return (t >= AUDIT_FIRST_KERN_ANOM_MSG && t <= AUDIT_LAST_KERN_ANOM_MSG) ||
+		(t >= AUDIT_FIRST_ANOM_MSG && t <= AUDIT_LAST_ANOM_MSG)
+}
+
+// Login user name: acct= of the USER_* message, auid otherwise.
+func (rep *AuditReport) userName(ev *AuditEvent) string {
+	if acct := ev.Value("acct"); acct != "" {
+		return acct
+	}
+	id := ev.Value("auid")
+	if id == "" || id == strconv.FormatUint(AUDIT_UNSET_ID, 10) {
+		id = ev.Value("uid")
+	}
+	if id == "" {
+		return "?"
+	}
+	if rep.Interp != nil {
+		if name := rep.Interp.UserName(id); name != "" {
+			return name
+		}
+	}
+	return id
+}
+
+func (rep *AuditReport) Add(ev *AuditEvent) {
+	user := rep.userName(ev)
+	success := auditEventSuccess(ev)
+	var login *AuditReportLogin
+	if r := ev.Record(AUDIT_USER_LOGIN); r != nil {
+		login = newAuditReportLogin(ev, r, user)
+	}
+	var auth *AuditReportLogin
+	if r := ev.Record(AUDIT_USER_AUTH); r != nil {
+		auth = newAuditReportLogin(ev, r, user)
+	}
+	paths := auditEventPaths(ev)
+
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+	if rep.start.IsZero() || ev.Timestamp.Before(rep.start) {
+		rep.start = ev.Timestamp
+	}
+	if ev.Timestamp.After(rep.end) {
+		rep.end = ev.Timestamp
+	}
+	rep.events++
+	u, ok := rep.users[user]
+	if !ok {
+		u = &AuditReportUser{User: user}
+		rep.users[user] = u
+	}
+	u.Events++
+	if success == "no" {
+		rep.failedEvents++
+		u.Failed++
+	}
+
+	if login != nil {
+		rep.logins = append(rep.logins, *login)
+	}
+	if auth != nil {
+		rep.auths = append(rep.auths, *auth)
+	}
+	for _, r := range ev.Records {
+		if !isAuditAnomaly(r.Type) {
+			continue
+		}
+		rep.anomalies = append(rep.anomalies, AuditReportAnomaly{
+			Time:   ev.Timestamp,
+			Serial: ev.Serial,
+			Type:   r.TypeName,
+			Pid:    r.Value("pid"),
+			User:   user,
+			Exe:    r.Value("exe"),
+		})
+	}
+
+	if sc := ev.Record(AUDIT_SYSCALL); sc != nil {
+		arch := uint32(0)
+		if a, err := strconv.ParseUint(sc.Value("arch"), 16, 32); err == nil {
+			arch = uint32(a)
+		}
+		if v := sc.Value("syscall"); v != "" {
+			rep.syscalls[AuditSyscallName(arch, v)]++
+		}
+		if exe := sc.Value("exe"); exe != "" {
+			rep.executables[exe]++
+		}
+		for _, k := range sc.Keys() {
+			rep.keys[k]++
+		}
+	}
+	if ev.Record(AUDIT_EXECVE) != nil {
+		u.Commands++
+	}
+	for _, p := range paths {
+		rep.files[p]++
+	}
+}
+
+func newAuditReportLogin(ev *AuditEvent, r *AuditRecord, user string) *AuditReportLogin {
+	host := r.Value("hostname")
+	if host == "" || host == "?" {
+		host = r.Value("addr")
+	}
+	res := r.Value("res")
+	return &AuditReportLogin{
+		Time:     ev.Timestamp,
+		Serial:   ev.Serial,
+		User:     user,
+		Host:     host,
+		Terminal: r.Value("terminal"),
+		Exe:      r.Value("exe"),
+		Success:  res == "success" || res == "yes" || res == "1",
+	}
+}
+
+func sortAuditReportCounts(m map[string]uint64) []AuditReportCount {
+	out := make([]AuditReportCount, 0, len(m))
+	for name, n := range m {
+		out = append(out, AuditReportCount{name, n})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
+
+// A snapshot of the aggregated data.
+func (rep *AuditReport) Summary() *AuditReportSummary {
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+	s := &AuditReportSummary{
+		Start:           rep.start,
+		End:             rep.end,
+		Events:          rep.events,
+		FailedEvents:    rep.failedEvents,
+		Logins:          append([]AuditReportLogin{}, rep.logins...),
+		Authentications: append([]AuditReportLogin{}, rep.auths...),
+		Anomalies:       append([]AuditReportAnomaly{}, rep.anomalies...),
+		Executables:     sortAuditReportCounts(rep.executables),
+		Files:           sortAuditReportCounts(rep.files),
+		Syscalls:        sortAuditReportCounts(rep.syscalls),
+		Keys:            sortAuditReportCounts(rep.keys),
+		Users:           make([]AuditReportUser, 0, len(rep.users)),
+	}
+	for _, l := range s.Logins {
+		if !l.Success {
+			s.FailedLogins++
+		}
+	}
+	for _, a := range s.Authentications {
+		if !a.Success {
+			s.FailedAuths++
+		}
+	}
+	for _, u := range rep.users {
+		s.Users = append(s.Users, *u)
+	}
+	sort.Slice(s.Users, func(i, j int) bool {
+		if s.Users[i].Events != s.Users[j].Events {
+			return s.Users[i].Events > s.Users[j].Events
+		}
+		return s.Users[i].User < s.Users[j].User
+	})
+	return s
+}
+
+func (rep *AuditReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(rep.Summary())
+}
+
+const auditReportTimeFormat = "01/02/2006 15:04:05"
+
+func auditReportYesNo(ok bool) string {
+	if ok {
+		return "yes"
+	}
+	return "no"
+}
+
+// Writes the summary followed by one section per report, laid out like
+// aureport does.
+func (rep *AuditReport) WriteText(w io.Writer) error {
+	s := rep.Summary()
+	ew := &auditReportWriter{w: w}
+
+	ew.printf("\nSummary Report\n======================\n")
+	if s.Events == 0 {
+		ew.printf("Range of time in logs: none\n")
+	} else {
+		ew.printf("Range of time in logs: %s - %s\n", s.Start.Format(auditReportTimeFormat), s.End.Format(auditReportTimeFormat))
+	}
+	ew.printf("Number of events: %d\n", s.Events)
+	ew.printf("Number of failed events: %d\n", s.FailedEvents)
+	ew.printf("Number of logins: %d\n", len(s.Logins))
+	ew.printf("Number of failed logins: %d\n", s.FailedLogins)
+	ew.printf("Number of authentications: %d\n", len(s.Authentications))
+	ew.printf("Number of failed authentications: %d\n", s.FailedAuths)
+	ew.printf("Number of users: %d\n", len(s.Users))
+	ew.printf("Number of executables: %d\n", len(s.Executables))
+	ew.printf("Number of files: %d\n", len(s.Files))
+	ew.printf("Number of anomaly events: %d\n", len(s.Anomalies))
+	ew.printf("Number of keys: %d\n", len(s.Keys))
+
+	ew.printf("\nLogin Report\n============================================\n")
+	ew.printf("# date time auid host term exe success event\n")
+	ew.printf("============================================\n")
+	for i, l := range s.Logins {
+		ew.printf("%d. %s %s %s %s %s %s %d\n", i+1, l.Time.Format(auditReportTimeFormat), l.User,
+			auditReportField(l.Host), auditReportField(l.Terminal), auditReportField(l.Exe), auditReportYesNo(l.Success), l.Serial)
+	}
+
+	ew.printf("\nFailed Authentication Report\n============================================\n")
+	ew.printf("# date time acct host term exe success event\n")
+	ew.printf("============================================\n")
+	n := 0
+	for _, a := range s.Authentications {
+		if a.Success {
+			continue
+		}
+		n++
+		ew.printf("%d. %s %s %s %s %s no %d\n", n, a.Time.Format(auditReportTimeFormat), a.User,
+			auditReportField(a.Host), auditReportField(a.Terminal), auditReportField(a.Exe), a.Serial)
+	}
+
+	ew.counts("Executable Summary Report", "total  file", s.Executables)
+	ew.counts("File Summary Report", "total  file", s.Files)
+
+	ew.printf("\nAnomaly Report\n=========================================\n")
+	ew.printf("# date time type exe auid event\n")
+	ew.printf("=========================================\n")
+	for i, a := range s.Anomalies {
+		ew.printf("%d. %s %s %s %s %d\n", i+1, a.Time.Format(auditReportTimeFormat), a.Type,
+			auditReportField(a.Exe), auditReportField(a.User), a.Serial)
+	}
+
+	ew.counts("Syscall Summary Report", "total  syscall", s.Syscalls)
+	ew.counts("Key Summary Report", "total  key", s.Keys)
+
+	ew.printf("\nUser Summary Report\n===========================================\n")
+	ew.printf("total  failed  commands  user\n")
+	ew.printf("===========================================\n")
+	for _, u := range s.Users {
+		ew.printf("%d  %d  %d  %s\n", u.Events, u.Failed, u.Commands, u.User)
+	}
+	return ew.err
+}
+
+func auditReportField(v string) string {
+	if v == "" {
+		return "?"
+	}
+	return v
+}
+
+// Remembers the first write error so the report code stays readable.
+type auditReportWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (ew *auditReportWriter) printf(format string, args ...interface{}) {
+	if ew.err != nil {
+		return
+	}
+	_, ew.err = fmt.Fprintf(ew.w, format, args...)
+}
+
+func (ew *auditReportWriter) counts(title, header string, rows []AuditReportCount) {
+	ew.printf("\n%s\n===================================\n%s\n===================================\n", title, header)
+	for _, c := range rows {
+		ew.printf("%d  %s\n", c.Count, c.Name)
+	}
+}
+
+// Aggregates the events of a recorded log that match q (nil for all).
+func AuditReportLog(lr *AuditLogReader, q *AuditQuery, rep *AuditReport) error {
+	return AuditSearch(lr, q, func(ev *AuditEvent) bool {
+		rep.Add(ev)
+		return true
+	})
+}
+
+// Live reports: aggregates the events of evchan and sends a report to
+// repchan every window, starting a fresh one each time. The events are
+// read until evchan is closed, then the last partial report is sent and
+// repchan is closed.
+func AuditReportWindow(window time.Duration, interp *AuditInterpreter, evchan <-chan *AuditEvent, repchan chan<- *AuditReport) {
+	defer close(repchan)
+	ticker := time.NewTicker(window)
+	defer ticker.Stop()
+
+	rep := NewAuditReport(interp)
+	for {
+		select {
+		case ev, ok := <-evchan:
+			if !ok {
+				repchan <- rep
+				return
+			}
+			rep.Add(ev)
+		case <-ticker.C:
+			repchan <- rep
+			rep = NewAuditReport(interp)
+		}
+	}
+}
@@ -0,0 +1,600 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+const (
+	AUDIT_FILTER_EXCLUDE = AUDIT_FILTER_TYPE
+	AUDIT_FILTER_FS      = 0x06 /* Apply rule at __audit_inode_child */
+
+	AUDIT_SESSIONID     = 25
+	AUDIT_FSTYPE        = 26
+	AUDIT_DEVMAJOR      = 100
+	AUDIT_DEVMINOR      = 101
+	AUDIT_INODE         = 102
+	AUDIT_EXIT          = 103
+	AUDIT_SUCCESS       = 104
+	AUDIT_WATCH         = 105
+	AUDIT_PERM          = 106
+	AUDIT_DIR           = 107
+	AUDIT_FILETYPE      = 108
+	AUDIT_OBJ_UID       = 109
+	AUDIT_OBJ_GID       = 110
+	AUDIT_FIELD_COMPARE = 111
+	AUDIT_EXE           = 112
+	AUDIT_ARG0          = 200
+	AUDIT_ARG1          = 201
+	AUDIT_ARG2          = 202
+	AUDIT_ARG3          = 203
+	AUDIT_FILTERKEY     = 210
+
+	AUDIT_PERM_EXEC  = 1
+	AUDIT_PERM_WRITE = 2
+	AUDIT_PERM_READ  = 4
+	AUDIT_PERM_ATTR  = 8
+
+	auditRuleDataLen = 4 * (4 + 4*AUDIT_MAX_FIELDS) /* struct audit_rule_data without buf */
+)
+
+var (
+	ErrAuditRuleList   = errors.New("audit rule: unknown list, want user, task, exit, exclude or filesystem")
+	ErrAuditRuleAction = errors.New("audit rule: unknown action, want never or always")
+	ErrAuditRuleShort  = errors.New("audit rule: short rule data")
+)
+
+var auditRuleLists = map[string]uint32{
+	"user":       AUDIT_FILTER_USER,
+	"task":       AUDIT_FILTER_TASK,
+	"exit":       AUDIT_FILTER_EXIT,
+	"exclude":    AUDIT_FILTER_EXCLUDE,
+	"filesystem": AUDIT_FILTER_FS,
+}
+
+var auditRuleActions = map[string]uint32{
+	"never":  AUDIT_NEVER,
+	"always": AUDIT_ALWAYS,
+}
+
+// Rule field names as auditctl -F takes them.
+var auditRuleFields = map[string]uint32{
+	"pid":           AUDIT_PID,
+	"uid":           AUDIT_UID,
+	"euid":          AUDIT_EUID,
+	"suid":          AUDIT_SUID,
+	"fsuid":         AUDIT_FSUID,
+	"gid":           AUDIT_GID,
+	"egid":          AUDIT_EGID,
+	"sgid":          AUDIT_SGID,
+	"fsgid":         AUDIT_FSGID,
+	"auid":          AUDIT_LOGINUID,
+	"pers":          AUDIT_PERS,
+	"arch":          AUDIT_ARCH,
+	"msgtype":       AUDIT_MSGTYPE,
+	"subj_user":     AUDIT_SUBJ_USER,
+	"subj_role":     AUDIT_SUBJ_ROLE,
+	"subj_type":     AUDIT_SUBJ_TYPE,
+	"subj_sen":      AUDIT_SUBJ_SEN,
+	"subj_clr":      AUDIT_SUBJ_CLR,
+	"ppid":          AUDIT_PPID,
+	"obj_user":      AUDIT_OBJ_USER,
+	"obj_role":      AUDIT_OBJ_ROLE,
+	"obj_type":      AUDIT_OBJ_TYPE,
+	"obj_lev_low":   AUDIT_OBJ_LEV_LOW,
+	"obj_lev_high":  AUDIT_OBJ_LEV_HIGH,
+	"loginuid_set":  AUDIT_LOGINUID_SET,
+	"sessionid":     AUDIT_SESSIONID,
+	"fstype":        AUDIT_FSTYPE,
+	"devmajor":      AUDIT_DEVMAJOR,
+	"devminor":      AUDIT_DEVMINOR,
+	"inode":         AUDIT_INODE,
+	"exit":          AUDIT_EXIT,
+	"success":       AUDIT_SUCCESS,
+	"path":          AUDIT_WATCH,
+	"perm":          AUDIT_PERM,
+	"dir":           AUDIT_DIR,
+	"filetype":      AUDIT_FILETYPE,
+	"obj_uid":       AUDIT_OBJ_UID,
+	"obj_gid":       AUDIT_OBJ_GID,
+	"field_compare": AUDIT_FIELD_COMPARE,
+	"exe":           AUDIT_EXE,
+	"a0":            AUDIT_ARG0,
+	"a1":            AUDIT_ARG1,
+	"a2":            AUDIT_ARG2,
+	"a3":            AUDIT_ARG3,
+	"key":           AUDIT_FILTERKEY,
+}
+
+var auditRuleOps = map[string]uint32{
+	"=":  AUDIT_EQUAL,
+	"!=": AUDIT_NOT_EQUAL,
+	"<":  AUDIT_LESS_THAN,
+	">":  AUDIT_GREATER_THAN,
+	"<=": AUDIT_LESS_THAN_OR_EQUAL,
+	">=": AUDIT_GREATER_THAN_OR_EQUAL,
+	"&":  AUDIT_BIT_MASK,
+	"&=": AUDIT_BIT_TEST,
+}
+
+func isAuditRuleStringField(f uint32) bool {
+	switch f {
+	case AUDIT_SUBJ_USER, AUDIT_SUBJ_ROLE, AUDIT_SUBJ_TYPE, AUDIT_SUBJ_SEN, AUDIT_SUBJ_CLR,
+		AUDIT_OBJ_USER, AUDIT_OBJ_ROLE, AUDIT_OBJ_TYPE, AUDIT_OBJ_LEV_LOW, AUDIT_OBJ_LEV_HIGH,
+		AUDIT_WATCH, AUDIT_DIR, AUDIT_FILTERKEY, AUDIT_EXE:
+		return true
+	}
+	return false
+}
+
+type AuditRuleField struct {
+	Name  string `json:"name"`
+	Op    string `json:"op"`
+	Value string `json:"value"`
+}
+
+// A rule in the terms auditctl uses: -a action,list -S syscall -F name=value.
+// Syscalls are names or numbers for the arch= field of the rule (the
+// running machine without one), "all" for every syscall.
+type AuditRule struct {
+	List     string           `json:"list"`
+	Action   string           `json:"action"`
+	Syscalls []string         `json:"syscalls,omitempty"`
+	Fields   []AuditRuleField `json:"fields,omitempty"`
+}
+
+func lookupAuditRuleName(m map[string]uint32, v uint32) string {
+	for name, n := range m {
+		if n == v {
+			return name
+		}
+	}
+	return strconv.FormatUint(uint64(v), 10)
+}
+
+// The AUDIT_ARCH_* value of arch=b64/b32 on the running machine.
+func auditRuleArch(v string) (uint32, error) {
+	host := auditHostArch()
+	switch v {
+	case "b64":
+		return host, nil
+	case "b32":
+		switch host {
+		case AUDIT_ARCH_X86_64:
+			return AUDIT_ARCH_I386, nil
+		case AUDIT_ARCH_AARCH64:
+			return AUDIT_ARCH_ARM, nil
+		}
+		return host &^ __AUDIT_ARCH_64BIT, nil
+	}
+	for a, name := range auditArchNames {
+		if name == v {
+			return a, nil
+		}
+	}
+	a, err := strconv.ParseUint(v, 0, 32)
+	return uint32(a), err
+}
+
+func formatAuditRuleArch(a uint32) string {
+	if b64, _ := auditRuleArch("b64"); a == b64 {
+		return "b64"
+	}
+	if b32, _ := auditRuleArch("b32"); a == b32 {
+		return "b32"
+	}
+	return "0x" + strconv.FormatUint(uint64(a), 16)
+}
+
+func parseAuditRulePerm(v string) (uint32, error) {
+	var perm uint32
+	for _, c := range v {
+		switch c {
+		case 'r':
+			perm |= AUDIT_PERM_READ
+		case 'w':
+			perm |= AUDIT_PERM_WRITE
+		case 'x':
+			perm |= AUDIT_PERM_EXEC
+		case 'a':
+			perm |= AUDIT_PERM_ATTR
+		default:
+			return 0, fmt.Errorf("audit rule: bad permission %q", v)
+		}
+	}
+	return perm, nil
+}
+
+func formatAuditRulePerm(perm uint32) string {
+	s := ""
+	for _, p := range []struct {
+		bit uint32
+		c   string
+	}{{AUDIT_PERM_READ, "r"}, {AUDIT_PERM_WRITE, "w"}, {AUDIT_PERM_EXEC, "x"}, {AUDIT_PERM_ATTR, "a"}} {
+		if perm&p.bit != 0 {
+			s += p.c
+		}
+	}
+	return s
+}
+
+// Encodes r as struct audit_rule_data, the payload of AUDIT_ADD_RULE and
+// AUDIT_DEL_RULE.
+func (r *AuditRule) Data() ([]byte, error) {
+	var rule AuditRuleData
+	var ok bool
+	if rule.Flags, ok = auditRuleLists[r.List]; !ok {
+		return nil, ErrAuditRuleList
+	}
+	if rule.Action, ok = auditRuleActions[r.Action]; !ok {
+		return nil, ErrAuditRuleAction
+	}
+	if len(r.Fields) > AUDIT_MAX_FIELDS {
+		return nil, fmt.Errorf("audit rule: more than %d fields", AUDIT_MAX_FIELDS)
+	}
+
+	arch := auditHostArch()
+	var buf bytes.Buffer
+	for i, f := range r.Fields {
+		field, ok := auditRuleFields[f.Name]
+		if !ok {
+			return nil, fmt.Errorf("audit rule: unknown field %q", f.Name)
+		}
+		op, ok := auditRuleOps[f.Op]
+		if !ok {
+			return nil, fmt.Errorf("audit rule: unknown operator %q", f.Op)
+		}
+		var value uint32
+		var err error
+		switch {
+		case isAuditRuleStringField(field):
+			value = uint32(len(f.Value))
+			buf.WriteString(f.Value)
+		case field == AUDIT_ARCH:
+			value, err = auditRuleArch(f.Value)
+			arch = value
+		case field == AUDIT_PERM:
+			value, err = parseAuditRulePerm(f.Value)
+		case field == AUDIT_MSGTYPE:
+			if t, ok := AuditMsgTypeByName(f.Value); ok {
+				value = uint32(t)
+				break
+			}
+			fallthrough
+		default:
+			var n int64
+			if f.Value == "unset" {
+				n = AUDIT_UNSET_ID
+			} else {
+				n, err = strconv.ParseInt(f.Value, 0, 64)
+			}
+			value = uint32(n)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("audit rule: field %s: %v", f.Name, err)
+		}
+		rule.Fields[i], rule.Fieldflags[i], rule.Values[i] = field, op, value
+	}
+	rule.Field_count = uint32(len(r.Fields))
+	rule.Buflen = uint32(buf.Len())
+
+	for _, sc := range r.Syscalls {
+		if sc == "all" {
+			for i := range rule.Mask {
+				rule.Mask[i] = ^uint32(0)
+			}
+			continue
+		}
+		nr, err := strconv.Atoi(sc)
+		if err != nil {
+			var ok bool
+			if nr, ok = AuditSyscallNumber(arch, sc); !ok {
+				return nil, fmt.Errorf("audit rule: unknown syscall %q", sc)
+			}
+		}
+		if int(auditWord(nr)) >= AUDIT_BITMASK_SIZE {
+			return nil, fmt.Errorf("audit rule: syscall %d out of range", nr)
+		}
+		AuditRuleSyscallData(&rule, nr)
+	}
+
+	out := new(bytes.Buffer)
+	if err := binary.Write(out, nativeEndian(), rule); err != nil {
+		return nil, err
+	}
+	out.Write(buf.Bytes())
+	return out.Bytes(), nil
+}
+
+// Decodes struct audit_rule_data as the kernel sends it for
+// AUDIT_LIST_RULES.
+func ParseAuditRuleData(b []byte) (*AuditRule, error) {
+	if len(b) < auditRuleDataLen {
+		return nil, ErrAuditRuleShort
+	}
+	var rule AuditRuleData
+	if err := binary.Read(bytes.NewReader(b[:auditRuleDataLen]), nativeEndian(), &rule); err != nil {
+		return nil, err
+	}
+	buf := b[auditRuleDataLen:]
+	if int(rule.Buflen) > len(buf) || rule.Field_count > AUDIT_MAX_FIELDS {
+		return nil, ErrAuditRuleShort
+	}
+	r := &AuditRule{
+		List:   lookupAuditRuleName(auditRuleLists, rule.Flags),
+		Action: lookupAuditRuleName(auditRuleActions, rule.Action),
+	}
+
+	arch := auditHostArch()
+	for i := 0; i < int(rule.Field_count); i++ {
+		field, value := rule.Fields[i], rule.Values[i]
+		f := AuditRuleField{
+			Name: lookupAuditRuleName(auditRuleFields, field),
+			Op:   lookupAuditRuleName(auditRuleOps, rule.Fieldflags[i]),
+		}
+		switch {
+		case isAuditRuleStringField(field):
+			if int(value) > len(buf) {
+				return nil, ErrAuditRuleShort
+			}
+			f.Value, buf = string(buf[:value]), buf[value:]
+		case field == AUDIT_ARCH:
+			f.Value, arch = formatAuditRuleArch(value), value
+		case field == AUDIT_PERM:
+			f.Value = formatAuditRulePerm(value)
+		case field == AUDIT_MSGTYPE:
+			f.Value = AuditMsgTypeName(uint16(value))
+		case field == AUDIT_EXIT:
+			f.Value = strconv.Itoa(int(int32(value)))
+		default:
+			f.Value = strconv.FormatUint(uint64(value), 10)
+		}
+		r.Fields = append(r.Fields, f)
+	}
+
+	all := true
+	for _, w := range rule.Mask {
+		all = all && w == ^uint32(0)
+	}
+	if all {
+		r.Syscalls = []string{"all"}
+		return r, nil
+	}
+	for nr := 0; nr < AUDIT_BITMASK_SIZE*32; nr++ {
+		if rule.Mask[auditWord(nr)]&auditBit(nr) == 0 {
+			continue
+		}
+		name := AuditSyscallName(arch, strconv.Itoa(nr))
+		if strings.HasPrefix(name, "unknown-syscall") {
+			name = strconv.Itoa(nr)
+		}
+		r.Syscalls = append(r.Syscalls, name)
+	}
+	return r, nil
+}
+
+// auditctl style: -a always,exit -F arch=b64 -S openat -F key=files
+func (r *AuditRule) String() string {
+	parts := []string{"-a " + r.Action + "," + r.List}
+	syscalls := func() {
+		if len(r.Syscalls) > 0 {
+			parts = append(parts, "-S "+strings.Join(r.Syscalls, ","))
+		}
+	}
+	hasArch := false
+	for _, f := range r.Fields {
+		if f.Name == "arch" {
+			hasArch = true
+		}
+	}
+	if !hasArch {
+		syscalls()
+	}
+	for _, f := range r.Fields {
+		parts = append(parts, "-F "+f.Name+f.Op+f.Value)
+		if f.Name == "arch" {
+			syscalls()
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// r the way the kernel will list it back, so rules can be compared by
+// their String.
+func (r *AuditRule) Canonical() (*AuditRule, error) {
+	data, err := r.Data()
+	if err != nil {
+		return nil, err
+	}
+	return ParseAuditRuleData(data)
+}
+
+// Requests on one transport take turns: each throws away the replies with
+// another sequence number, so two at once would steal each other's.
+var auditRequestLocks = struct {
+	sync.Mutex
+	m map[NetlinkTransport]*auditRequestLock
+}{m: make(map[NetlinkTransport]*auditRequestLock)}
+
+type auditRequestLock struct {
+	sync.Mutex
+	users int
+}
+
+func lockAuditRequests(s NetlinkTransport) func() {
+	auditRequestLocks.Lock()
+	l, ok := auditRequestLocks.m[s]
+	if !ok {
+		l = &auditRequestLock{}
+		auditRequestLocks.m[s] = l
+	}
+	l.users++
+	auditRequestLocks.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		auditRequestLocks.Lock()
+		if l.users--; l.users == 0 {
+			delete(auditRequestLocks.m, s)
+		}
+		auditRequestLocks.Unlock()
+	}
+}
+
+// Sends a request and reads the replies with its sequence number. fn gets
+// every reply that is not an ACK and returns true once it has seen the
+// last one; with a nil fn the ACK ends the exchange. NACKs are returned
+// as the errno they carry. Serialized per transport.
+func auditRequest(s NetlinkTransport, typ int, data []byte, fn func(m syscall.NetlinkMessage) bool) error {
+	defer lockAuditRequests(s)()
+	wb := newNetlinkAuditRequest(typ, syscall.AF_NETLINK, len(data))
+	wb.Data = append(wb.Data[:0], data...)
+	if err := s.Send(wb); err != nil {
+		return err
+	}
+	for {
+		msgs, err := s.Receive(MAX_AUDIT_MESSAGE_LENGTH, 0)
+		if err != nil {
+			return err
+		}
+		for _, m := range msgs {
+			if m.Header.Seq != wb.Header.Seq {
+				continue
+			}
+			if m.Header.Type == syscall.NLMSG_ERROR {
+				if len(m.Data) < 4 {
+					return syscall.EINVAL
+				}
+				if errno := -int32(nativeEndian().Uint32(m.Data[0:4])); errno != 0 {
+					return syscall.Errno(errno)
+				}
+				if fn == nil {
+					return nil
+				}
+				continue
+			}
+			if fn != nil && fn(m) {
+				return nil
+			}
+		}
+	}
+}
+
+// AUDIT_GET.
+func AuditGetStatus(s NetlinkTransport) (*AuditStatus, error) {
+	var status AuditStatus
+	var perr error
+	err := auditRequest(s, AUDIT_GET, nil, func(m syscall.NetlinkMessage) bool {
+		if m.Header.Type != AUDIT_GET {
+			return false
+		}
+		perr = binary.Read(bytes.NewReader(m.Data), nativeEndian(), &status)
+		return true
+	})
+	if err == nil {
+		err = perr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// AUDIT_SET of the fields selected by status.Mask (AUDIT_STATUS_*).
+func AuditSetStatus(s NetlinkTransport, status *AuditStatus) error {
+	buff := new(bytes.Buffer)
+	if err := binary.Write(buff, nativeEndian(), status); err != nil {
+		return err
+	}
+	return auditRequest(s, AUDIT_SET, buff.Bytes(), nil)
+}
+
+// AUDIT_LIST_RULES.
+func AuditListRules(s NetlinkTransport) ([]*AuditRule, error) {
+	var rules []*AuditRule
+	var perr error
+	err := auditRequest(s, AUDIT_LIST_RULES, nil, func(m syscall.NetlinkMessage) bool {
+		switch m.Header.Type {
+		case syscall.NLMSG_DONE:
+			return true
+		case AUDIT_LIST_RULES:
+			r, err := ParseAuditRuleData(m.Data)
+			if err != nil && perr == nil {
+				perr = err
+			}
+			if r != nil {
+				rules = append(rules, r)
+			}
+		}
+		return false
+	})
+	if err == nil {
+		err = perr
+	}
+	return rules, err
+}
+
+func AuditAddRule(s NetlinkTransport, r *AuditRule) error {
+	data, err := r.Data()
+	if err != nil {
+		return err
+	}
+	return auditRequest(s, AUDIT_ADD_RULE, data, nil)
+}
+
+func AuditDeleteRule(s NetlinkTransport, r *AuditRule) error {
+	data, err := r.Data()
+	if err != nil {
+		return err
+	}
+	return auditRequest(s, AUDIT_DEL_RULE, data, nil)
+}
+
+// Makes the kernel rule set equal to want: rules missing from want are
+// deleted, missing ones added in the order of want. Rules are compared in
+// their canonical form.
+func AuditReconcileRules(s NetlinkTransport, want []*AuditRule) (added, deleted []*AuditRule, err error) {
+	have, err := AuditListRules(s)
+	if err != nil {
+		return nil, nil, err
+	}
+	wanted := make(map[string]bool)
+	var canon []*AuditRule
+	for _, r := range want {
+		c, err := r.Canonical()
+		if err != nil {
+			return nil, nil, err
+		}
+		wanted[c.String()] = true
+		canon = append(canon, c)
+	}
+	present := make(map[string]bool)
+	for _, r := range have {
+		if wanted[r.String()] && !present[r.String()] {
+			present[r.String()] = true
+			continue
+		}
+		if err := AuditDeleteRule(s, r); err != nil {
+			return added, deleted, err
+		}
+		deleted = append(deleted, r)
+	}
+	for _, r := range canon {
+		if present[r.String()] {
+			continue
+		}
+		if err := AuditAddRule(s, r); err != nil {
+			return added, deleted, err
+		}
+		present[r.String()] = true
+		added = append(added, r)
+	}
+	return added, deleted, nil
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	AUDIT_SESSION_DEFAULT_MAX_EVENTS   = 10000
+	AUDIT_SESSION_DEFAULT_MAX_COMMANDS = 1000
+	AUDIT_SESSION_DEFAULT_RETAIN       = 24 * time.Hour
+	AUDIT_SESSION_DEFAULT_IDLE         = 7 * 24 * time.Hour
+)
+
+// A command run inside a session, from an event with EXECVE records.
+type AuditSessionCommand struct {
+	Time    time.Time
+	Serial  uint64
+	Pid     string
+	Exe     string
+	Argv    []string
+	Success string /* yes, no or "" */
+}
+
+// One login session, identified by the kernel's ses= number.
+type AuditSession struct {
+	Ses      uint32
+	Auid     uint32
+	User     string
+	Addr     string
+	Hostname string
+	Terminal string
+	Exe      string /* login program, e.g. /usr/sbin/sshd */
+	Start    time.Time
+	End      time.Time /* zero while the session is open */
+	Last     time.Time /* newest event */
+	Idle     bool      /* ended for inactivity, no USER_END was seen */
+	Commands []AuditSessionCommand
+	Events   []*AuditEvent /* timeline, oldest first */
+	Dropped  uint64        /* events dropped from the front of the timeline */
+
+	CommandsDropped uint64 /* commands dropped from the front of Commands */
+
+	//Events and Commands are rings inside the tracker, these are their
+	//oldest entries once full. Snapshots are rotated back into order.
+	evFirst  int
+	cmdFirst int
+}
+
+func (s *AuditSession) Open() bool {
+	return s.End.IsZero()
+}
+
+// Groups events by their ses= value. Session boundaries come from the
+// kernel LOGIN record and the USER_LOGIN, USER_START and USER_END/
+// USER_LOGOUT messages. Events of processes without a session (ses unset)
+// are ignored. Sessions without an event for Idle are taken as ended, their
+// USER_END was lost. Safe for concurrent use.
+type AuditSessionTracker struct {
+	MaxEvents   int           /* per session timeline, oldest dropped first, 0 for no limit */
+	MaxCommands int           /* per session, oldest dropped first, 0 for no limit */
+	Retain      time.Duration /* how long ended sessions are kept */
+	Idle        time.Duration
+	Interp      *AuditInterpreter
+
+	mu       sync.RWMutex
+	sessions map[uint32]*AuditSession
+	now      func() time.Time
+}
+
+func NewAuditSessionTracker(maxEvents int, retain time.Duration, interp *AuditInterpreter) *AuditSessionTracker {
+	if maxEvents <= 0 {
+		maxEvents = AUDIT_SESSION_DEFAULT_MAX_EVENTS
+	}
+	if retain <= 0 {
+		retain = AUDIT_SESSION_DEFAULT_RETAIN
+	}
+	return &AuditSessionTracker{
+		MaxEvents:   maxEvents,
+		MaxCommands: AUDIT_SESSION_DEFAULT_MAX_COMMANDS,
+		Retain:      retain,
+		Idle:        AUDIT_SESSION_DEFAULT_IDLE,
+		Interp:      interp,
+		sessions:    make(map[uint32]*AuditSession),
+		now:         time.Now,
+	}
+}
+
+func parseAuditId(v string) (uint32, bool) {
+	n, err := strconv.ParseUint(v, 10, 32)
+	if err != nil || n == AUDIT_UNSET_ID {
+		return 0, false
+	}
+	return uint32(n), true
+}
+
+// The record that says something about the session itself, if any.
+func auditSessionBoundary(ev *AuditEvent) *AuditRecord {
+	for _, typ := range []uint16{AUDIT_LOGIN, AUDIT_USER_LOGIN, AUDIT_USER_START, AUDIT_USER_END, AUDIT_USER_LOGOUT} {
+		if r := ev.Record(typ); r != nil {
+			return r
+		}
+	}
+	return nil
+}
+
+func (t *AuditSessionTracker) Add(ev *AuditEvent) {
+	boundary := auditSessionBoundary(ev)
+	sesValue, auidValue := ev.Value("ses"), ev.Value("auid")
+	if boundary != nil && boundary.Type == AUDIT_LOGIN {
+		//The SYSCALL record of a LOGIN event still carries the old ids
+		sesValue, auidValue = boundary.Value("ses"), boundary.Value("auid")
+	}
+	ses, ok := parseAuditId(sesValue)
+	if !ok {
+		return
+	}
+	auid, hasAuid := parseAuditId(auidValue)
+	var user string
+	if hasAuid && t.Interp != nil {
+		user = t.Interp.UserName(strconv.FormatUint(uint64(auid), 10))
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.sessions[ses]
+	//A reused ses number after the old session ended starts a new one
+	if ok && !s.Open() && boundary != nil && boundary.Type != AUDIT_USER_END && boundary.Type != AUDIT_USER_LOGOUT {
+		ok = false
+	}
+	if !ok {
+		s = &AuditSession{Ses: ses, Auid: AUDIT_UNSET_ID, Start: ev.Timestamp}
+		t.sessions[ses] = s
+	}
+	if hasAuid && s.Auid == AUDIT_UNSET_ID {
+		s.Auid, s.User = auid, user
+	}
+	if ev.Timestamp.Before(s.Start) {
+		s.Start = ev.Timestamp
+	}
+	if ev.Timestamp.After(s.Last) {
+		s.Last = ev.Timestamp
+	}
+
+	if boundary != nil {
+		switch boundary.Type {
+		case AUDIT_USER_LOGIN, AUDIT_USER_START:
+			setIfEmpty(&s.Addr, boundary.Value("addr"))
+			setIfEmpty(&s.Hostname, boundary.Value("hostname"))
+			setIfEmpty(&s.Terminal, boundary.Value("terminal"))
+			setIfEmpty(&s.Exe, boundary.Value("exe"))
+			if acct := boundary.Value("acct"); acct != "" && s.User == "" {
+				s.User = acct
+			}
+		case AUDIT_USER_END, AUDIT_USER_LOGOUT:
+			s.End, s.Idle = ev.Timestamp, false
+		}
+	}
+
+	if ev.Record(AUDIT_EXECVE) != nil {
+		cmd := AuditSessionCommand{
+			Time:    ev.Timestamp,
+			Serial:  ev.Serial,
+			Pid:     ev.Value("pid"),
+			Exe:     ev.Value("exe"),
+			Argv:    ev.Argv,
+			Success: auditEventSuccess(ev),
+		}
+		if t.MaxCommands > 0 && len(s.Commands) >= t.MaxCommands {
+			s.Commands[s.cmdFirst] = cmd
+			s.cmdFirst = (s.cmdFirst + 1) % len(s.Commands)
+			s.CommandsDropped++
+		} else {
+			s.Commands = append(s.Commands, cmd)
+		}
+	}
+	if t.MaxEvents > 0 && len(s.Events) >= t.MaxEvents {
+		s.Events[s.evFirst] = ev
+		s.evFirst = (s.evFirst + 1) % len(s.Events)
+		s.Dropped++
+	} else {
+		s.Events = append(s.Events, ev)
+	}
+}
+
+func setIfEmpty(dst *string, v string) {
+	if *dst == "" && v != "" && v != "?" {
+		*dst = v
+	}
+}
+
+// A snapshot of the session, without the timeline unless events is set.
+func (s *AuditSession) copy(events bool) AuditSession {
+	c := *s
+	c.Commands = make([]AuditSessionCommand, 0, len(s.Commands))
+	c.Commands = append(append(c.Commands, s.Commands[s.cmdFirst:]...), s.Commands[:s.cmdFirst]...)
+	c.Events = nil
+	if events {
+		c.Events = make([]*AuditEvent, 0, len(s.Events))
+		c.Events = append(append(c.Events, s.Events[s.evFirst:]...), s.Events[:s.evFirst]...)
+	}
+	c.evFirst, c.cmdFirst = 0, 0
+	return c
+}
+
+// Returns a snapshot of the session.
+func (t *AuditSessionTracker) Session(ses uint32) (AuditSession, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	s, ok := t.sessions[ses]
+	if !ok {
+		return AuditSession{}, false
+	}
+	return s.copy(true), true
+}
+
+// Snapshots of all known sessions ordered by start time. Pass the auid
+// to only get the sessions of one user, AUDIT_UNSET_ID for all.
+func (t *AuditSessionTracker) Sessions(auid uint32) []AuditSession {
+	t.mu.RLock()
+	out := make([]AuditSession, 0, len(t.sessions))
+	for _, s := range t.sessions {
+		if auid != AUDIT_UNSET_ID && s.Auid != auid {
+			continue
+		}
+		out = append(out, s.copy(false))
+	}
+	t.mu.RUnlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].Start.Before(out[j].Start) })
+	return out
+}
+
+// The events of session ses between from and to (zero values mean no
+// bound), in time order.
+func (t *AuditSessionTracker) Timeline(ses uint32, from, to time.Time) []*AuditEvent {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	s, ok := t.sessions[ses]
+	if !ok {
+		return nil
+	}
+	var out []*AuditEvent
+	for _, ev := range s.Events {
+		if !from.IsZero() && ev.Timestamp.Before(from) {
+			continue
+		}
+		if !to.IsZero() && ev.Timestamp.After(to) {
+			continue
+		}
+		out = append(out, ev)
+	}
+	sortAuditEvents(out)
+	return out
+}
+
+// Ends open sessions idle for longer than Idle and forgets sessions that
+// ended more than Retain ago.
+func (t *AuditSessionTracker) Expire() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	cutoff := now.Add(-t.Retain)
+	n := 0
+	for ses, s := range t.sessions {
+		if s.Open() && t.Idle > 0 && s.Last.Before(now.Add(-t.Idle)) {
+			s.End, s.Idle = s.Last, true
+		}
+		if !s.Open() && s.End.Before(cutoff) {
+			delete(t.sessions, ses)
+			n++
+		}
+	}
+	return n
+}
+
+// Feeds every event of in to t and passes it on to out (if not nil).
+// out is closed once in is closed.
+func AuditSessionStream(t *AuditSessionTracker, in <-chan *AuditEvent, out chan<- *AuditEvent) {
+	if out != nil {
+		defer close(out)
+	}
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case ev, ok := <-in:
+			if !ok {
+				return
+			}
+			t.Add(ev)
+			if out != nil {
+				out <- ev
+			}
+		case <-ticker.C:
+			t.Expire()
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func auditSessionTestEvent(t *testing.T, serial uint64, typ uint16, data string) *AuditEvent {
+	r := parseAuditTestRecord(t, typ, "audit(1700000000.000:"+strconv.FormatUint(serial, 10)+"): "+data)
+	r.Timestamp = r.Timestamp.Add(time.Duration(serial) * time.Second)
+	return &AuditEvent{Serial: serial, Timestamp: r.Timestamp, Records: []*AuditRecord{r}}
+}
+
+func TestAuditSessionTracker(t *testing.T) {
+	tr := NewAuditSessionTracker(0, 0, nil)
+	tr.Add(auditSessionTestEvent(t, 1, AUDIT_USER_LOGIN, `pid=1 uid=0 auid=1000 ses=3 msg='op=login acct="alice" exe="/usr/sbin/sshd" hostname=h addr=10.0.0.1 terminal=ssh res=success'`))
+	tr.Add(auditSessionTestEvent(t, 2, AUDIT_SYSCALL, `arch=c000003e syscall=59 success=yes pid=2 auid=1000 ses=3 comm="ls"`))
+	//Not in a session
+	tr.Add(auditSessionTestEvent(t, 3, AUDIT_SYSCALL, `syscall=59 pid=4 auid=4294967295 ses=4294967295`))
+	tr.Add(auditSessionTestEvent(t, 4, AUDIT_USER_END, `pid=1 uid=0 auid=1000 ses=3 msg='op=PAM:session_close acct="alice" res=success'`))
+
+	s, ok := tr.Session(3)
+	if !ok || s.Auid != 1000 || s.User != "alice" || s.Addr != "10.0.0.1" || s.Exe != "/usr/sbin/sshd" || s.Open() {
+		t.Fatalf("got %+v", s)
+	}
+	if len(s.Events) != 3 || s.Events[1].Serial != 2 {
+		t.Fatalf("got %d events", len(s.Events))
+	}
+	if n := len(tr.Sessions(AUDIT_UNSET_ID)); n != 1 {
+		t.Fatalf("got %d sessions", n)
+	}
+}
+
+// Full timelines drop their oldest events and snapshots stay in order.
+func TestAuditSessionTrackerRing(t *testing.T) {
+	tr := NewAuditSessionTracker(2, 0, nil)
+	for serial := uint64(1); serial <= 5; serial++ {
+		tr.Add(auditSessionTestEvent(t, serial, AUDIT_SYSCALL, "syscall=2 pid=2 auid=1000 ses=3"))
+	}
+	s, _ := tr.Session(3)
+	if len(s.Events) != 2 || s.Events[0].Serial != 4 || s.Events[1].Serial != 5 || s.Dropped != 3 {
+		t.Fatalf("got %d events, dropped %d", len(s.Events), s.Dropped)
+	}
+
+	//The exported limits may be set to 0, meaning no limit
+	tr = NewAuditSessionTracker(0, 0, nil)
+	tr.MaxEvents, tr.MaxCommands = 0, 0
+	for serial := uint64(1); serial <= 5; serial++ {
+		tr.Add(auditSessionTestEvent(t, serial, AUDIT_SYSCALL, "syscall=2 pid=2 auid=1000 ses=3"))
+	}
+	if s, _ := tr.Session(3); len(s.Events) != 5 || s.Dropped != 0 {
+		t.Fatalf("got %d events, dropped %d", len(s.Events), s.Dropped)
+	}
+}
+
+func TestAuditSessionTrackerIdle(t *testing.T) {
+	tr := NewAuditSessionTracker(0, 24*time.Hour, nil)
+	tr.Idle = time.Hour
+	tr.Add(auditSessionTestEvent(t, 1, AUDIT_SYSCALL, "syscall=2 pid=2 auid=1000 ses=3"))
+	last := time.Unix(1700000001, 0)
+
+	tr.now = func() time.Time { return last.Add(tr.Idle + time.Second) }
+	if n := tr.Expire(); n != 0 {
+		t.Fatalf("forgot %d sessions", n)
+	}
+	if s, _ := tr.Session(3); s.Open() || !s.Idle || !s.End.Equal(last) {
+		t.Fatalf("got %+v", s)
+	}
+	tr.now = func() time.Time { return last.Add(25 * time.Hour) }
+	if n := tr.Expire(); n != 1 {
+		t.Fatalf("forgot %d sessions", n)
+	}
+}
@@ -0,0 +1,93 @@
+//go:build ignore
+
+// Generates AuditSyscalls.go, the syscall number to name tables used to
+// interpret the syscall= field, from the kernel's uapi unistd headers.
+//
+//	go run AuditSyscallsGen.go [-i /usr/include] [-o AuditSyscalls.go]
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+var tables = []struct {
+	arch   string
+	header string
+}{
+	{"AUDIT_ARCH_X86_64", "x86_64-linux-gnu/asm/unistd_64.h"},
+	{"AUDIT_ARCH_I386", "x86_64-linux-gnu/asm/unistd_32.h"},
+	{"AUDIT_ARCH_AARCH64", "asm-generic/unistd.h"},
+}
+
+// asm-generic/unistd.h defines the 64-bit names of some calls through __NR3264_*.
+var defineRe = regexp.MustCompile(`^#define\s+__NR(?:3264)?_([a-z0-9_]+)\s+([0-9]+)\s*$`)
+
+func parse(path string) (map[int]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	calls := make(map[int]string)
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		m := defineRe.FindStringSubmatch(sc.Text())
+		if m == nil {
+			continue
+		}
+		nr, err := strconv.Atoi(m[2])
+		if err != nil {
+			continue
+		}
+		if _, ok := calls[nr]; !ok {
+			calls[nr] = m[1]
+		}
+	}
+	return calls, sc.Err()
+}
+
+func main() {
+	include := flag.String("i", "/usr/include", "kernel header include directory")
+	out := flag.String("o", "AuditSyscalls.go", "output file")
+	flag.Parse()
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by AuditSyscallsGen.go; DO NOT EDIT.\n\npackage main\n\n")
+	fmt.Fprintf(&b, "var auditSyscallTables = map[uint32]map[int]string{\n")
+	for _, t := range tables {
+		calls, err := parse(filepath.Join(*include, t.header))
+		if err != nil {
+			log.Fatal(err)
+		}
+		nrs := make([]int, 0, len(calls))
+		for nr := range calls {
+			nrs = append(nrs, nr)
+		}
+		sort.Ints(nrs)
+		fmt.Fprintf(&b, "\t%s: {\n", t.arch)
+		for _, nr := range nrs {
+			fmt.Fprintf(&b, "\t\t%d: %q,\n", nr, calls[nr])
+		}
+		fmt.Fprintf(&b, "\t},\n")
+	}
+	fmt.Fprintf(&b, "}\n")
+
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(*out, src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/syslog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	AUDIT_SYSLOG_RFC5424 = 0
+	AUDIT_SYSLOG_RFC3164 = 1
+
+	AUDIT_SYSLOG_SD_ID           = "audit@32473" /* 32473 is the IANA example enterprise number */
+	AUDIT_SYSLOG_DEFAULT_APP     = "audit"
+	AUDIT_SYSLOG_DEFAULT_TIMEOUT = 10 * time.Second
+	AUDIT_SYSLOG_MAX_UDP         = 65000
+)
+
+var ErrAuditSyslogNetwork = errors.New("audit syslog: network must be udp, tcp, tls or unix")
+
+// Event fields copied into the structured data element, in this order.
+var auditSyslogDefaultSDFields = []string{"auid", "uid", "euid", "ses", "pid", "ppid", "comm", "exe", "syscall", "success", "exit", "res"}
+
+// Severity per record type used unless the configuration overrides it.
+func DefaultAuditSyslogSeverities() map[uint16]syslog.Priority {
+	m := make(map[uint16]syslog.Priority)
+	for t := uint16(AUDIT_FIRST_KERN_ANOM_MSG); t <= AUDIT_LAST_KERN_ANOM_MSG; t++ {
+		m[t] = syslog.LOG_WARNING
+	}
+	for t := uint16(AUDIT_FIRST_ANOM_MSG); t <= AUDIT_LAST_ANOM_MSG; t++ {
+		m[t] = syslog.LOG_WARNING
+	}
+	for t := uint16(AUDIT_FIRST_ANOM_RESP); t <= AUDIT_LAST_ANOM_RESP; t++ {
+		m[t] = syslog.LOG_NOTICE
+	}
+	m[AUDIT_AVC] = syslog.LOG_NOTICE
+	m[AUDIT_SELINUX_ERR] = syslog.LOG_ERR
+	m[AUDIT_USER_SELINUX_ERR] = syslog.LOG_ERR
+	m[AUDIT_DAEMON_ABORT] = syslog.LOG_ERR
+	m[AUDIT_DAEMON_ERR] = syslog.LOG_ERR
+	m[AUDIT_INTEGRITY_RULE] = syslog.LOG_WARNING
+	return m
+}
+
+type AuditSyslogConfig struct {
+	Network  string /* udp, tcp, tls or unix */
+	Address  string /* host:port, or the socket path for unix ("" is /dev/log) */
+	TLS      *tls.Config
+	Format   int
+	Facility syslog.Priority /* LOG_AUTHPRIV, LOG_LOCAL0 ... */
+	Severity syslog.Priority /* for record types without an entry below */
+
+	//Facility and Severity are taken as unset when zero, LOG_AUTHPRIV and
+	//LOG_INFO are used then. Set these to use LOG_KERN or LOG_EMERG.
+	FacilitySet bool
+	SeveritySet bool
+
+	TypeFacility map[uint16]syslog.Priority
+	TypeSeverity map[uint16]syslog.Priority /* nil for DefaultAuditSyslogSeverities */
+	SDFields     []string                   /* nil for the default list */
+
+	Hostname string /* "" for os.Hostname */
+	AppName  string
+	Timeout  time.Duration
+}
+
+// Sends audit records as syslog messages, one message per record with the
+// event's key fields as RFC 5424 structured data. Stream transports use
+// octet counting framing (RFC 6587), the unix socket is tried as datagram
+// first like /dev/log expects. Safe for concurrent use.
+type AuditSyslogWriter struct {
+	cfg    AuditSyslogConfig
+	pid    string
+	mu     sync.Mutex
+	conn   net.Conn /* nil after a failed redial, dialled again on the next write */
+	frame  bool     /* octet counting */
+	lf     bool     /* newline framing, unix stream sockets */
+	closed bool
+}
+
+func NewAuditSyslogWriter(cfg AuditSyslogConfig) (*AuditSyslogWriter, error) {
+	switch cfg.Network {
+	case "udp", "tcp", "tls", "unix":
+	default:
+		return nil, ErrAuditSyslogNetwork
+	}
+	if cfg.Network == "unix" && cfg.Address == "" {
+		cfg.Address = "/dev/log"
+	}
+	if cfg.Facility == 0 && !cfg.FacilitySet {
+		cfg.Facility = syslog.LOG_AUTHPRIV
+	}
+	if cfg.Severity == 0 && !cfg.SeveritySet {
+		cfg.Severity = syslog.LOG_INFO
+	}
+	if cfg.TypeSeverity == nil {
+		cfg.TypeSeverity = DefaultAuditSyslogSeverities()
+	}
+	if cfg.SDFields == nil {
+		cfg.SDFields = auditSyslogDefaultSDFields
+	}
+	if cfg.Hostname == "" {
+		cfg.Hostname, _ = os.Hostname()
+	}
+	if cfg.AppName == "" {
+		cfg.AppName = AUDIT_SYSLOG_DEFAULT_APP
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = AUDIT_SYSLOG_DEFAULT_TIMEOUT
+	}
+	w := &AuditSyslogWriter{cfg: cfg, pid: strconv.Itoa(os.Getpid())}
+	if err := w.dial(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Connects, w.conn is only replaced on success.
+func (w *AuditSyslogWriter) dial() error {
+	var conn net.Conn
+	var err error
+	frame, lf := false, false
+	d := &net.Dialer{Timeout: w.cfg.Timeout}
+	switch w.cfg.Network {
+	case "udp":
+		conn, err = d.Dial("udp", w.cfg.Address)
+	case "tcp":
+		conn, err = d.Dial("tcp", w.cfg.Address)
+		frame = true
+	case "tls":
+		//Not assigned directly, a failed dial is a typed nil *tls.Conn
+		var tc *tls.Conn
+		if tc, err = tls.DialWithDialer(d, "tcp", w.cfg.Address, w.cfg.TLS); err == nil {
+			conn = tc
+		}
+		frame = true
+	case "unix":
+		conn, err = d.Dial("unixgram", w.cfg.Address)
+		if err != nil {
+			conn, err = d.Dial("unix", w.cfg.Address)
+			lf = true
+		}
+	}
+	if err != nil {
+		return err
+	}
+	w.conn, w.frame, w.lf = conn, frame, lf
+	return nil
+}
+
+// Priority of a record: facility and severity from the per type tables,
+// the configured defaults otherwise.
+func (w *AuditSyslogWriter) priority(typ uint16) syslog.Priority {
+	fac, ok := w.cfg.TypeFacility[typ]
+	if !ok {
+		fac = w.cfg.Facility
+	}
+	sev, ok := w.cfg.TypeSeverity[typ]
+	if !ok {
+		sev = w.cfg.Severity
+	}
+	return fac&^7 | sev&7
+}
+
+// SD-PARAM values escape ", \ and ].
+var auditSyslogSDEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+func (w *AuditSyslogWriter) structuredData(ev *AuditEvent, r *AuditRecord) string {
+	var b strings.Builder
+	b.WriteString("[" + AUDIT_SYSLOG_SD_ID)
+	param := func(name, value string) {
+		b.WriteString(" " + name + "=\"" + auditSyslogSDEscaper.Replace(value) + "\"")
+	}
+	param("type", r.TypeName)
+	param("serial", strconv.FormatUint(ev.Serial, 10))
+	if ev.Node != "" {
+		param("node", ev.Node)
+	}
+	for _, name := range w.cfg.SDFields {
+		if v := ev.Value(name); v != "" {
+			param(name, v)
+		}
+	}
+	for _, k := range auditEventKeys(ev) {
+		param("key", k)
+	}
+	b.WriteString("]")
+	return b.String()
+}
+
+// All rule keys of the event.
+func auditEventKeys(ev *AuditEvent) []string {
+	var keys []string
+	for _, r := range ev.Records {
+		keys = append(keys, r.Keys()...)
+	}
+	return keys
+}
+
+// Formats one record of ev without framing.
+func (w *AuditSyslogWriter) Format(ev *AuditEvent, r *AuditRecord) string {
+	pri := w.priority(r.Type)
+	text := "type=" + r.TypeName + " msg=" + formatAuditStamp(r.Timestamp, r.Serial) + ": " + r.Data
+	if w.cfg.Format == AUDIT_SYSLOG_RFC3164 {
+		return fmt.Sprintf("<%d>%s %s %s[%s]: %s", pri, ev.Timestamp.Format(time.Stamp),
+			w.cfg.Hostname, w.cfg.AppName, w.pid, text)
+	}
+	host := w.cfg.Hostname
+	if host == "" {
+		host = "-"
+	}
+	return fmt.Sprintf("<%d>1 %s %s %s %s %s %s %s", pri, ev.Timestamp.Format("2006-01-02T15:04:05.000000Z07:00"),
+		host, w.cfg.AppName, w.pid, r.TypeName, w.structuredData(ev, r), text)
+}
+
+func (w *AuditSyslogWriter) write(msg string) error {
+	switch {
+	case w.frame:
+		msg = strconv.Itoa(len(msg)) + " " + msg
+	case w.lf:
+		msg += "\n"
+	case w.cfg.Network == "udp" && len(msg) > AUDIT_SYSLOG_MAX_UDP:
+		msg = msg[:AUDIT_SYSLOG_MAX_UDP]
+	}
+	if w.conn == nil {
+		return net.ErrClosed
+	}
+	w.conn.SetWriteDeadline(time.Now().Add(w.cfg.Timeout))
+	_, err := w.conn.Write([]byte(msg))
+	return err
+}
+
+// Sends every record of ev except EOE. A broken connection is redialled
+// once before giving up; if that fails too the next call dials again.
+func (w *AuditSyslogWriter) WriteEvent(ev *AuditEvent) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return net.ErrClosed
+	}
+	for _, r := range ev.Records {
+		if r.Type == AUDIT_EOE {
+			continue
+		}
+		if w.conn == nil {
+			if err := w.dial(); err != nil {
+				return err
+			}
+		}
+		msg := w.Format(ev, r)
+		if err := w.write(msg); err != nil {
+			w.conn.Close()
+			w.conn = nil
+			if err := w.dial(); err != nil {
+				return err
+			}
+			if err := w.write(msg); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (w *AuditSyslogWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+	if w.conn == nil {
+		return nil
+	}
+	err := w.conn.Close()
+	w.conn = nil
+	return err
+}
+
+// Forwards every event from evchan until it is closed. Send errors go to
+// errchan.
+func AuditSyslogStream(w *AuditSyslogWriter, evchan <-chan *AuditEvent, errchan chan<- error) {
+	for ev := range evchan {
+		if err := w.WriteEvent(ev); err != nil {
+			errchan <- err
+		}
+	}
+}
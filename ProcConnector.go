@@ -0,0 +1,385 @@
+package main
+
+import (
+	"fmt"
+	"syscall"
+	"time"
+)
+
+// Process events connector, see linux/connector.h and linux/cn_proc.h
+const (
+	NETLINK_CONNECTOR = 11
+
+	CN_IDX_PROC = 0x1
+	CN_VAL_PROC = 0x1
+
+	PROC_CN_MCAST_LISTEN = 1
+	PROC_CN_MCAST_IGNORE = 2
+
+	PROC_EVENT_NONE     = 0x00000000 /* ack of a listen/ignore request */
+	PROC_EVENT_FORK     = 0x00000001
+	PROC_EVENT_EXEC     = 0x00000002
+	PROC_EVENT_UID      = 0x00000004
+	PROC_EVENT_GID      = 0x00000040
+	PROC_EVENT_SID      = 0x00000080
+	PROC_EVENT_PTRACE   = 0x00000100
+	PROC_EVENT_COMM     = 0x00000200
+	PROC_EVENT_COREDUMP = 0x40000000
+	PROC_EVENT_EXIT     = 0x80000000
+
+	cnMsgLen        = 20 /* struct cn_msg without data */
+	procEventHdrLen = 16 /* what, cpu, timestamp_ns */
+	procCommLen     = 16 /* TASK_COMM_LEN */
+
+	PROC_CONNECTOR_POLL        = 250 * time.Millisecond /* how often a stream checks done */
+	PROC_CONNECTOR_MAX_BACKOFF = 5 * time.Second        /* between reads after repeated errors */
+)
+
+var procEventNames = map[uint32]string{
+	PROC_EVENT_NONE:     "none",
+	PROC_EVENT_FORK:     "fork",
+	PROC_EVENT_EXEC:     "exec",
+	PROC_EVENT_UID:      "uid",
+	PROC_EVENT_GID:      "gid",
+	PROC_EVENT_SID:      "sid",
+	PROC_EVENT_PTRACE:   "ptrace",
+	PROC_EVENT_COMM:     "comm",
+	PROC_EVENT_COREDUMP: "coredump",
+	PROC_EVENT_EXIT:     "exit",
+}
+
+// Minimum size of the event_data union member for each event.
+var procEventDataLen = map[uint32]int{
+	PROC_EVENT_NONE:     4,
+	PROC_EVENT_FORK:     16,
+	PROC_EVENT_EXEC:     8,
+	PROC_EVENT_UID:      16,
+	PROC_EVENT_GID:      16,
+	PROC_EVENT_SID:      8,
+	PROC_EVENT_PTRACE:   16,
+	PROC_EVENT_COMM:     8 + procCommLen,
+	PROC_EVENT_COREDUMP: 8,
+	PROC_EVENT_EXIT:     16,
+}
+
+func ProcEventName(what uint32) string {
+	if name, ok := procEventNames[what]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%#x)", what)
+}
+
+// Common part of every process event.
+type ProcEventHeader struct {
+	What      uint32
+	CPU       uint32
+	Timestamp time.Duration /* since boot, CLOCK_MONOTONIC */
+}
+
+// One of the Proc*Event types below.
+type ProcEvent interface {
+	Header() ProcEventHeader
+}
+
+func (h ProcEventHeader) Header() ProcEventHeader { return h }
+
+// Answer to a listen/ignore request, Err is an errno.
+type ProcAckEvent struct {
+	ProcEventHeader
+	Err uint32
+}
+
+type ProcForkEvent struct {
+	ProcEventHeader
+	ParentPid  uint32
+	ParentTgid uint32
+	ChildPid   uint32
+	ChildTgid  uint32
+}
+
+type ProcExecEvent struct {
+	ProcEventHeader
+	Pid  uint32
+	Tgid uint32
+}
+
+// Sent for PROC_EVENT_UID (Real/Effective are uids) and PROC_EVENT_GID
+// (gids).
+type ProcIdEvent struct {
+	ProcEventHeader
+	Pid       uint32
+	Tgid      uint32
+	Real      uint32
+	Effective uint32
+}
+
+type ProcSidEvent struct {
+	ProcEventHeader
+	Pid  uint32
+	Tgid uint32
+}
+
+// TracerPid is 0 when the tracer detached.
+type ProcPtraceEvent struct {
+	ProcEventHeader
+	Pid        uint32
+	Tgid       uint32
+	TracerPid  uint32
+	TracerTgid uint32
+}
+
+type ProcCommEvent struct {
+	ProcEventHeader
+	Pid  uint32
+	Tgid uint32
+	Comm string
+}
+
+type ProcCoredumpEvent struct {
+	ProcEventHeader
+	Pid        uint32
+	Tgid       uint32
+	ParentPid  uint32
+	ParentTgid uint32
+}
+
+type ProcExitEvent struct {
+	ProcEventHeader
+	Pid        uint32
+	Tgid       uint32
+	ExitCode   uint32
+	ExitSignal uint32
+	ParentPid  uint32 /* 0 on kernels before 4.19 */
+	ParentTgid uint32
+}
+
+// Decodes the payload of a connector netlink message (struct cn_msg
+// followed by struct proc_event).
+func ParseProcEvent(data []byte) (ProcEvent, error) {
+	if len(data) < cnMsgLen+procEventHdrLen {
+		return nil, syscall.EINVAL
+	}
+	e := nativeEndian()
+	if e.Uint32(data[0:4]) != CN_IDX_PROC || e.Uint32(data[4:8]) != CN_VAL_PROC {
+		return nil, fmt.Errorf("proc connector: unexpected connector id %d:%d", e.Uint32(data[0:4]), e.Uint32(data[4:8]))
+	}
+	n := int(e.Uint16(data[16:18]))
+	b := data[cnMsgLen:]
+	if n < len(b) {
+		b = b[:n]
+	}
+	if len(b) < procEventHdrLen {
+		return nil, syscall.EINVAL
+	}
+	h := ProcEventHeader{
+		What:      e.Uint32(b[0:4]),
+		CPU:       e.Uint32(b[4:8]),
+		Timestamp: time.Duration(e.Uint64(b[8:16])),
+	}
+	b = b[procEventHdrLen:]
+	u32 := func(i int) uint32 {
+		if len(b) < 4*(i+1) {
+			return 0
+		}
+		return e.Uint32(b[4*i:])
+	}
+
+	if min, ok := procEventDataLen[h.What]; ok && len(b) < min {
+		return nil, fmt.Errorf("proc connector: short %s event", ProcEventName(h.What))
+	}
+
+	switch h.What {
+	case PROC_EVENT_NONE:
+		return &ProcAckEvent{h, u32(0)}, nil
+	case PROC_EVENT_FORK:
+		return &ProcForkEvent{h, u32(0), u32(1), u32(2), u32(3)}, nil
+	case PROC_EVENT_EXEC:
+		return &ProcExecEvent{h, u32(0), u32(1)}, nil
+	case PROC_EVENT_UID, PROC_EVENT_GID:
+		return &ProcIdEvent{h, u32(0), u32(1), u32(2), u32(3)}, nil
+	case PROC_EVENT_SID:
+		return &ProcSidEvent{h, u32(0), u32(1)}, nil
+	case PROC_EVENT_PTRACE:
+		return &ProcPtraceEvent{h, u32(0), u32(1), u32(2), u32(3)}, nil
+	case PROC_EVENT_COMM:
+		comm := b[8 : 8+procCommLen]
+		for i, c := range comm {
+			if c == 0 {
+				comm = comm[:i]
+				break
+			}
+		}
+		return &ProcCommEvent{h, u32(0), u32(1), string(comm)}, nil
+	case PROC_EVENT_COREDUMP:
+		return &ProcCoredumpEvent{h, u32(0), u32(1), u32(2), u32(3)}, nil
+	case PROC_EVENT_EXIT:
+		return &ProcExitEvent{h, u32(0), u32(1), u32(2), u32(3), u32(4), u32(5)}, nil
+	}
+	return nil, fmt.Errorf("proc connector: unknown event %#x", h.What)
+}
+
+// Socket on the process events connector, the NETLINK_CONNECTOR
+// counterpart of NetlinkSocket. Needs CAP_NET_ADMIN.
+type ProcConnectorSocket struct {
+	fd  int
+	lsa syscall.SockaddrNetlink
+}
+
+// Opens the connector socket and joins the proc events multicast group.
+// Call Listen to make the kernel start sending.
+func GetProcConnectorSocket() (*ProcConnectorSocket, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_DGRAM, NETLINK_CONNECTOR)
+	if err != nil {
+		return nil, err
+	}
+	s := &ProcConnectorSocket{
+		fd: fd,
+	}
+	s.lsa.Family = syscall.AF_NETLINK
+	s.lsa.Groups = CN_IDX_PROC
+	s.lsa.Pid = 0 //Let the kernel pick, several sockets may be open
+
+	if err := syscall.Bind(fd, &s.lsa); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	if lsa, err := syscall.Getsockname(fd); err == nil {
+		if v, ok := lsa.(*syscall.SockaddrNetlink); ok {
+			s.lsa.Pid = v.Pid
+		}
+	}
+	//Wake up Receive now and then so streams can notice cancellation
+	tv := syscall.NsecToTimeval(int64(PROC_CONNECTOR_POLL))
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *ProcConnectorSocket) Close() {
+	syscall.Close(s.fd)
+}
+
+func (s *ProcConnectorSocket) setMcast(op uint32) error {
+	e := nativeEndian()
+	b := make([]byte, syscall.NLMSG_HDRLEN+cnMsgLen+4)
+	e.PutUint32(b[0:4], uint32(len(b)))
+	e.PutUint16(b[4:6], syscall.NLMSG_DONE)
+	e.PutUint32(b[12:16], s.lsa.Pid)
+	cn := b[syscall.NLMSG_HDRLEN:]
+	e.PutUint32(cn[0:4], CN_IDX_PROC)
+	e.PutUint32(cn[4:8], CN_VAL_PROC)
+	e.PutUint16(cn[16:18], 4)
+	e.PutUint32(cn[cnMsgLen:], op)
+
+	kernel := &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}
+	return syscall.Sendto(s.fd, b, 0, kernel)
+}
+
+// Asks the kernel to start sending process events.
+func (s *ProcConnectorSocket) Listen() error {
+	return s.setMcast(PROC_CN_MCAST_LISTEN)
+}
+
+// Asks the kernel to stop sending process events.
+func (s *ProcConnectorSocket) Ignore() error {
+	return s.setMcast(PROC_CN_MCAST_IGNORE)
+}
+
+// Reads one datagram and decodes the events in it. Returns EAGAIN when
+// nothing arrived within PROC_CONNECTOR_POLL.
+func (s *ProcConnectorSocket) Receive() ([]ProcEvent, error) {
+	rb := make([]byte, syscall.Getpagesize())
+	nr, from, err := syscall.Recvfrom(s.fd, rb, 0)
+	if err != nil {
+		return nil, err
+	}
+	//Only trust the kernel, anybody may send to the multicast group
+	if sa, ok := from.(*syscall.SockaddrNetlink); !ok || sa.Pid != 0 {
+		return nil, nil
+	}
+	msgs, err := syscall.ParseNetlinkMessage(rb[:nr])
+	if err != nil {
+		return nil, err
+	}
+	var evs []ProcEvent
+	for _, m := range msgs {
+		if m.Header.Type != syscall.NLMSG_DONE {
+			continue
+		}
+		ev, err := ParseProcEvent(m.Data)
+		if err != nil {
+			return evs, err
+		}
+		evs = append(evs, ev)
+	}
+	return evs, nil
+}
+
+// Sends Listen and then every process event to evchan until done fires
+// or is closed. Errors other than the receive timeout go to errchan,
+// repeated ones slow the reads down, EBADF ends the stream. On return the
+// kernel is told to stop and evchan is closed; the socket stays open.
+func ProcEventStream(s *ProcConnectorSocket, evchan chan<- ProcEvent, errchan chan<- error, done <-chan bool) {
+	defer close(evchan)
+	defer s.Ignore()
+	if err := s.Listen(); err != nil {
+		errchan <- err
+		return
+	}
+	var backoff time.Duration
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		evs, err := s.Receive()
+		if err == syscall.EAGAIN || err == syscall.EWOULDBLOCK || err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			select {
+			case errchan <- err:
+			case <-done:
+				return
+			}
+			if err == syscall.EBADF {
+				return
+			}
+			//ENOBUFS means events were dropped, the socket itself is fine
+			if err != syscall.ENOBUFS {
+				if backoff = 2 * backoff; backoff == 0 {
+					backoff = PROC_CONNECTOR_POLL
+				} else if backoff > PROC_CONNECTOR_MAX_BACKOFF {
+					backoff = PROC_CONNECTOR_MAX_BACKOFF
+				}
+				select {
+				case <-time.After(backoff):
+				case <-done:
+					return
+				}
+			}
+		} else {
+			backoff = 0
+		}
+		for _, ev := range evs {
+			if ack, ok := ev.(*ProcAckEvent); ok {
+				if ack.Err != 0 {
+					select {
+					case errchan <- syscall.Errno(ack.Err):
+					case <-done:
+						return
+					}
+				}
+				continue
+			}
+			select {
+			case evchan <- ev:
+			case <-done:
+				return
+			}
+		}
+	}
+}
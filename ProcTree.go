@@ -0,0 +1,412 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	PROC_TREE_DEFAULT_GRACE = 30 * time.Second
+	PROC_TREE_MAX_DEPTH     = 1024 /* guards Ancestors against ppid loops */
+
+	procClockTicks = 100 /* USER_HZ, the unit of starttime in /proc/<pid>/stat */
+)
+
+// What the tracker knows about one process (thread group).
+type ProcInfo struct {
+	Pid       int
+	Ppid      int
+	Exe       string
+	Comm      string
+	Argv      []string
+	Uid       uint32
+	Euid      uint32
+	Auid      uint32
+	StartTime time.Time
+	Cwd       string
+	Exited    time.Time /* zero while running */
+	ExitCode  uint32
+}
+
+// In-memory process table fed by process connector events and audit
+// events. Exited processes are kept for Grace so late audit events can
+// still be attributed. Safe for concurrent use.
+type ProcTree struct {
+	Grace   time.Duration
+	ProcDir string /* "/proc" */
+
+	mu       sync.RWMutex
+	procs    map[int]*ProcInfo
+	bootTime time.Time
+	now      func() time.Time
+}
+
+func NewProcTree(grace time.Duration) *ProcTree {
+	if grace <= 0 {
+		grace = PROC_TREE_DEFAULT_GRACE
+	}
+	t := &ProcTree{
+		Grace:   grace,
+		ProcDir: "/proc",
+		procs:   make(map[int]*ProcInfo),
+		now:     time.Now,
+	}
+	t.bootTime = readProcBootTime(t.ProcDir)
+	return t
+}
+
+// btime from /proc/stat, to turn since-boot timestamps into wall clock.
+func readProcBootTime(procDir string) time.Time {
+	f, err := os.Open(filepath.Join(procDir, "stat"))
+	if err != nil {
+		return time.Time{}
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if v := strings.TrimPrefix(sc.Text(), "btime "); v != sc.Text() {
+			if sec, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64); err == nil {
+				return time.Unix(sec, 0)
+			}
+		}
+	}
+	return time.Time{}
+}
+
+func (t *ProcTree) sinceBoot(d time.Duration) time.Time {
+	if t.bootTime.IsZero() || d == 0 {
+		return t.now()
+	}
+	return t.bootTime.Add(d)
+}
+
+// Reads everything currently running from /proc. Processes that vanish
+// while scanning are skipped.
+func (t *ProcTree) Seed() error {
+	d, err := os.Open(t.ProcDir)
+	if err != nil {
+		return err
+	}
+	names, err := d.Readdirnames(-1)
+	d.Close()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		pid, err := strconv.Atoi(name)
+		if err != nil {
+			continue
+		}
+		p, err := t.readProc(pid)
+		if err != nil {
+			continue
+		}
+		t.mu.Lock()
+		t.procs[pid] = p
+		t.mu.Unlock()
+	}
+	return nil
+}
+
+// Builds a ProcInfo from /proc/<pid>. Only stat is required, exe, cwd and
+// cmdline are unreadable for kernel threads and other users' processes.
+func (t *ProcTree) readProc(pid int) (*ProcInfo, error) {
+	dir := filepath.Join(t.ProcDir, strconv.Itoa(pid))
+	stat, err := os.ReadFile(filepath.Join(dir, "stat"))
+	if err != nil {
+		return nil, err
+	}
+	p := &ProcInfo{Pid: pid, StartTime: t.now(), Auid: AUDIT_UNSET_ID}
+
+	//pid (comm) state ppid ... comm may contain spaces and parentheses
+	open, end := bytes.IndexByte(stat, '('), bytes.LastIndexByte(stat, ')')
+	if open < 0 || end < open {
+		return nil, os.ErrInvalid
+	}
+	p.Comm = string(stat[open+1 : end])
+	rest := strings.Fields(string(stat[end+1:]))
+	if len(rest) > 19 {
+		p.Ppid, _ = strconv.Atoi(rest[1])
+		if ticks, err := strconv.ParseInt(rest[19], 10, 64); err == nil && !t.bootTime.IsZero() {
+			p.StartTime = t.bootTime.Add(time.Duration(ticks) * (time.Second / procClockTicks))
+		}
+	}
+
+	if status, err := os.ReadFile(filepath.Join(dir, "status")); err == nil {
+		for _, line := range strings.Split(string(status), "\n") {
+			if f := strings.Fields(line); len(f) >= 3 && f[0] == "Uid:" {
+				uid, _ := strconv.ParseUint(f[1], 10, 32)
+				euid, _ := strconv.ParseUint(f[2], 10, 32)
+				p.Uid, p.Euid = uint32(uid), uint32(euid)
+			}
+		}
+	}
+	if auid, err := os.ReadFile(filepath.Join(dir, "loginuid")); err == nil {
+		if v, err := strconv.ParseUint(strings.TrimSpace(string(auid)), 10, 32); err == nil {
+			p.Auid = uint32(v)
+		}
+	}
+	p.Exe, _ = os.Readlink(filepath.Join(dir, "exe"))
+	p.Cwd, _ = os.Readlink(filepath.Join(dir, "cwd"))
+	if cmdline, err := os.ReadFile(filepath.Join(dir, "cmdline")); err == nil && len(cmdline) > 0 {
+		p.Argv = strings.Split(strings.TrimSuffix(string(cmdline), "\x00"), "\x00")
+	}
+	return p, nil
+}
+
+// Returns a copy of the entry for pid.
+func (t *ProcTree) Get(pid int) (ProcInfo, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	p, ok := t.procs[pid]
+	if !ok {
+		return ProcInfo{}, false
+	}
+	return *p, true
+}
+
+func (t *ProcTree) Len() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return len(t.procs)
+}
+
+// Parent, grandparent ... of pid, nearest first. Stops at pid 0 or at the
+// first parent the tracker does not know.
+func (t *ProcTree) Ancestors(pid int) []ProcInfo {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	var out []ProcInfo
+	p, ok := t.procs[pid]
+	for ok && p.Ppid > 0 && len(out) < PROC_TREE_MAX_DEPTH {
+		p, ok = t.procs[p.Ppid]
+		if ok {
+			out = append(out, *p)
+		}
+	}
+	return out
+}
+
+// Running and recently exited processes whose parent is pid.
+func (t *ProcTree) Children(pid int) []ProcInfo {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	var out []ProcInfo
+	for _, p := range t.procs {
+		if p.Ppid == pid && p.Pid != pid {
+			out = append(out, *p)
+		}
+	}
+	return out
+}
+
+// Reads /proc/<pid> if the tracker does not know pid yet, without holding
+// the write lock. Returns nil if pid is known or unreadable.
+func (t *ProcTree) prefetch(pid int) *ProcInfo {
+	t.mu.RLock()
+	_, ok := t.procs[pid]
+	t.mu.RUnlock()
+	if ok {
+		return nil
+	}
+	p, _ := t.readProc(pid)
+	return p
+}
+
+// Must be called with mu held. Unknown pids get fetched, the result of
+// prefetch, or a bare entry.
+func (t *ProcTree) lookup(pid int, fetched *ProcInfo) *ProcInfo {
+	if p, ok := t.procs[pid]; ok {
+		return p
+	}
+	p := fetched
+	if p == nil {
+		p = &ProcInfo{Pid: pid, StartTime: t.now(), Auid: AUDIT_UNSET_ID}
+	}
+	t.procs[pid] = p
+	return p
+}
+
+// Applies a process connector event. Threads are not tracked, only
+// thread group leaders. /proc is read before taking the lock.
+func (t *ProcTree) HandleProcEvent(ev ProcEvent) {
+	var fetched *ProcInfo
+	switch e := ev.(type) {
+	case *ProcForkEvent:
+		if e.ChildPid == e.ChildTgid {
+			fetched = t.prefetch(int(e.ParentTgid))
+		}
+	case *ProcExecEvent:
+		if e.Pid == e.Tgid {
+			fetched, _ = t.readProc(int(e.Tgid))
+		}
+	case *ProcIdEvent:
+		if e.What == PROC_EVENT_UID {
+			fetched = t.prefetch(int(e.Tgid))
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch e := ev.(type) {
+	case *ProcForkEvent:
+		if e.ChildPid != e.ChildTgid {
+			return
+		}
+		parent := t.lookup(int(e.ParentTgid), fetched)
+		child := &ProcInfo{
+			Pid:       int(e.ChildTgid),
+			Ppid:      int(e.ParentTgid),
+			Exe:       parent.Exe,
+			Comm:      parent.Comm,
+			Argv:      parent.Argv,
+			Uid:       parent.Uid,
+			Euid:      parent.Euid,
+			Auid:      parent.Auid,
+			Cwd:       parent.Cwd,
+			StartTime: t.sinceBoot(e.Timestamp),
+		}
+		t.procs[child.Pid] = child
+	case *ProcExecEvent:
+		if e.Pid != e.Tgid {
+			return
+		}
+		p := t.lookup(int(e.Tgid), fetched)
+		//Best effort, the audit EXECVE record fills in argv if /proc lost the race
+		if fetched != nil && p != fetched {
+			p.Exe, p.Comm, p.Argv, p.Cwd = fetched.Exe, fetched.Comm, fetched.Argv, fetched.Cwd
+		}
+		p.Exited, p.ExitCode = time.Time{}, 0
+	case *ProcIdEvent:
+		if e.What != PROC_EVENT_UID {
+			return
+		}
+		p := t.lookup(int(e.Tgid), fetched)
+		p.Uid, p.Euid = e.Real, e.Effective
+	case *ProcCommEvent:
+		if p, ok := t.procs[int(e.Tgid)]; ok {
+			p.Comm = e.Comm
+		}
+	case *ProcExitEvent:
+		if e.Pid != e.Tgid {
+			return
+		}
+		if p, ok := t.procs[int(e.Tgid)]; ok {
+			//Compared against audit record times, not when we got to it
+			p.Exited = t.sinceBoot(e.Timestamp)
+			p.ExitCode = e.ExitCode
+		}
+	}
+}
+
+// Updates the process of an audit event from its SYSCALL, EXECVE and CWD
+// records.
+func (t *ProcTree) HandleAuditEvent(ev *AuditEvent) {
+	sc := ev.Record(AUDIT_SYSCALL)
+	if sc == nil {
+		return
+	}
+	pid, err := strconv.Atoi(sc.Value("pid"))
+	if err != nil || pid <= 0 {
+		return
+	}
+	num := func(name string) (uint32, bool) {
+		v, err := strconv.ParseUint(sc.Value(name), 10, 32)
+		return uint32(v), err == nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p, ok := t.procs[pid]
+	//An event newer than the exit of the entry is from a process that reused the pid
+	if !ok || (!p.Exited.IsZero() && ev.Timestamp.After(p.Exited)) {
+		p = &ProcInfo{Pid: pid, StartTime: ev.Timestamp, Auid: AUDIT_UNSET_ID}
+		t.procs[pid] = p
+	}
+	if ppid, err := strconv.Atoi(sc.Value("ppid")); err == nil {
+		p.Ppid = ppid
+	}
+	if v, ok := num("uid"); ok {
+		p.Uid = v
+	}
+	if v, ok := num("euid"); ok {
+		p.Euid = v
+	}
+	if v, ok := num("auid"); ok {
+		p.Auid = v
+	}
+	if exe := sc.Value("exe"); exe != "" {
+		p.Exe = exe
+	}
+	if comm := sc.Value("comm"); comm != "" {
+		p.Comm = comm
+	}
+	if ev.Argv != nil {
+		p.Argv = ev.Argv
+	}
+	if r := ev.Record(AUDIT_CWD); r != nil {
+		p.Cwd = r.Value("cwd")
+	}
+}
+
+// Drops processes that exited more than Grace ago.
+func (t *ProcTree) Expire() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	cutoff := t.now().Add(-t.Grace)
+	n := 0
+	for pid, p := range t.procs {
+		if !p.Exited.IsZero() && p.Exited.Before(cutoff) {
+			delete(t.procs, pid)
+			n++
+		}
+	}
+	return n
+}
+
+// How often ProcTreeStream calls Expire.
+func (t *ProcTree) expireInterval() time.Duration {
+	if d := t.Grace / 2; d > 0 {
+		return d
+	}
+	return 1
+}
+
+// Keeps t up to date from both streams. Audit events are forwarded to out
+// after the tree saw them, so consumers can look up their process. Either
+// channel may be nil. Returns, closing out, once both inputs are closed.
+func ProcTreeStream(t *ProcTree, procchan <-chan ProcEvent, evchan <-chan *AuditEvent, out chan<- *AuditEvent) {
+	if out != nil {
+		defer close(out)
+	}
+	ticker := time.NewTicker(t.expireInterval())
+	defer ticker.Stop()
+
+	for procchan != nil || evchan != nil {
+		select {
+		case ev, ok := <-procchan:
+			if !ok {
+				procchan = nil
+				continue
+			}
+			t.HandleProcEvent(ev)
+		case ev, ok := <-evchan:
+			if !ok {
+				evchan = nil
+				continue
+			}
+			t.HandleAuditEvent(ev)
+			if out != nil {
+				out <- ev
+			}
+		case <-ticker.C:
+			t.Expire()
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// A tree on an empty /proc whose boot time and clock the test controls.
+func newProcTestTree(t *testing.T) (*ProcTree, time.Time) {
+	tr := NewProcTree(time.Minute)
+	tr.ProcDir = t.TempDir()
+	boot := time.Unix(1700000000, 0)
+	tr.bootTime = boot
+	tr.now = func() time.Time { return boot.Add(time.Hour) }
+	return tr, boot
+}
+
+func procTestSyscallEvent(t *testing.T, when time.Time, pid, comm string) *AuditEvent {
+	r := parseAuditTestRecord(t, AUDIT_SYSCALL, "audit(0.000:1): arch=c000003e syscall=59 ppid=1 pid="+pid+" auid=1000 uid=0 euid=0 comm=\""+comm+"\"")
+	r.Timestamp = when
+	return &AuditEvent{Serial: 1, Timestamp: when, Records: []*AuditRecord{r}}
+}
+
+func TestProcTreeFork(t *testing.T) {
+	tr, boot := newProcTestTree(t)
+	tr.HandleAuditEvent(procTestSyscallEvent(t, boot.Add(time.Second), "10", "sh"))
+	tr.HandleProcEvent(&ProcForkEvent{ProcEventHeader: ProcEventHeader{Timestamp: 2 * time.Second}, ParentPid: 10, ParentTgid: 10, ChildPid: 11, ChildTgid: 11})
+	//Threads are not tracked
+	tr.HandleProcEvent(&ProcForkEvent{ParentPid: 10, ParentTgid: 10, ChildPid: 12, ChildTgid: 10})
+
+	p, ok := tr.Get(11)
+	if !ok || p.Ppid != 10 || p.Comm != "sh" || p.Auid != 1000 || !p.StartTime.Equal(boot.Add(2*time.Second)) {
+		t.Fatalf("got %+v", p)
+	}
+	if _, ok := tr.Get(12); ok {
+		t.Fatal("thread tracked as a process")
+	}
+	if a := tr.Ancestors(11); len(a) != 1 || a[0].Pid != 10 {
+		t.Fatalf("got ancestors %+v", a)
+	}
+	if c := tr.Children(10); len(c) != 1 || c[0].Pid != 11 {
+		t.Fatalf("got children %+v", c)
+	}
+}
+
+// Exits are stamped with the connector time, so a late audit event from
+// before the exit still lands on the old entry and a newer one replaces it.
+func TestProcTreeExit(t *testing.T) {
+	tr, boot := newProcTestTree(t)
+	tr.HandleAuditEvent(procTestSyscallEvent(t, boot.Add(time.Second), "10", "old"))
+	tr.HandleProcEvent(&ProcExitEvent{ProcEventHeader: ProcEventHeader{Timestamp: 5 * time.Second}, Pid: 10, Tgid: 10, ExitCode: 256})
+	p, _ := tr.Get(10)
+	if !p.Exited.Equal(boot.Add(5*time.Second)) || p.ExitCode != 256 {
+		t.Fatalf("got exited %v code %d", p.Exited, p.ExitCode)
+	}
+
+	tr.HandleAuditEvent(procTestSyscallEvent(t, boot.Add(4*time.Second), "10", "late"))
+	if p, _ := tr.Get(10); p.Comm != "late" || p.Exited.IsZero() {
+		t.Fatalf("late event: got %+v", p)
+	}
+	tr.HandleAuditEvent(procTestSyscallEvent(t, boot.Add(6*time.Second), "10", "new"))
+	if p, _ := tr.Get(10); p.Comm != "new" || !p.Exited.IsZero() || !p.StartTime.Equal(boot.Add(6*time.Second)) {
+		t.Fatalf("reused pid: got %+v", p)
+	}
+
+	tr.HandleProcEvent(&ProcExitEvent{ProcEventHeader: ProcEventHeader{Timestamp: 7 * time.Second}, Pid: 10, Tgid: 10})
+	if n := tr.Expire(); n != 1 || tr.Len() != 0 {
+		t.Fatalf("expired %d, %d left", n, tr.Len())
+	}
+}
+
+// An exported Grace too small for a ticker must not panic the stream.
+func TestProcTreeStreamSmallGrace(t *testing.T) {
+	tr, boot := newProcTestTree(t)
+	tr.Grace = 1
+	evchan := make(chan *AuditEvent, 1)
+	out := make(chan *AuditEvent, 1)
+	evchan <- procTestSyscallEvent(t, boot, "10", "sh")
+	close(evchan)
+	ProcTreeStream(tr, nil, evchan, out)
+	if ev, ok := <-out; !ok || ev.Serial != 1 {
+		t.Fatal("event not forwarded")
+	}
+	if _, ok := <-out; ok {
+		t.Fatal("out not closed")
+	}
+}